@@ -0,0 +1,271 @@
+// Package blob stores binary values, such as screenshots or log bundles,
+// under an id in the tenant of the request. Blobs are kept in a directory
+// whatever the storage backend of items is, and are streamed to and from it
+// rather than held in memory.
+//
+// Every blob is a pair of files named after the SHA-256 of its id: the
+// content and its metadata as JSON. Both are written to temporary files and
+// renamed into place, so readers see either the old or the new blob.
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// DefaultContentType is the type of blobs uploaded without one.
+const DefaultContentType = "application/octet-stream"
+
+// Store keeps blobs in a directory, with one subdirectory per tenant.
+type Store struct {
+	dir string
+	// mu makes replacing the content and metadata of a blob atomic for
+	// readers, which hold it while they open both.
+	mu sync.RWMutex
+}
+
+// NewStore returns a store of blobs in dir, which is created if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Ping reports an error if no blob can be written to the store, such as when
+// its disk is full or read-only.
+func (s *Store) Ping(ctx context.Context) error {
+	f, err := os.CreateTemp(s.dir, ".ping-*")
+	if err != nil {
+		return fmt.Errorf("blob directory is not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// paths returns the content and metadata files of id in the tenant of ctx.
+func (s *Store) paths(ctx context.Context, id string) (dir string, content string, meta string) {
+	dir = filepath.Join(s.dir, tenant.FromContext(ctx))
+	sum := sha256.Sum256([]byte(id))
+	name := hex.EncodeToString(sum[:])
+	return dir, filepath.Join(dir, name+".blob"), filepath.Join(dir, name+".json")
+}
+
+// Put stores the content read from r under id, replacing any blob there.
+// The body is hashed while it is written, and discarded if expectedSHA256 is
+// set and differs. Errors of r, such as *http.MaxBytesError, are returned
+// wrapped, while failures to write the content are internal errors.
+func (s *Store) Put(ctx context.Context, id string, contentType string, r io.Reader, expectedSHA256 []byte) (model.Blob, error) {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "PutBlob")
+	defer span.End()
+	span.SetAttributes(attribute.String("blob.id", id), attribute.String("blob.content_type", contentType))
+
+	dir, contentPath, metaPath := s.paths(ctx, id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		span.SetStatus(codes.Error, "failed to create tenant directory")
+		return model.Blob{}, errs.NewInternal(fmt.Errorf("failed to create blob directory: %w", err))
+	}
+
+	tmp, err := os.CreateTemp(dir, "put-*.tmp")
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to create temporary file")
+		return model.Blob{}, errs.NewInternal(fmt.Errorf("failed to create blob file: %w", err))
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	w := &errWriter{w: io.MultiWriter(tmp, hash)}
+	size, err := io.Copy(w, r)
+	span.SetAttributes(attribute.Int64("blob.bytes_in", size))
+	if w.err != nil {
+		span.RecordError(w.err)
+		span.SetStatus(codes.Error, "failed to write blob")
+		return model.Blob{}, errs.NewInternal(fmt.Errorf("failed to write blob %s: %w", id, w.err))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to read blob")
+		return model.Blob{}, fmt.Errorf("failed to read blob %s: %w", id, err)
+	}
+	sum := hash.Sum(nil)
+	if expectedSHA256 != nil && !bytes.Equal(sum, expectedSHA256) {
+		span.SetStatus(codes.Error, "checksum mismatch")
+		return model.Blob{}, errs.NewInvalidInput(fmt.Errorf("blob %s has SHA-256 %x, expected %x", id, sum, expectedSHA256))
+	}
+	if err := tmp.Sync(); err != nil {
+		span.SetStatus(codes.Error, "failed to sync blob")
+		return model.Blob{}, errs.NewInternal(fmt.Errorf("failed to sync blob %s: %w", id, err))
+	}
+
+	now := time.Now().UTC()
+	blob := model.Blob{
+		Tenant:      tenant.FromContext(ctx),
+		ID:          id,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(sum),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if current, err := readMeta(metaPath); err == nil {
+		blob.CreatedAt = current.CreatedAt
+	}
+	metaTmp, err := writeMetaTemp(dir, blob)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to write metadata")
+		return model.Blob{}, errs.NewInternal(fmt.Errorf("failed to write metadata of blob %s: %w", id, err))
+	}
+	defer os.Remove(metaTmp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Rename(tmp.Name(), contentPath); err != nil {
+		span.SetStatus(codes.Error, "failed to rename blob")
+		return model.Blob{}, errs.NewInternal(fmt.Errorf("failed to store blob %s: %w", id, err))
+	}
+	if err := os.Rename(metaTmp, metaPath); err != nil {
+		span.SetStatus(codes.Error, "failed to rename metadata")
+		return model.Blob{}, errs.NewInternal(fmt.Errorf("failed to store metadata of blob %s: %w", id, err))
+	}
+
+	span.SetAttributes(attribute.Int64("blob.size", size), attribute.String("blob.sha256", blob.SHA256))
+	span.SetStatus(codes.Ok, "success")
+	return blob, nil
+}
+
+// Open returns the metadata and content of id. The caller must close the
+// content, which stays readable if the blob is replaced or deleted meanwhile.
+func (s *Store) Open(ctx context.Context, id string) (model.Blob, *os.File, error) {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "OpenBlob")
+	defer span.End()
+	span.SetAttributes(attribute.String("blob.id", id))
+
+	_, contentPath, metaPath := s.paths(ctx, id)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob, err := readMeta(metaPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		span.SetStatus(codes.Error, "blob not found")
+		return model.Blob{}, nil, errs.NewNotFound(fmt.Errorf("blob with ID %s not found", id))
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to read metadata")
+		return model.Blob{}, nil, errs.NewInternal(fmt.Errorf("failed to read metadata of blob %s: %w", id, err))
+	}
+	f, err := os.Open(contentPath)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to open blob")
+		return model.Blob{}, nil, errs.NewInternal(fmt.Errorf("failed to open blob %s: %w", id, err))
+	}
+
+	span.SetAttributes(attribute.Int64("blob.size", blob.Size))
+	span.SetStatus(codes.Ok, "success")
+	return blob, f, nil
+}
+
+func (s *Store) Delete(ctx context.Context, id string) error {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "DeleteBlob")
+	defer span.End()
+	span.SetAttributes(attribute.String("blob.id", id))
+
+	_, contentPath, metaPath := s.paths(ctx, id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(metaPath); errors.Is(err, fs.ErrNotExist) {
+		span.SetStatus(codes.Error, "blob not found")
+		return errs.NewNotFound(fmt.Errorf("blob with ID %s not found", id))
+	} else if err != nil {
+		span.SetStatus(codes.Error, "failed to delete metadata")
+		return errs.NewInternal(fmt.Errorf("failed to delete blob %s: %w", id, err))
+	}
+	if err := os.Remove(contentPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		span.SetStatus(codes.Error, "failed to delete blob")
+		return errs.NewInternal(fmt.Errorf("failed to delete blob %s: %w", id, err))
+	}
+	span.SetStatus(codes.Ok, "success")
+	return nil
+}
+
+// DeleteTenant removes every blob of the tenant name.
+func (s *Store) DeleteTenant(ctx context.Context, name string) error {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "DeleteTenantBlobs")
+	defer span.End()
+	span.SetAttributes(attribute.String("blob.tenant", name))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.RemoveAll(filepath.Join(s.dir, name)); err != nil {
+		span.SetStatus(codes.Error, "failed to delete blobs")
+		return errs.NewInternal(fmt.Errorf("failed to delete blobs of tenant %s: %w", name, err))
+	}
+	span.SetStatus(codes.Ok, "success")
+	return nil
+}
+
+func readMeta(path string) (model.Blob, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return model.Blob{}, err
+	}
+	var blob model.Blob
+	err = json.Unmarshal(b, &blob)
+	return blob, err
+}
+
+// writeMetaTemp writes blob to a temporary file in dir and returns its name.
+func writeMetaTemp(dir string, blob model.Blob) (string, error) {
+	f, err := os.CreateTemp(dir, "meta-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(blob); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// errWriter records the first error of w, so that failures to write can be
+// told apart from failures to read in io.Copy.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,123 @@
+package blob_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"simple_lgtm/internal/blob"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := blob.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	t.Run("PutOpen", func(t *testing.T) {
+		sum := sha256.Sum256([]byte("hello, blob"))
+		b, err := store.Put(ctx, "a/b", "text/plain", strings.NewReader("hello, blob"), sum[:])
+		require.NoError(t, err)
+		assert.Equal(t, int64(11), b.Size)
+		assert.Equal(t, hex.EncodeToString(sum[:]), b.SHA256)
+		assert.Equal(t, tenant.Default, b.Tenant)
+
+		opened, f, err := store.Open(ctx, "a/b")
+		require.NoError(t, err)
+		defer f.Close()
+		assert.Equal(t, b, opened)
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "hello, blob", string(content))
+	})
+
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		sum := sha256.Sum256([]byte("other"))
+		_, err := store.Put(ctx, "mismatch", "text/plain", strings.NewReader("content"), sum[:])
+		assert.True(t, errs.IsInvalidInput(err))
+
+		_, _, err = store.Open(ctx, "mismatch")
+		assert.True(t, errs.IsNotFound(err))
+	})
+
+	t.Run("ReaderError", func(t *testing.T) {
+		_, err := store.Put(ctx, "broken", "text/plain", io.MultiReader(strings.NewReader("partial"), errReader{}), nil)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.False(t, errs.IsInternal(err))
+
+		_, _, err = store.Open(ctx, "broken")
+		assert.True(t, errs.IsNotFound(err))
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		first, err := store.Put(ctx, "replace", "text/plain", strings.NewReader("one"), nil)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		second, err := store.Put(ctx, "replace", "application/json", strings.NewReader(`"two"`), nil)
+		require.NoError(t, err)
+		assert.Equal(t, first.CreatedAt, second.CreatedAt)
+		assert.True(t, second.UpdatedAt.After(first.UpdatedAt))
+		assert.Equal(t, "application/json", second.ContentType)
+	})
+
+	t.Run("TenantIsolation", func(t *testing.T) {
+		acme := tenant.WithTenant(ctx, "acme")
+		_, err := store.Put(acme, "shared", "text/plain", strings.NewReader("acme"), nil)
+		require.NoError(t, err)
+
+		_, _, err = store.Open(ctx, "shared")
+		assert.True(t, errs.IsNotFound(err))
+		b, f, err := store.Open(acme, "shared")
+		require.NoError(t, err)
+		f.Close()
+		assert.Equal(t, "acme", b.Tenant)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, err := store.Put(ctx, "delete", "text/plain", strings.NewReader("gone"), nil)
+		require.NoError(t, err)
+		_, f, err := store.Open(ctx, "delete")
+		require.NoError(t, err)
+		defer f.Close()
+
+		require.NoError(t, store.Delete(ctx, "delete"))
+		assert.True(t, errs.IsNotFound(store.Delete(ctx, "delete")))
+		_, _, err = store.Open(ctx, "delete")
+		assert.True(t, errs.IsNotFound(err))
+
+		// Content that is already open stays readable.
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "gone", string(content))
+	})
+
+	t.Run("DeleteTenant", func(t *testing.T) {
+		doomed := tenant.WithTenant(ctx, "doomed")
+		_, err := store.Put(doomed, "a", "text/plain", strings.NewReader("a"), nil)
+		require.NoError(t, err)
+		_, err = store.Put(ctx, "kept", "text/plain", strings.NewReader("kept"), nil)
+		require.NoError(t, err)
+
+		require.NoError(t, store.DeleteTenant(ctx, "doomed"))
+		_, _, err = store.Open(doomed, "a")
+		assert.True(t, errs.IsNotFound(err))
+		_, f, err := store.Open(ctx, "kept")
+		require.NoError(t, err)
+		f.Close()
+
+		// A tenant without blobs has nothing to delete.
+		assert.NoError(t, store.DeleteTenant(ctx, "empty"))
+	})
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
@@ -3,12 +3,60 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
 	AppName                  string
 	Port                     int
 	OtelExporterOLTPEndpoint string
+	StorageBackend           string
+	WALPath                  string
+	WALSyncBatchSize         int
+	WALSyncInterval          time.Duration
+	WALCompactInterval       time.Duration
+	DBDialect                string
+	DBDSN                    string
+	DBMaxOpenConns           int
+	DBMaxIdleConns           int
+	TrashRetention           time.Duration
+	PurgeInterval            time.Duration
+	ExpirySweepInterval      time.Duration
+	SnapshotDir              string
+	SnapshotInterval         time.Duration
+	SnapshotRetain           int
+	BatchMaxSize             int
+	CacheSize                int
+	CacheTTL                 time.Duration
+	MaxTenants               int
+	WatchBufferSize          int
+	WebhookWorkers           int
+	WebhookQueueSize         int
+	WebhookMaxAttempts       int
+	WebhookBackoff           time.Duration
+	WebhookTimeout           time.Duration
+	SchemaFile               string
+	BlobDir                  string
+	BlobMaxSize              int64
+	PatchMaxSize             int64
+	HTTPReadTimeout          time.Duration
+	HTTPReadHeaderTimeout    time.Duration
+	HTTPWriteTimeout         time.Duration
+	HTTPIdleTimeout          time.Duration
+	HTTPMaxHeaderBytes       int
+	ShutdownTimeout          time.Duration
+	ShutdownDrainDelay       time.Duration
+	HealthCheckInterval      time.Duration
+	HealthCheckTimeout       time.Duration
+	// MetricsExporter is "prometheus" to serve the metrics on /metrics for
+	// scraping or "otlp" to push them to the OTLP endpoint.
+	MetricsExporter string
+	// MetricsOTLPProtocol is "http/protobuf" or "grpc".
+	MetricsOTLPProtocol   string
+	MetricsExportInterval time.Duration
+	// MetricsTemporality is "cumulative" or "delta" for the counters and
+	// histograms pushed over OTLP.
+	MetricsTemporality string
 }
 
 func Load() *Config {
@@ -27,9 +75,264 @@ func Load() *Config {
 		otelExporterOLTPEndpoint = "http://localhost:4318"
 	}
 
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "memory"
+	}
+
+	walPath := os.Getenv("WAL_PATH")
+	if walPath == "" {
+		walPath = "data/wal.log"
+	}
+
+	walSyncBatchSize, err := strconv.Atoi(os.Getenv("WAL_SYNC_BATCH_SIZE"))
+	if err != nil {
+		walSyncBatchSize = 32
+	}
+
+	walSyncInterval, err := time.ParseDuration(os.Getenv("WAL_SYNC_INTERVAL"))
+	if err != nil {
+		walSyncInterval = 10 * time.Millisecond
+	}
+
+	walCompactInterval, err := time.ParseDuration(os.Getenv("WAL_COMPACT_INTERVAL"))
+	if err != nil {
+		walCompactInterval = 5 * time.Minute
+	}
+
+	dbDialect := os.Getenv("DB_DIALECT")
+	if dbDialect == "" {
+		dbDialect = "sqlite"
+	}
+
+	dbDSN := os.Getenv("DB_DSN")
+	if dbDSN == "" {
+		dbDSN = "file:data/app.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	}
+
+	// SQLite allows a single writer, so more than one connection only turns
+	// concurrent writes into SQLITE_BUSY errors.
+	dbMaxOpenConns, err := strconv.Atoi(os.Getenv("DB_MAX_OPEN_CONNS"))
+	if err != nil {
+		dbMaxOpenConns = 10
+		if dbDialect == "sqlite" {
+			dbMaxOpenConns = 1
+		}
+	}
+
+	dbMaxIdleConns, err := strconv.Atoi(os.Getenv("DB_MAX_IDLE_CONNS"))
+	if err != nil {
+		dbMaxIdleConns = min(5, dbMaxOpenConns)
+	}
+
+	trashRetention, err := time.ParseDuration(os.Getenv("TRASH_RETENTION"))
+	if err != nil {
+		trashRetention = 7 * 24 * time.Hour
+	}
+
+	purgeInterval, err := time.ParseDuration(os.Getenv("PURGE_INTERVAL"))
+	if err != nil {
+		purgeInterval = time.Hour
+	}
+
+	expirySweepInterval, err := time.ParseDuration(os.Getenv("EXPIRY_SWEEP_INTERVAL"))
+	if err != nil {
+		expirySweepInterval = time.Minute
+	}
+
+	snapshotDir := os.Getenv("SNAPSHOT_DIR")
+	if snapshotDir == "" {
+		snapshotDir = "data/snapshots"
+	}
+
+	snapshotInterval, err := time.ParseDuration(os.Getenv("SNAPSHOT_INTERVAL"))
+	if err != nil {
+		snapshotInterval = 5 * time.Minute
+	}
+
+	snapshotRetain, err := strconv.Atoi(os.Getenv("SNAPSHOT_RETAIN"))
+	if err != nil {
+		snapshotRetain = 3
+	}
+
+	batchMaxSize, err := strconv.Atoi(os.Getenv("BATCH_MAX_SIZE"))
+	if err != nil || batchMaxSize < 1 {
+		batchMaxSize = 100
+	}
+
+	cacheSize, err := strconv.Atoi(os.Getenv("CACHE_SIZE"))
+	if err != nil {
+		cacheSize = 0 // Disabled
+	}
+
+	cacheTTL, err := time.ParseDuration(os.Getenv("CACHE_TTL"))
+	if err != nil {
+		cacheTTL = 30 * time.Second
+	}
+
+	maxTenants, err := strconv.Atoi(os.Getenv("MAX_TENANTS"))
+	if err != nil || maxTenants < 1 {
+		maxTenants = 100
+	}
+
+	watchBufferSize, err := strconv.Atoi(os.Getenv("WATCH_BUFFER_SIZE"))
+	if err != nil || watchBufferSize < 1 {
+		watchBufferSize = 1024
+	}
+
+	webhookWorkers, err := strconv.Atoi(os.Getenv("WEBHOOK_WORKERS"))
+	if err != nil || webhookWorkers < 1 {
+		webhookWorkers = 4
+	}
+
+	webhookQueueSize, err := strconv.Atoi(os.Getenv("WEBHOOK_QUEUE_SIZE"))
+	if err != nil || webhookQueueSize < 0 {
+		webhookQueueSize = 1000
+	}
+
+	webhookMaxAttempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	if err != nil || webhookMaxAttempts < 1 {
+		webhookMaxAttempts = 5
+	}
+
+	webhookBackoff, err := time.ParseDuration(os.Getenv("WEBHOOK_BACKOFF"))
+	if err != nil {
+		webhookBackoff = time.Second
+	}
+
+	webhookTimeout, err := time.ParseDuration(os.Getenv("WEBHOOK_TIMEOUT"))
+	if err != nil {
+		webhookTimeout = 10 * time.Second
+	}
+
+	// Without a schema file, schemas are only registered through /schemas.
+	schemaFile := os.Getenv("SCHEMA_FILE")
+
+	blobDir := os.Getenv("BLOB_DIR")
+	if blobDir == "" {
+		blobDir = "data/blobs"
+	}
+
+	blobMaxSize, err := strconv.ParseInt(os.Getenv("BLOB_MAX_SIZE"), 10, 64)
+	if err != nil || blobMaxSize < 1 {
+		blobMaxSize = 100 << 20
+	}
+
+	patchMaxSize, err := strconv.ParseInt(os.Getenv("PATCH_MAX_SIZE"), 10, 64)
+	if err != nil || patchMaxSize < 1 {
+		patchMaxSize = 1 << 20
+	}
+
+	httpReadTimeout, err := time.ParseDuration(os.Getenv("HTTP_READ_TIMEOUT"))
+	if err != nil {
+		httpReadTimeout = 60 * time.Second
+	}
+
+	httpReadHeaderTimeout, err := time.ParseDuration(os.Getenv("HTTP_READ_HEADER_TIMEOUT"))
+	if err != nil {
+		httpReadHeaderTimeout = 10 * time.Second
+	}
+
+	httpWriteTimeout, err := time.ParseDuration(os.Getenv("HTTP_WRITE_TIMEOUT"))
+	if err != nil {
+		httpWriteTimeout = 60 * time.Second
+	}
+
+	httpIdleTimeout, err := time.ParseDuration(os.Getenv("HTTP_IDLE_TIMEOUT"))
+	if err != nil {
+		httpIdleTimeout = 120 * time.Second
+	}
+
+	httpMaxHeaderBytes, err := strconv.Atoi(os.Getenv("HTTP_MAX_HEADER_BYTES"))
+	if err != nil || httpMaxHeaderBytes < 1 {
+		httpMaxHeaderBytes = 1 << 20
+	}
+
+	shutdownTimeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || shutdownTimeout <= 0 {
+		shutdownTimeout = 30 * time.Second
+	}
+
+	shutdownDrainDelay, err := time.ParseDuration(os.Getenv("SHUTDOWN_DRAIN_DELAY"))
+	if err != nil || shutdownDrainDelay < 0 {
+		shutdownDrainDelay = 0
+	}
+
+	healthCheckInterval, err := time.ParseDuration(os.Getenv("HEALTH_CHECK_INTERVAL"))
+	if err != nil {
+		healthCheckInterval = 15 * time.Second
+	}
+
+	healthCheckTimeout, err := time.ParseDuration(os.Getenv("HEALTH_CHECK_TIMEOUT"))
+	if err != nil || healthCheckTimeout <= 0 {
+		healthCheckTimeout = 2 * time.Second
+	}
+
+	metricsExporter := os.Getenv("METRICS_EXPORTER")
+	if metricsExporter == "" {
+		metricsExporter = "prometheus"
+	}
+
+	metricsOTLPProtocol := os.Getenv("METRICS_OTLP_PROTOCOL")
+	if metricsOTLPProtocol == "" {
+		metricsOTLPProtocol = "http/protobuf"
+	}
+
+	metricsExportInterval, err := time.ParseDuration(os.Getenv("METRICS_EXPORT_INTERVAL"))
+	if err != nil || metricsExportInterval <= 0 {
+		metricsExportInterval = 15 * time.Second
+	}
+
+	metricsTemporality := os.Getenv("METRICS_TEMPORALITY")
+	if metricsTemporality == "" {
+		metricsTemporality = "cumulative"
+	}
+
 	return &Config{
 		AppName:                  appName,
 		Port:                     port,
 		OtelExporterOLTPEndpoint: otelExporterOLTPEndpoint,
+		StorageBackend:           storageBackend,
+		WALPath:                  walPath,
+		WALSyncBatchSize:         walSyncBatchSize,
+		WALSyncInterval:          walSyncInterval,
+		WALCompactInterval:       walCompactInterval,
+		DBDialect:                dbDialect,
+		DBDSN:                    dbDSN,
+		DBMaxOpenConns:           dbMaxOpenConns,
+		DBMaxIdleConns:           dbMaxIdleConns,
+		TrashRetention:           trashRetention,
+		PurgeInterval:            purgeInterval,
+		ExpirySweepInterval:      expirySweepInterval,
+		SnapshotDir:              snapshotDir,
+		SnapshotInterval:         snapshotInterval,
+		SnapshotRetain:           snapshotRetain,
+		BatchMaxSize:             batchMaxSize,
+		CacheSize:                cacheSize,
+		CacheTTL:                 cacheTTL,
+		MaxTenants:               maxTenants,
+		WatchBufferSize:          watchBufferSize,
+		WebhookWorkers:           webhookWorkers,
+		WebhookQueueSize:         webhookQueueSize,
+		WebhookMaxAttempts:       webhookMaxAttempts,
+		WebhookBackoff:           webhookBackoff,
+		WebhookTimeout:           webhookTimeout,
+		SchemaFile:               schemaFile,
+		BlobDir:                  blobDir,
+		BlobMaxSize:              blobMaxSize,
+		PatchMaxSize:             patchMaxSize,
+		HTTPReadTimeout:          httpReadTimeout,
+		HTTPReadHeaderTimeout:    httpReadHeaderTimeout,
+		HTTPWriteTimeout:         httpWriteTimeout,
+		HTTPIdleTimeout:          httpIdleTimeout,
+		HTTPMaxHeaderBytes:       httpMaxHeaderBytes,
+		ShutdownTimeout:          shutdownTimeout,
+		ShutdownDrainDelay:       shutdownDrainDelay,
+		HealthCheckInterval:      healthCheckInterval,
+		HealthCheckTimeout:       healthCheckTimeout,
+		MetricsExporter:          metricsExporter,
+		MetricsOTLPProtocol:      metricsOTLPProtocol,
+		MetricsExportInterval:    metricsExportInterval,
+		MetricsTemporality:       metricsTemporality,
 	}
 }
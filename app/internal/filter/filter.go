@@ -0,0 +1,119 @@
+// Package filter implements the expression language of the filter query
+// parameter of GET /data, for example:
+//
+//	id ^= "order-" AND NOT (value *= "error" OR value ~ "^warn")
+//
+// A condition compares a field (id or value) to a double-quoted string with
+// one of the operators = (equal), != (not equal), ^= (prefix), *= (substring)
+// or ~ (regular expression). Conditions are combined with AND, OR, NOT and
+// parentheses. Keywords are case-insensitive and AND binds tighter than OR.
+// The value field is compared by its text, see model.Value.Text.
+package filter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"simple_lgtm/internal/model"
+)
+
+type Field string
+
+const (
+	FieldID    Field = "id"
+	FieldValue Field = "value"
+)
+
+type Op string
+
+const (
+	OpEqual    Op = "="
+	OpNotEqual Op = "!="
+	OpPrefix   Op = "^="
+	OpContains Op = "*="
+	OpRegex    Op = "~"
+)
+
+// Expr is a node of a parsed filter expression.
+type Expr interface {
+	// Match reports whether item satisfies the expression.
+	Match(item model.DataItem) bool
+	// String returns the canonical form of the expression.
+	String() string
+}
+
+type And struct {
+	Left  Expr
+	Right Expr
+}
+
+func (e *And) Match(item model.DataItem) bool {
+	return e.Left.Match(item) && e.Right.Match(item)
+}
+
+func (e *And) String() string {
+	return "(" + e.Left.String() + " AND " + e.Right.String() + ")"
+}
+
+type Or struct {
+	Left  Expr
+	Right Expr
+}
+
+func (e *Or) Match(item model.DataItem) bool {
+	return e.Left.Match(item) || e.Right.Match(item)
+}
+
+func (e *Or) String() string {
+	return "(" + e.Left.String() + " OR " + e.Right.String() + ")"
+}
+
+type Not struct {
+	Expr Expr
+}
+
+func (e *Not) Match(item model.DataItem) bool {
+	return !e.Expr.Match(item)
+}
+
+func (e *Not) String() string {
+	return "NOT " + e.Expr.String()
+}
+
+type Condition struct {
+	Field Field
+	Op    Op
+	Value string
+	// Regex is the compiled Value of an OpRegex condition.
+	Regex *regexp.Regexp
+}
+
+func (c *Condition) Match(item model.DataItem) bool {
+	var s string
+	switch c.Field {
+	case FieldID:
+		s = item.ID
+	case FieldValue:
+		s = item.Value.Text()
+	}
+
+	switch c.Op {
+	case OpEqual:
+		return s == c.Value
+	case OpNotEqual:
+		return s != c.Value
+	case OpPrefix:
+		return strings.HasPrefix(s, c.Value)
+	case OpContains:
+		return strings.Contains(s, c.Value)
+	case OpRegex:
+		return c.Regex.MatchString(s)
+	default:
+		return false
+	}
+}
+
+func (c *Condition) String() string {
+	return string(c.Field) + " " + string(c.Op) + " " + strconv.Quote(c.Value)
+}
@@ -0,0 +1,79 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+
+	"simple_lgtm/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("Precedence", func(t *testing.T) {
+		expr, err := Parse(`id = "a" OR id ^= "b" and NOT value *= "c"`)
+		assert.NoError(t, err)
+		assert.Equal(t, `(id = "a" OR (id ^= "b" AND NOT value *= "c"))`, expr.String())
+	})
+
+	t.Run("Parentheses", func(t *testing.T) {
+		expr, err := Parse(`(id = "a" OR id != "b") AND value ~ "^x"`)
+		assert.NoError(t, err)
+		assert.Equal(t, `((id = "a" OR id != "b") AND value ~ "^x")`, expr.String())
+	})
+
+	t.Run("Escapes", func(t *testing.T) {
+		expr, err := Parse(`value = "say \"hi\" \\o/"`)
+		assert.NoError(t, err)
+		assert.Equal(t, `say "hi" \o/`, expr.(*Condition).Value)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			input string
+			pos   int
+		}{
+			{``, 1},
+			{`name = "a"`, 1},
+			{`id == "a"`, 5},
+			{`id = a`, 6},
+			{`id = "a`, 6},
+			{`id = "a" AND`, 13},
+			{`(id = "a"`, 10},
+			{`id = "a")`, 9},
+			{`id ! "a"`, 4},
+			{`value ~ "("`, 9},
+			{`id = "a" $`, 10},
+		} {
+			_, err := Parse(tc.input)
+			var syntaxErr *SyntaxError
+			if assert.True(t, errors.As(err, &syntaxErr), "%q", tc.input) {
+				assert.Equal(t, tc.pos, syntaxErr.Pos, "%q: %s", tc.input, err)
+			}
+		}
+	})
+}
+
+func TestMatch(t *testing.T) {
+	item := model.DataItem{ID: "order-42", Value: model.StringValue("payment error: timeout")}
+
+	for _, tc := range []struct {
+		input string
+		want  bool
+	}{
+		{`id = "order-42"`, true},
+		{`id != "order-42"`, false},
+		{`id ^= "order-"`, true},
+		{`id ^= "Order-"`, false},
+		{`value *= "error"`, true},
+		{`value ~ "time(out)?$"`, true},
+		{`id ^= "order-" AND NOT value *= "error"`, false},
+		{`id ^= "invoice-" OR value *= "error"`, true},
+		{`NOT (id ^= "invoice-" OR value *= "ok")`, true},
+	} {
+		expr, err := Parse(tc.input)
+		if assert.NoError(t, err, tc.input) {
+			assert.Equal(t, tc.want, expr.Match(item), tc.input)
+		}
+	}
+}
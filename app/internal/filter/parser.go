@@ -0,0 +1,234 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SyntaxError reports the position of the offending token of an invalid
+// expression. Pos is the 1-based byte offset into the expression.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func (t token) keyword(name string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, name)
+}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i + 1})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i + 1})
+			i++
+		case c == '=' || c == '~':
+			tokens = append(tokens, token{kind: tokenOp, text: string(c), pos: i + 1})
+			i++
+		case c == '!' || c == '^' || c == '*':
+			if i+1 >= len(input) || input[i+1] != '=' {
+				return nil, &SyntaxError{Pos: i + 1, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: input[i : i+2], pos: i + 1})
+			i += 2
+		case c == '"':
+			start := i
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(input) {
+					return nil, &SyntaxError{Pos: start + 1, Msg: "unterminated string"}
+				}
+				if input[i] == '"' {
+					i++
+					break
+				}
+				if input[i] == '\\' {
+					if i+1 >= len(input) || (input[i+1] != '"' && input[i+1] != '\\') {
+						return nil, &SyntaxError{Pos: i + 1, Msg: "invalid escape sequence"}
+					}
+					i++
+				}
+				b.WriteByte(input[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, text: b.String(), pos: start + 1})
+		case isIdentStart(c):
+			start := i
+			for i < len(input) && (isIdentStart(input[i]) || ('0' <= input[i] && input[i] <= '9')) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: input[start:i], pos: start + 1})
+		default:
+			return nil, &SyntaxError{Pos: i + 1, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(input) + 1}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses and validates a filter expression. Errors are *SyntaxError.
+func Parse(input string) (Expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, &SyntaxError{Pos: tok.pos, Msg: "unexpected " + tok.describe()}
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().keyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().keyword("AND") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	tok := p.peek()
+	switch {
+	case tok.keyword("NOT"):
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Expr: expr}, nil
+	case tok.kind == tokenLParen:
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, &SyntaxError{Pos: closing.pos, Msg: "expected \")\" but found " + closing.describe()}
+		}
+		return expr, nil
+	default:
+		return p.parseCondition()
+	}
+}
+
+func (p *parser) parseCondition() (Expr, error) {
+	fieldTok := p.next()
+	if fieldTok.kind != tokenIdent {
+		return nil, &SyntaxError{Pos: fieldTok.pos, Msg: "expected field but found " + fieldTok.describe()}
+	}
+	field := Field(strings.ToLower(fieldTok.text))
+	if field != FieldID && field != FieldValue {
+		return nil, &SyntaxError{Pos: fieldTok.pos, Msg: fmt.Sprintf("unknown field %q", fieldTok.text)}
+	}
+
+	opTok := p.next()
+	if opTok.kind != tokenOp {
+		return nil, &SyntaxError{Pos: opTok.pos, Msg: "expected operator but found " + opTok.describe()}
+	}
+
+	valueTok := p.next()
+	if valueTok.kind != tokenString {
+		return nil, &SyntaxError{Pos: valueTok.pos, Msg: "expected string but found " + valueTok.describe()}
+	}
+
+	cond := &Condition{Field: field, Op: Op(opTok.text), Value: valueTok.text}
+	if cond.Op == OpRegex {
+		regex, err := regexp.Compile(valueTok.text)
+		if err != nil {
+			return nil, &SyntaxError{Pos: valueTok.pos, Msg: fmt.Sprintf("invalid regular expression: %s", err)}
+		}
+		cond.Regex = regex
+	}
+	return cond, nil
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/pkg/http_handler"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AdminHandler serves the operational endpoints under /admin.
+type AdminHandler struct {
+	snapshots *repository.SnapshotManager
+}
+
+func NewAdminHandler(snapshots *repository.SnapshotManager) *AdminHandler {
+	return &AdminHandler{
+		snapshots: snapshots,
+	}
+}
+
+func AdminRoutes(mux *http.ServeMux, handler *AdminHandler) {
+	mux.HandleFunc("POST /admin/snapshot", otelhttp.NewHandler(http.HandlerFunc(handler.SnapshotHandler), "Snapshot").ServeHTTP)
+	mux.HandleFunc("POST /admin/restore", otelhttp.NewHandler(http.HandlerFunc(handler.RestoreSnapshotHandler), "RestoreSnapshot").ServeHTTP)
+}
+
+func (h *AdminHandler) SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "SnapshotHandler")
+	defer span.End()
+
+	info, err := h.snapshots.Save(ctx)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to save snapshot")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusCreated, "Snapshot saved successfully", info)
+	span.SetStatus(codes.Ok, "success")
+}
+
+// RestoreSnapshotHandler restores the snapshot named by the name query
+// parameter, or the latest readable one without it.
+func (h *AdminHandler) RestoreSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "RestoreSnapshotHandler")
+	defer span.End()
+
+	var (
+		info repository.SnapshotInfo
+		err  error
+	)
+	if name := r.URL.Query().Get("name"); name != "" {
+		span.SetAttributes(attribute.String("request.snapshot", name))
+		info, err = h.snapshots.Restore(ctx, name)
+	} else {
+		info, err = h.snapshots.RestoreLatest(ctx)
+	}
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to restore snapshot")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "Snapshot restored successfully", info)
+	span.SetStatus(codes.Ok, "success")
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/service"
+	"simple_lgtm/pkg/errs"
+	"simple_lgtm/pkg/http_handler"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type batchRequest struct {
+	// Atomic applies the operations all or nothing instead of one by one.
+	Atomic     bool             `json:"atomic"`
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchOperation is an item payload as accepted by POST and PUT together
+// with the operation to apply to it.
+type batchOperation struct {
+	Op string `json:"op"`
+	model.DataItem
+	ExpectedVersion int64 `json:"expected_version,omitempty"`
+}
+
+type batchResult struct {
+	Status int             `json:"status"`
+	Data   *model.DataItem `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Code   string          `json:"code,omitempty"`
+}
+
+func (h *Handler) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "BatchHandler")
+	defer span.End()
+
+	var payload batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("invalid request payload: %s", err.Error())))
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid request payload")
+		return
+	}
+
+	span.SetAttributes(
+		attribute.Int("request.operations", len(payload.Operations)),
+		attribute.Bool("request.atomic", payload.Atomic),
+	)
+
+	now := time.Now()
+	actor := requestActor(r)
+	ops := make([]service.BatchOperation, len(payload.Operations))
+	for i, op := range payload.Operations {
+		if err := op.validate(); err != nil {
+			err = err.At(fmt.Sprintf("/operations/%d", i))
+			http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(err, errs.D("operation", i)))
+			span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+			span.SetStatus(codes.Error, "validation error")
+			return
+		}
+		if op.Op == string(service.BatchOpCreate) || op.Op == string(service.BatchOpUpdate) {
+			if err := h.schemas.Validate(ctx, op.ID, op.Value); err != nil {
+				http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("operation %d: %w", i, err), errs.D("operation", i)))
+				span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error()), attribute.Int("request.operation", i)))
+				span.SetStatus(codes.Error, "schema validation error")
+				return
+			}
+		}
+		ops[i] = service.BatchOperation{
+			Op:              service.BatchOp(op.Op),
+			ID:              op.ID,
+			Value:           op.Value,
+			Labels:          op.Labels,
+			ExpiresAt:       op.Expiry(now),
+			ExpectedVersion: op.ExpectedVersion,
+			Actor:           actor,
+		}
+	}
+
+	results, err := h.batcher.Execute(ctx, ops, payload.Atomic)
+	if err != nil && results == nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to execute batch")
+		return
+	}
+
+	data := make([]batchResult, len(results))
+	for i, result := range results {
+		data[i] = newBatchResult(ops[i].Op, result)
+	}
+
+	// A failed atomic batch is answered with the status of the operation
+	// that failed, so that clients which ignore the results still notice.
+	if err != nil {
+		http_handler.AbortProblem(ctx, w, err, map[string]any{"results": data})
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "batch rolled back")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "Batch executed successfully", data)
+	span.SetStatus(codes.Ok, "success")
+}
+
+// validate checks op like the single item endpoint of its operation would.
+func (op *batchOperation) validate() *model.ValidationError {
+	invalid := &model.ValidationError{}
+	switch service.BatchOp(op.Op) {
+	case service.BatchOpCreate, service.BatchOpUpdate:
+		if err := op.DataItem.Validate(); err != nil {
+			errors.As(err, &invalid)
+		}
+	case service.BatchOpGet, service.BatchOpDelete:
+		if op.ID == "" {
+			invalid.Fields = append(invalid.Fields, errs.FieldError{Field: "/id", Message: "is required"})
+		}
+	default:
+		invalid.Fields = append(invalid.Fields, errs.FieldError{Field: "/op", Message: fmt.Sprintf("must be one of create, get, update and delete, not %q", op.Op)})
+	}
+	if len(invalid.Fields) == 0 {
+		return nil
+	}
+	return invalid
+}
+
+// newBatchResult reports result with the status code the single item
+// endpoint of op would have answered with.
+func newBatchResult(op service.BatchOp, result service.BatchResult) batchResult {
+	switch {
+	case errors.Is(result.Err, service.ErrBatchAborted):
+		return batchResult{Status: http.StatusFailedDependency, Error: result.Err.Error()}
+	case result.Err != nil:
+		problem := errs.NewProblem(result.Err)
+		return batchResult{Status: problem.Status, Error: problem.Detail, Code: problem.Code}
+	case op == service.BatchOpCreate:
+		return batchResult{Status: http.StatusCreated, Data: result.Item}
+	default:
+		return batchResult{Status: http.StatusOK, Data: result.Item}
+	}
+}
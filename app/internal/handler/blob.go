@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"simple_lgtm/internal/blob"
+	"simple_lgtm/pkg/errs"
+	"simple_lgtm/pkg/http_handler"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BlobHandler serves binary values under /blobs. Bodies are streamed to and
+// from the store, and downloads support Range and conditional requests.
+type BlobHandler struct {
+	blobs        *blob.Store
+	maxSize      int64
+	bytesCounter *prometheus.CounterVec
+}
+
+func NewBlobHandler(blobs *blob.Store, maxSize int64, bytesCounter *prometheus.CounterVec) *BlobHandler {
+	return &BlobHandler{
+		blobs:        blobs,
+		maxSize:      maxSize,
+		bytesCounter: bytesCounter,
+	}
+}
+
+// BlobRoutes registers the blob routes, which are scoped to the tenant of the
+// request like those of items. GET also serves HEAD.
+func BlobRoutes(mux *http.ServeMux, handler *BlobHandler, scope *Handler) {
+	scoped := func(fn http.HandlerFunc, operation string) http.HandlerFunc {
+		return otelhttp.NewHandler(scope.tenantScoped(fn), operation).ServeHTTP
+	}
+	mux.HandleFunc("PUT /blobs/{id}", scoped(handler.PutBlobHandler, "PutBlob"))
+	mux.HandleFunc("GET /blobs/{id}", scoped(handler.GetBlobHandler, "GetBlob"))
+	mux.HandleFunc("DELETE /blobs/{id}", scoped(handler.DeleteBlobHandler, "DeleteBlob"))
+}
+
+// PutBlobHandler stores the raw request body under the id, with the
+// Content-Type of the request. A SHA-256 in a Repr-Digest or Content-Digest
+// header is checked against the body.
+func (h *BlobHandler) PutBlobHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "PutBlobHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id), attribute.Int64("http.request_content_length", r.ContentLength))
+
+	if r.ContentLength > h.maxSize {
+		err := errs.NewPayloadTooLarge(fmt.Errorf("blob of %d bytes exceeds the limit of %d bytes", r.ContentLength, h.maxSize))
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "blob too large")
+		return
+	}
+
+	expected, err := parseDigest(r.Header)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid digest")
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = blob.DefaultContentType
+	}
+
+	// Uploads up to the size limit may take longer than the read timeout of
+	// the server.
+	_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+	body := &countingReader{r: http.MaxBytesReader(w, r.Body, h.maxSize)}
+	b, err := h.blobs.Put(ctx, id, contentType, body, expected)
+	h.bytesCounter.WithLabelValues("in").Add(float64(body.n))
+	span.SetAttributes(attribute.Int64("blob.bytes_in", body.n))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			err = errs.NewPayloadTooLarge(fmt.Errorf("blob exceeds the limit of %d bytes", h.maxSize))
+		} else if body.err != nil && errors.Is(err, body.err) {
+			err = errs.NewInvalidInput(fmt.Errorf("failed to read request body: %w", err))
+		}
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to put blob")
+		return
+	}
+
+	status, message := http.StatusOK, "Blob replaced successfully"
+	if b.CreatedAt.Equal(b.UpdatedAt) {
+		status, message = http.StatusCreated, "Blob created successfully"
+	}
+	w.Header().Set("ETag", strconv.Quote(b.SHA256))
+	http_handler.JSON(ctx, w, status, message, b)
+	span.SetStatus(codes.Ok, "success")
+}
+
+// GetBlobHandler streams the content of a blob. Its metadata is returned in
+// the Content-Type, Content-Length, ETag, Last-Modified and Repr-Digest
+// headers.
+func (h *BlobHandler) GetBlobHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "GetBlobHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id))
+	if rng := r.Header.Get("Range"); rng != "" {
+		span.SetAttributes(attribute.String("http.request.range", rng))
+	}
+
+	b, f, err := h.blobs.Open(ctx, id)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to get blob")
+		return
+	}
+	defer f.Close()
+
+	sum, _ := hex.DecodeString(b.SHA256)
+	w.Header().Set("Content-Type", b.ContentType)
+	w.Header().Set("ETag", strconv.Quote(b.SHA256))
+	w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+
+	// Downloads of large blobs may take longer than the write timeout of the
+	// server.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	cw := &countingResponseWriter{ResponseWriter: w}
+	http.ServeContent(cw, r.WithContext(ctx), "", b.UpdatedAt, f)
+	h.bytesCounter.WithLabelValues("out").Add(float64(cw.n))
+	span.SetAttributes(attribute.Int64("blob.size", b.Size), attribute.Int64("blob.bytes_out", cw.n), attribute.Int("http.status_code", cw.status))
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *BlobHandler) DeleteBlobHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "DeleteBlobHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id))
+
+	if err := h.blobs.Delete(ctx, id); err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to delete blob")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "Blob deleted successfully", nil)
+	span.SetStatus(codes.Ok, "success")
+}
+
+// parseDigest returns the SHA-256 of a Repr-Digest or Content-Digest header,
+// see RFC 9530, or nil if neither has one. Other algorithms are ignored.
+func parseDigest(header http.Header) ([]byte, error) {
+	for _, name := range []string{"Repr-Digest", "Content-Digest"} {
+		for _, member := range strings.Split(header.Get(name), ",") {
+			algorithm, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+			if !ok || !strings.EqualFold(algorithm, "sha-256") {
+				continue
+			}
+			sum, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+			if err != nil || len(sum) != 32 {
+				return nil, errs.NewInvalidInput(fmt.Errorf("invalid sha-256 in %s header", name))
+			}
+			return sum, nil
+		}
+	}
+	return nil, nil
+}
+
+// countingReader counts the bytes read from r and records the first error
+// other than io.EOF that r returned.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// countingResponseWriter counts the bytes of the body written to the
+// ResponseWriter and records its status.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (c *countingResponseWriter) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *countingResponseWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the flushing and deadlines of the
+// wrapped ResponseWriter.
+func (c *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/pkg/errs"
+)
+
+func formatETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseETag returns the version of an ETag made by formatETag. Any other
+// tag, such as an unquoted or weak one, is reported as false.
+func parseETag(tag string) (int64, bool) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version <= 0 || formatETag(version) != tag {
+		return 0, false
+	}
+	return version, true
+}
+
+// etagList is a parsed If-Match or If-None-Match header.
+type etagList struct {
+	any  bool
+	tags []string
+}
+
+func parseETagList(header string) etagList {
+	var list etagList
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		switch tag {
+		case "":
+		case "*":
+			list.any = true
+		default:
+			list.tags = append(list.tags, tag)
+		}
+	}
+	return list
+}
+
+func (l etagList) empty() bool {
+	return !l.any && len(l.tags) == 0
+}
+
+// matchStrong uses the strong comparison of RFC 9110, under which weak tags
+// never match. It is used for If-Match.
+func (l etagList) matchStrong(etag string) bool {
+	if l.any {
+		return true
+	}
+	for _, tag := range l.tags {
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWeak uses the weak comparison of RFC 9110. It is used for
+// If-None-Match.
+func (l etagList) matchWeak(etag string) bool {
+	if l.any {
+		return true
+	}
+	for _, tag := range l.tags {
+		if strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writePrecondition evaluates If-Match and If-None-Match of a PUT or DELETE
+// on id and returns the version the write must be applied to, or
+// repository.AnyVersion if the request is unconditional. If-Match fails on an
+// item that does not exist, as RFC 9110 requires.
+func (h *Handler) writePrecondition(ctx context.Context, r *http.Request, id string) (int64, error) {
+	ifMatch := parseETagList(r.Header.Get("If-Match"))
+	ifNoneMatch := parseETagList(r.Header.Get("If-None-Match"))
+	if ifMatch.empty() && ifNoneMatch.empty() {
+		return repository.AnyVersion, nil
+	}
+
+	// A single strong ETag is checked by the repository itself as part of
+	// the compare-and-swap, without an extra read.
+	if ifNoneMatch.empty() && !ifMatch.any && len(ifMatch.tags) == 1 {
+		version, ok := parseETag(ifMatch.tags[0])
+		if !ok {
+			return 0, errs.NewPreconditionFailed(fmt.Errorf("If-Match %s does not match data with ID %s", ifMatch.tags[0], id))
+		}
+		return version, nil
+	}
+
+	item, err := h.service.GetData(ctx, id)
+	if errs.IsNotFound(err) {
+		if !ifMatch.empty() {
+			return 0, errs.NewPreconditionFailed(fmt.Errorf("If-Match does not match data with ID %s, which does not exist", id))
+		}
+		return repository.AnyVersion, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	etag := formatETag(item.Version)
+	if !ifMatch.empty() && !ifMatch.matchStrong(etag) {
+		return 0, errs.NewPreconditionFailed(fmt.Errorf("If-Match does not match data with ID %s at %s", id, etag))
+	}
+	if !ifNoneMatch.empty() && ifNoneMatch.matchWeak(etag) {
+		return 0, errs.NewPreconditionFailed(fmt.Errorf("If-None-Match matches data with ID %s at %s", id, etag))
+	}
+	return item.Version, nil
+}
+
+// preconditionError reports a version conflict on a conditional write, or an
+// item it finds missing, as a failed precondition, since the version came
+// from the client's ETag.
+func preconditionError(expectedVersion int64, err error) error {
+	if expectedVersion != repository.AnyVersion && (errs.IsConflict(err) || errs.IsNotFound(err)) {
+		return errs.NewPreconditionFailed(err)
+	}
+	return err
+}
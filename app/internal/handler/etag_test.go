@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseETagList(t *testing.T) {
+	tests := []struct {
+		header string
+		want   etagList
+	}{
+		{"", etagList{}},
+		{" , ", etagList{}},
+		{"*", etagList{any: true}},
+		{`"1"`, etagList{tags: []string{`"1"`}}},
+		{`W/"1"`, etagList{tags: []string{`W/"1"`}}},
+		{`"1", W/"2" ,"3"`, etagList{tags: []string{`"1"`, `W/"2"`, `"3"`}}},
+		{`*, "1"`, etagList{any: true, tags: []string{`"1"`}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got := parseETagList(tt.header)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.header == "" || tt.header == " , ", got.empty())
+		})
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		strong bool
+		weak   bool
+	}{
+		{"", `"1"`, false, false},
+		{"*", `"1"`, true, true},
+		{`"1"`, `"1"`, true, true},
+		{`"2"`, `"1"`, false, false},
+		{`W/"1"`, `"1"`, false, true},
+		{`W/"2"`, `"1"`, false, false},
+		{`"2", "1"`, `"1"`, true, true},
+		{`"2", W/"1"`, `"1"`, false, true},
+		{`"2", "3"`, `"1"`, false, false},
+		{`1`, `"1"`, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			list := parseETagList(tt.header)
+			assert.Equal(t, tt.strong, list.matchStrong(tt.etag), "strong")
+			assert.Equal(t, tt.weak, list.matchWeak(tt.etag), "weak")
+		})
+	}
+}
+
+func TestWritePrecondition(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHandler(t)
+	_, err := h.service.CreateData(ctx, "1", model.Value(`1`), nil, nil)
+	require.NoError(t, err)
+	_, err = h.service.UpdateData(ctx, "1", model.Value(`2`), nil, nil, repository.AnyVersion)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		id          string
+		ifMatch     string
+		ifNoneMatch string
+		want        int64
+		check       func(error) bool
+	}{
+		{"Unconditional", "1", "", "", repository.AnyVersion, nil},
+		// A single strong tag is left to the repository, which is why its
+		// version is returned whether or not it is current or exists.
+		{"SingleTag", "1", `"2"`, "", 2, nil},
+		{"SingleStaleTag", "1", `"1"`, "", 1, nil},
+		{"SingleTagMissing", "missing", `"1"`, "", 1, nil},
+		{"SingleWeakTag", "1", `W/"2"`, "", 0, errs.IsPreconditionFailed},
+		{"SingleInvalidTag", "1", `"x"`, "", 0, errs.IsPreconditionFailed},
+		{"SingleZeroTag", "1", `"0"`, "", 0, errs.IsPreconditionFailed},
+		{"SingleInvalidTagMissing", "missing", `"x"`, "", 0, errs.IsPreconditionFailed},
+		{"SingleUnquotedTag", "1", `2`, "", 0, errs.IsPreconditionFailed},
+		{"SingleHalfQuotedTag", "1", `"2`, "", 0, errs.IsPreconditionFailed},
+		{"SingleSignedTag", "1", `"+2"`, "", 0, errs.IsPreconditionFailed},
+		{"Any", "1", "*", "", 2, nil},
+		{"AnyMissing", "missing", "*", "", 0, errs.IsPreconditionFailed},
+		{"ListMatches", "1", `"1", "2"`, "", 2, nil},
+		{"ListDoesNotMatch", "1", `"1", "3"`, "", 0, errs.IsPreconditionFailed},
+		{"ListWeakDoesNotMatch", "1", `"1", W/"2"`, "", 0, errs.IsPreconditionFailed},
+		{"ListMissing", "missing", `"1", "2"`, "", 0, errs.IsPreconditionFailed},
+		{"NoneMatchAny", "1", "", "*", 0, errs.IsPreconditionFailed},
+		{"NoneMatchWeak", "1", "", `W/"2"`, 0, errs.IsPreconditionFailed},
+		{"NoneMatchStale", "1", "", `"1"`, 2, nil},
+		{"NoneMatchMissing", "missing", "", "*", repository.AnyVersion, nil},
+		{"Both", "1", `"2"`, `"1"`, 2, nil},
+		{"BothNoneMatches", "1", `"2"`, `"2"`, 0, errs.IsPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/data/"+tt.id, nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			version, err := h.writePrecondition(ctx, r, tt.id)
+			if tt.check != nil {
+				assert.True(t, tt.check(err), "unexpected error %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, version)
+		})
+	}
+}
+
+func TestPreconditionError(t *testing.T) {
+	conflict := errs.NewConflict(errors.New("data with ID 1 is at version 2, expected 1"))
+	notFound := errs.NewNotFound(errors.New("data with ID 1 not found"))
+
+	tests := []struct {
+		name            string
+		expectedVersion int64
+		err             error
+		status          int
+	}{
+		{"ConditionalConflict", 1, conflict, http.StatusPreconditionFailed},
+		{"UnconditionalConflict", repository.AnyVersion, conflict, http.StatusConflict},
+		{"ConditionalNotFound", 1, notFound, http.StatusPreconditionFailed},
+		{"UnconditionalNotFound", repository.AnyVersion, notFound, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := preconditionError(tt.expectedVersion, tt.err)
+			status, _ := errs.MapHttp(err)
+			assert.Equal(t, tt.status, status)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+	assert.NoError(t, preconditionError(1, nil))
+}
+
+func TestConditionalRequests(t *testing.T) {
+	server := newTestServer(t)
+	w := serve(server, http.MethodPost, "/data", `{"id":"1","value":1}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers []string
+		status  int
+	}{
+		{"GetNotModified", http.MethodGet, "/data/1", []string{"If-None-Match", `"1"`}, http.StatusNotModified},
+		{"GetNotModifiedWeak", http.MethodGet, "/data/1", []string{"If-None-Match", `W/"1"`}, http.StatusNotModified},
+		{"GetNotModifiedList", http.MethodGet, "/data/1", []string{"If-None-Match", `"7", "1"`}, http.StatusNotModified},
+		{"GetNotModifiedAny", http.MethodGet, "/data/1", []string{"If-None-Match", "*"}, http.StatusNotModified},
+		{"GetModified", http.MethodGet, "/data/1", []string{"If-None-Match", `"7"`}, http.StatusOK},
+		{"PutStale", http.MethodPut, "/data/1", []string{"If-Match", `"7"`}, http.StatusPreconditionFailed},
+		{"PutWeak", http.MethodPut, "/data/1", []string{"If-Match", `W/"1"`}, http.StatusPreconditionFailed},
+		{"PutMissing", http.MethodPut, "/data/missing", []string{"If-Match", `"1"`}, http.StatusPreconditionFailed},
+		{"PutMissingInvalidTag", http.MethodPut, "/data/missing", []string{"If-Match", `"x"`}, http.StatusPreconditionFailed},
+		{"DeleteStale", http.MethodDelete, "/data/1", []string{"If-Match", `"7"`}, http.StatusPreconditionFailed},
+		{"DeleteMissing", http.MethodDelete, "/data/missing", []string{"If-Match", `"1"`}, http.StatusPreconditionFailed},
+		{"DeleteMissingAny", http.MethodDelete, "/data/missing", []string{"If-Match", "*"}, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(server, tt.method, tt.target, `{"value":2}`, tt.headers...)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusNotModified {
+				assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+				assert.Empty(t, w.Body.String())
+			}
+		})
+	}
+
+	w = serve(server, http.MethodPut, "/data/1", `{"value":2}`, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+}
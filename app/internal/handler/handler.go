@@ -2,11 +2,19 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"simple_lgtm/internal/filter"
 	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/patch"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/internal/schema"
 	"simple_lgtm/pkg/errs"
 	"simple_lgtm/pkg/http_handler"
+	"strconv"
+	"strings"
 
 	"simple_lgtm/internal/service"
 	"time"
@@ -19,32 +27,29 @@ import (
 )
 
 type Handler struct {
-	service          *service.Service
-	requestCounter   *prometheus.CounterVec
-	latencyHistogram *prometheus.HistogramVec
+	service service.DataService
+	batcher *service.Batcher
+	schemas *schema.Registry
+	// patchMaxSize bounds the body of PATCH requests, in bytes.
+	patchMaxSize int64
+	// tenantRequestCounter counts the requests to /data by tenant.
+	tenantRequestCounter *prometheus.CounterVec
 }
 
-func NewHandler(svc *service.Service, requestCounter *prometheus.CounterVec, latencyHistogram *prometheus.HistogramVec) *Handler {
+func NewHandler(svc service.DataService, batcher *service.Batcher, schemas *schema.Registry, patchMaxSize int64, tenantRequestCounter *prometheus.CounterVec) *Handler {
 	return &Handler{
-		service:          svc,
-		requestCounter:   requestCounter,
-		latencyHistogram: latencyHistogram,
+		service:              svc,
+		batcher:              batcher,
+		schemas:              schemas,
+		patchMaxSize:         patchMaxSize,
+		tenantRequestCounter: tenantRequestCounter,
 	}
 }
 
 func (h *Handler) CreateDataHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	method := r.Method
-	path := r.URL.Path
-
 	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "CreateDataHandler")
 	defer span.End()
 
-	h.requestCounter.WithLabelValues(method, path).Inc()
-	defer func() {
-		h.latencyHistogram.WithLabelValues(method, path).Observe(time.Since(start).Seconds())
-	}()
-
 	var payload model.DataItem
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("invalid request payload: %s", err.Error())))
@@ -53,7 +58,7 @@ func (h *Handler) CreateDataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := payload.Validate(); err != nil {
-		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("validation error: %s", err.Error())))
+		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(err))
 		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
 		span.SetStatus(codes.Error, "validation error")
 		return
@@ -61,10 +66,17 @@ func (h *Handler) CreateDataHandler(w http.ResponseWriter, r *http.Request) {
 
 	span.SetAttributes(
 		attribute.String("request.id", payload.ID),
-		attribute.String("request.value", payload.Value),
+		attribute.String("request.value", string(payload.Value)),
 	)
 
-	err := h.service.CreateData(ctx, payload.ID, payload.Value)
+	if err := h.schemas.Validate(ctx, payload.ID, payload.Value); err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "schema validation error")
+		return
+	}
+
+	item, err := h.service.CreateData(ctx, payload.ID, payload.Value, payload.Labels, payload.Expiry(time.Now()))
 	if err != nil {
 		http_handler.AbortJSON(ctx, w, err)
 		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
@@ -72,23 +84,15 @@ func (h *Handler) CreateDataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http_handler.JSON(ctx, w, http.StatusCreated, "Data created successfully", nil)
+	w.Header().Set("ETag", formatETag(item.Version))
+	http_handler.JSON(ctx, w, http.StatusCreated, "Data created successfully", item)
 	span.SetStatus(codes.Ok, "success")
 }
 
 func (h *Handler) GetDataHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	method := r.Method
-	path := r.URL.Path
-
 	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "GetDataHandler")
 	defer span.End()
 
-	h.requestCounter.WithLabelValues(method, path).Inc()
-	defer func() {
-		h.latencyHistogram.WithLabelValues(method, path).Observe(time.Since(start).Seconds())
-	}()
-
 	id := r.PathValue("id")
 	if id == "" {
 		err := errs.NewInvalidInput(fmt.Errorf("ID parameter is required"))
@@ -100,11 +104,59 @@ func (h *Handler) GetDataHandler(w http.ResponseWriter, r *http.Request) {
 
 	span.SetAttributes(attribute.String("request.id", id))
 
-	data, err := h.service.GetData(ctx, id)
+	asOf, ok, err := parseAsOf(r)
 	if err != nil {
 		http_handler.AbortJSON(ctx, w, err)
 		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
-		span.SetStatus(codes.Error, "failed to get data")
+		span.SetStatus(codes.Error, "invalid query parameters")
+		return
+	}
+
+	metadata, err := parseMetadata(r)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid query parameters")
+		return
+	}
+
+	// The whole item is returned, like by List, unless metadata=false asks
+	// for only the value, as it was before items had metadata.
+	var (
+		version int64
+		data    any
+	)
+	if ok {
+		rev, err := h.service.GetRevision(ctx, id, asOf)
+		if err != nil {
+			http_handler.AbortJSON(ctx, w, err)
+			span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+			span.SetStatus(codes.Error, "failed to get revision")
+			return
+		}
+		version, data = rev.Version, rev.Value
+		if metadata {
+			data = rev
+		}
+	} else {
+		item, err := h.service.GetData(ctx, id)
+		if err != nil {
+			http_handler.AbortJSON(ctx, w, err)
+			span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+			span.SetStatus(codes.Error, "failed to get data")
+			return
+		}
+		version, data = item.Version, item.Value
+		if metadata {
+			data = item
+		}
+	}
+
+	etag := formatETag(version)
+	w.Header().Set("ETag", etag)
+	if parseETagList(r.Header.Get("If-None-Match")).matchWeak(etag) {
+		http_handler.Empty(ctx, w, http.StatusNotModified)
+		span.SetStatus(codes.Ok, "not modified")
 		return
 	}
 
@@ -113,18 +165,9 @@ func (h *Handler) GetDataHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) UpdateDataHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	method := r.Method
-	path := r.URL.Path
-
 	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "UpdateDataHandler")
 	defer span.End()
 
-	h.requestCounter.WithLabelValues(method, path).Inc()
-	defer func() {
-		h.latencyHistogram.WithLabelValues(method, path).Observe(time.Since(start).Seconds())
-	}()
-
 	id := r.PathValue("id")
 	var payload model.DataItem
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -135,7 +178,7 @@ func (h *Handler) UpdateDataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	payload.ID = id
 	if err := payload.Validate(); err != nil {
-		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("validation error: %s", err.Error())))
+		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(err))
 		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
 		span.SetStatus(codes.Error, "validation error")
 		return
@@ -143,34 +186,109 @@ func (h *Handler) UpdateDataHandler(w http.ResponseWriter, r *http.Request) {
 
 	span.SetAttributes(
 		attribute.String("request.id", payload.ID),
-		attribute.String("request.value", payload.Value),
+		attribute.String("request.value", string(payload.Value)),
 	)
 
-	err := h.service.UpdateData(ctx, payload.ID, payload.Value)
+	if err := h.schemas.Validate(ctx, payload.ID, payload.Value); err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "schema validation error")
+		return
+	}
+
+	expectedVersion, err := h.writePrecondition(ctx, r, payload.ID)
 	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "precondition failed")
+		return
+	}
+	span.SetAttributes(attribute.Int64("request.expectedVersion", expectedVersion))
+
+	item, err := h.service.UpdateData(ctx, payload.ID, payload.Value, payload.Labels, payload.Expiry(time.Now()), expectedVersion)
+	if err != nil {
+		err = preconditionError(expectedVersion, err)
 		http_handler.AbortJSON(ctx, w, err)
 		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
 		span.SetStatus(codes.Error, "failed to update data")
 		return
 	}
 
-	http_handler.JSON(ctx, w, http.StatusOK, "Data updated successfully", nil)
+	w.Header().Set("ETag", formatETag(item.Version))
+	http_handler.JSON(ctx, w, http.StatusOK, "Data updated successfully", item)
 	span.SetStatus(codes.Ok, "success")
 }
 
-func (h *Handler) DeleteDataHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	method := r.Method
-	path := r.URL.Path
+// PatchDataHandler applies a JSON Merge Patch or JSON Patch, as given by the
+// Content-Type, to the value of an item. The patched value is validated and
+// written in the same transaction as the current value is read.
+func (h *Handler) PatchDataHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "PatchDataHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	contentType := r.Header.Get("Content-Type")
+	span.SetAttributes(attribute.String("request.id", id), attribute.String("request.contentType", contentType))
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.patchMaxSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			err = errs.NewPayloadTooLarge(fmt.Errorf("patch exceeds the limit of %d bytes", h.patchMaxSize))
+		} else {
+			err = errs.NewInvalidInput(fmt.Errorf("failed to read request body: %w", err))
+		}
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to read request body")
+		return
+	}
+	p, err := patch.Parse(contentType, body)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid patch")
+		return
+	}
 
+	expectedVersion, err := h.writePrecondition(ctx, r, id)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "precondition failed")
+		return
+	}
+	span.SetAttributes(attribute.Int64("request.expectedVersion", expectedVersion))
+
+	item, err := h.service.PatchData(ctx, id, func(current model.Value) (model.Value, error) {
+		value, err := p.Apply(current)
+		if err != nil {
+			return "", err
+		}
+		if value.Null() {
+			return "", errs.NewInvalidInput(fmt.Errorf("validation error: Value is required"))
+		}
+		return value, h.schemas.Validate(ctx, id, value)
+	}, expectedVersion)
+	if err != nil {
+		if !errors.Is(err, patch.ErrTestFailed) {
+			err = preconditionError(expectedVersion, err)
+		}
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to patch data")
+		return
+	}
+
+	w.Header().Set("ETag", formatETag(item.Version))
+	http_handler.JSON(ctx, w, http.StatusOK, "Data patched successfully", item)
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *Handler) DeleteDataHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "DeleteDataHandler")
 	defer span.End()
 
-	h.requestCounter.WithLabelValues(method, path).Inc()
-	defer func() {
-		h.latencyHistogram.WithLabelValues(method, path).Observe(time.Since(start).Seconds())
-	}()
-
 	id := r.PathValue("id")
 	if id == "" {
 		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("ID parameter is required")))
@@ -180,8 +298,21 @@ func (h *Handler) DeleteDataHandler(w http.ResponseWriter, r *http.Request) {
 
 	span.SetAttributes(attribute.String("request.id", id))
 
-	err := h.service.DeleteData(ctx, id)
+	expectedVersion, err := h.writePrecondition(ctx, r, id)
 	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "precondition failed")
+		return
+	}
+	span.SetAttributes(attribute.Int64("request.expectedVersion", expectedVersion))
+
+	actor := requestActor(r)
+	span.SetAttributes(attribute.String("request.actor", actor))
+
+	_, err = h.service.DeleteData(ctx, id, expectedVersion, actor)
+	if err != nil {
+		err = preconditionError(expectedVersion, err)
 		http_handler.AbortJSON(ctx, w, err)
 		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
 		span.SetStatus(codes.Error, "failed to delete data")
@@ -193,19 +324,18 @@ func (h *Handler) DeleteDataHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) ListAllDataHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	method := r.Method
-	path := r.URL.Path
-
 	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "ListAllDataHandler")
 	defer span.End()
 
-	h.requestCounter.WithLabelValues(method, path).Inc()
-	defer func() {
-		h.latencyHistogram.WithLabelValues(method, path).Observe(time.Since(start).Seconds())
-	}()
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid query parameters")
+		return
+	}
 
-	data, err := h.service.ListAllData(ctx)
+	page, err := h.service.ListAllData(ctx, opts)
 	if err != nil {
 		http_handler.AbortJSON(ctx, w, err)
 		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
@@ -213,6 +343,110 @@ func (h *Handler) ListAllDataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http_handler.JSON(ctx, w, http.StatusOK, "ok", data)
+	http_handler.JSONPage(ctx, w, http.StatusOK, "ok", page.Items, page.NextCursor)
 	span.SetStatus(codes.Ok, "success")
 }
+
+func (h *Handler) ListTrashHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "ListTrashHandler")
+	defer span.End()
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid query parameters")
+		return
+	}
+
+	page, err := h.service.ListTrash(ctx, opts)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "Failed to list trash")
+		return
+	}
+
+	http_handler.JSONPage(ctx, w, http.StatusOK, "ok", page.Items, page.NextCursor)
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *Handler) RestoreDataHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "RestoreDataHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	if id == "" {
+		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("ID parameter is required")))
+		span.SetStatus(codes.Error, "ID parameter is required")
+		return
+	}
+
+	span.SetAttributes(attribute.String("request.id", id))
+
+	item, err := h.service.RestoreData(ctx, id)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to restore data")
+		return
+	}
+
+	w.Header().Set("ETag", formatETag(item.Version))
+	http_handler.JSON(ctx, w, http.StatusOK, "Data restored successfully", nil)
+	span.SetStatus(codes.Ok, "success")
+}
+
+// requestActor identifies who made the request for the audit fields of an
+// item. There is no authentication, so it is taken from the X-Actor header.
+func requestActor(r *http.Request) string {
+	if actor := strings.TrimSpace(r.Header.Get("X-Actor")); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// parseListOptions reads the limit, cursor, order and filter query
+// parameters. A leading "-" on order sorts descending, e.g. order=-updated_at.
+func parseListOptions(r *http.Request) (repository.ListOptions, error) {
+	query := r.URL.Query()
+	opts := repository.ListOptions{Cursor: query.Get("cursor")}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > repository.MaxListLimit {
+			return opts, errs.NewInvalidInput(fmt.Errorf("limit must be between 1 and %d", repository.MaxListLimit))
+		}
+		opts.Limit = n
+	}
+
+	order := query.Get("order")
+	if strings.HasPrefix(order, "-") {
+		opts.Descending = true
+		order = order[1:]
+	}
+	opts.OrderBy = repository.Order(order)
+
+	if expr := query.Get("filter"); expr != "" {
+		parsed, err := filter.Parse(expr)
+		if err != nil {
+			return opts, errs.NewInvalidInput(fmt.Errorf("invalid filter: %w", err))
+		}
+		opts.Filter = parsed
+	}
+	return opts, nil
+}
+
+// parseMetadata reads the metadata query parameter. GET returns the whole
+// item unless it is false, which returns only the value.
+func parseMetadata(r *http.Request) (bool, error) {
+	param := r.URL.Query().Get("metadata")
+	if param == "" {
+		return true, nil
+	}
+	metadata, err := strconv.ParseBool(param)
+	if err != nil {
+		return false, errs.NewInvalidInput(fmt.Errorf("metadata must be a boolean"))
+	}
+	return metadata, nil
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"simple_lgtm/internal/blob"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/internal/schema"
+	"simple_lgtm/internal/service"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopNotifier struct{}
+
+func (nopNotifier) Notify(context.Context, model.Notification) {}
+
+// newTestHandler returns a Handler over an in-memory repository.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	feed := repository.NewFeed(10, prometheus.NewGauge(prometheus.GaugeOpts{Name: "subscribers"}), prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"}))
+	repo := repository.NewPublishingRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), feed)
+	blobs, err := blob.NewStore(t.TempDir())
+	require.NoError(t, err)
+	svc := service.NewService(repo, feed, blobs, 10)
+	batcher := service.NewBatcher(svc, nopNotifier{}, 10, prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "size"}, []string{"mode", "result"}))
+	return NewHandler(svc, batcher, schema.NewRegistry(), 64, prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"tenant"}))
+}
+
+// newTestServer returns the endpoints of newTestHandler.
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	mux := http.NewServeMux()
+	Routes(mux, newTestHandler(t))
+	return TenantPrefix(mux)
+}
+
+// serve makes a request to server with the headers given as name and value
+// pairs.
+func serve(server http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	return w
+}
+
+// decodeData decodes the data of a successful response into v.
+func decodeData(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	require.NoError(t, json.Unmarshal(body.Data, v))
+}
+
+func TestGetData(t *testing.T) {
+	server := newTestServer(t)
+	w := serve(server, http.MethodPost, "/data", `{"id":"1","value":{"a":1},"labels":{"env":"prod"}}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	t.Run("Item", func(t *testing.T) {
+		w := serve(server, http.MethodGet, "/data/1", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var item model.DataItem
+		decodeData(t, w, &item)
+		assert.Equal(t, "1", item.ID)
+		assert.Equal(t, model.Value(`{"a":1}`), item.Value)
+		assert.Equal(t, map[string]string{"env": "prod"}, item.Labels)
+		assert.Equal(t, int64(1), item.Version)
+		assert.False(t, item.CreatedAt.IsZero())
+		assert.Equal(t, item.CreatedAt, item.UpdatedAt)
+	})
+
+	t.Run("ValueOnly", func(t *testing.T) {
+		w := serve(server, http.MethodGet, "/data/1?metadata=false", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var value model.Value
+		decodeData(t, w, &value)
+		assert.Equal(t, model.Value(`{"a":1}`), value)
+	})
+
+	t.Run("InvalidMetadata", func(t *testing.T) {
+		w := serve(server, http.MethodGet, "/data/1?metadata=maybe", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		w := serve(server, http.MethodGet, "/data", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var items []model.DataItem
+		decodeData(t, w, &items)
+		require.Len(t, items, 1)
+		assert.Equal(t, map[string]string{"env": "prod"}, items[0].Labels)
+		assert.Equal(t, int64(1), items[0].Version)
+		assert.False(t, items[0].UpdatedAt.IsZero())
+	})
+}
+
+func TestGetDataExpiresAt(t *testing.T) {
+	server := newTestServer(t)
+	w := serve(server, http.MethodPost, "/data", `{"id":"1","value":1,"ttl":"1h"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created model.DataItem
+	decodeData(t, w, &created)
+	require.NotNil(t, created.ExpiresAt)
+	assert.WithinDuration(t, created.CreatedAt.Add(time.Hour), *created.ExpiresAt, time.Second)
+	assert.Empty(t, created.TTL)
+
+	w = serve(server, http.MethodGet, "/data/1", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var item model.DataItem
+	decodeData(t, w, &item)
+	require.NotNil(t, item.ExpiresAt)
+	assert.True(t, created.ExpiresAt.Equal(*item.ExpiresAt))
+
+	w = serve(server, http.MethodGet, "/data/1?as_of=1", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var rev model.Revision
+	decodeData(t, w, &rev)
+	require.NotNil(t, rev.ExpiresAt)
+	assert.True(t, created.ExpiresAt.Equal(*rev.ExpiresAt))
+}
+
+func TestPatchDataTooLarge(t *testing.T) {
+	server := newTestServer(t)
+	w := serve(server, http.MethodPost, "/data", `{"id":"1","value":{"a":1}}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(server, http.MethodPatch, "/data/1", `{"a":2}`, "Content-Type", "application/merge-patch+json")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(server, http.MethodPatch, "/data/1", `{"a":"`+strings.Repeat("x", 64)+`"}`, "Content-Type", "application/merge-patch+json")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	var problem struct {
+		Code string `json:"code"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal(t, "PAYLOAD_TOO_LARGE", problem.Code)
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+	"simple_lgtm/pkg/health"
+	"simple_lgtm/pkg/http_handler"
+)
+
+// HealthHandler serves the probes of orchestrators. They are not traced,
+// since they are polled every few seconds.
+type HealthHandler struct {
+	checks *health.Registry
+}
+
+func NewHealthHandler(checks *health.Registry) *HealthHandler {
+	return &HealthHandler{
+		checks: checks,
+	}
+}
+
+func HealthRoutes(mux *http.ServeMux, handler *HealthHandler) {
+	mux.HandleFunc("GET /healthz", handler.LivenessHandler)
+	mux.HandleFunc("GET /readyz", handler.ReadinessHandler)
+}
+
+// LivenessHandler reports that the process serves requests. It checks no
+// dependency, so that an outage of one does not get the process restarted.
+func (h *HealthHandler) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	http_handler.JSON(r.Context(), w, http.StatusOK, "Alive", health.Report{Status: health.StatusPass, Checks: map[string]health.Result{}})
+}
+
+// ReadinessHandler runs the registered checks and responds with the result of
+// every one of them, with 503 Service Unavailable if a required check failed
+// or the server is shutting down.
+func (h *HealthHandler) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := h.checks.Check(r.Context())
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == health.StatusFail {
+		http_handler.JSON(r.Context(), w, http.StatusServiceUnavailable, "Not ready", report)
+		return
+	}
+	http_handler.JSON(r.Context(), w, http.StatusOK, "Ready", report)
+}
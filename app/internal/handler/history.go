@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"simple_lgtm/internal/service"
+	"simple_lgtm/pkg/errs"
+	"simple_lgtm/pkg/http_handler"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func (h *Handler) GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "GetHistoryHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id))
+
+	revisions, err := h.service.GetHistory(ctx, id)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to get history")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "ok", revisions)
+	span.SetStatus(codes.Ok, "success")
+}
+
+// DiffDataHandler compares the revisions in the from and to query
+// parameters. Without to it compares with the latest revision.
+func (h *Handler) DiffDataHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "DiffDataHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id))
+
+	query := r.URL.Query()
+	from, err := strconv.ParseInt(query.Get("from"), 10, 64)
+	if err != nil || from < 1 {
+		err := errs.NewInvalidInput(fmt.Errorf("from must be a version"))
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid query parameters")
+		return
+	}
+	var to int64
+	if param := query.Get("to"); param != "" {
+		to, err = strconv.ParseInt(param, 10, 64)
+		if err != nil || to < 1 {
+			err := errs.NewInvalidInput(fmt.Errorf("to must be a version"))
+			http_handler.AbortJSON(ctx, w, err)
+			span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+			span.SetStatus(codes.Error, "invalid query parameters")
+			return
+		}
+	}
+
+	diff, err := h.service.Diff(ctx, id, from, to)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to diff data")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "ok", diff)
+	span.SetStatus(codes.Ok, "success")
+}
+
+// parseAsOf reads the as_of query parameter, which is either a version or an
+// RFC 3339 timestamp. ok is false if it is absent.
+func parseAsOf(r *http.Request) (asOf service.AsOf, ok bool, err error) {
+	param := r.URL.Query().Get("as_of")
+	if param == "" {
+		return asOf, false, nil
+	}
+	if version, err := strconv.ParseInt(param, 10, 64); err == nil {
+		if version < 1 {
+			return asOf, false, errs.NewInvalidInput(fmt.Errorf("as_of version must be positive"))
+		}
+		return service.AsOf{Version: version}, true, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, param)
+	if err != nil {
+		return asOf, false, errs.NewInvalidInput(fmt.Errorf("as_of must be a version or an RFC 3339 time"))
+	}
+	return service.AsOf{Time: t}, true, nil
+}
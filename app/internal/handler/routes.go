@@ -7,12 +7,32 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+// Routes registers the endpoints of handler. The /data endpoints are scoped
+// to the tenant of the request, so mux must be served through TenantPrefix.
 func Routes(mux *http.ServeMux, handler *Handler) {
-	mux.HandleFunc("GET /metrics", promhttp.Handler().ServeHTTP)
+	scoped := func(fn http.HandlerFunc, operation string) http.HandlerFunc {
+		return otelhttp.NewHandler(handler.tenantScoped(fn), operation).ServeHTTP
+	}
+	mux.HandleFunc("GET /data", scoped(handler.ListAllDataHandler, "ListData"))
+	mux.HandleFunc("GET /data/_trash", scoped(handler.ListTrashHandler, "ListTrash"))
+	mux.HandleFunc("GET /data/_watch", scoped(handler.WatchHandler, "Watch"))
+	mux.HandleFunc("GET /data/{id}", scoped(handler.GetDataHandler, "GetData"))
+	mux.HandleFunc("GET /data/{id}/history", scoped(handler.GetHistoryHandler, "GetHistory"))
+	mux.HandleFunc("GET /data/{id}/diff", scoped(handler.DiffDataHandler, "DiffData"))
+	mux.HandleFunc("POST /data", scoped(handler.CreateDataHandler, "CreateData"))
+	mux.HandleFunc("POST /data/_batch", scoped(handler.BatchHandler, "Batch"))
+	mux.HandleFunc("PUT /data/{id}", scoped(handler.UpdateDataHandler, "UpdateData"))
+	mux.HandleFunc("PATCH /data/{id}", scoped(handler.PatchDataHandler, "PatchData"))
+	mux.HandleFunc("DELETE /data/{id}", scoped(handler.DeleteDataHandler, "DeleteData"))
+	mux.HandleFunc("POST /data/{id}/restore", scoped(handler.RestoreDataHandler, "RestoreData"))
+
+	mux.HandleFunc("GET /tenants", otelhttp.NewHandler(http.HandlerFunc(handler.ListTenantsHandler), "ListTenants").ServeHTTP)
+	mux.HandleFunc("POST /tenants", otelhttp.NewHandler(http.HandlerFunc(handler.CreateTenantHandler), "CreateTenant").ServeHTTP)
+	mux.HandleFunc("DELETE /tenants/{name}", otelhttp.NewHandler(http.HandlerFunc(handler.DeleteTenantHandler), "DeleteTenant").ServeHTTP)
+}
 
-	mux.HandleFunc("GET /data", otelhttp.NewHandler(http.HandlerFunc(handler.ListAllDataHandler), "ListData").ServeHTTP)
-	mux.HandleFunc("GET /data/{id}", otelhttp.NewHandler(http.HandlerFunc(handler.GetDataHandler), "GetData").ServeHTTP)
-	mux.HandleFunc("POST /data", otelhttp.NewHandler(http.HandlerFunc(handler.CreateDataHandler), "CreateData").ServeHTTP)
-	mux.HandleFunc("PUT /data/{id}", otelhttp.NewHandler(http.HandlerFunc(handler.UpdateDataHandler), "UpdateData").ServeHTTP)
-	mux.HandleFunc("DELETE /data/{id}", otelhttp.NewHandler(http.HandlerFunc(handler.DeleteDataHandler), "DeleteData").ServeHTTP)
+// MetricsRoutes serves the metrics of the default Prometheus registry for
+// scraping.
+func MetricsRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /metrics", promhttp.Handler().ServeHTTP)
 }
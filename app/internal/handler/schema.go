@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/schema"
+	"simple_lgtm/pkg/errs"
+	"simple_lgtm/pkg/http_handler"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SchemaHandler serves the schemas of item values under /schemas. Schemas are
+// not scoped to the tenant of the request but may name one.
+type SchemaHandler struct {
+	schemas *schema.Registry
+}
+
+func NewSchemaHandler(schemas *schema.Registry) *SchemaHandler {
+	return &SchemaHandler{
+		schemas: schemas,
+	}
+}
+
+func SchemaRoutes(mux *http.ServeMux, handler *SchemaHandler) {
+	mux.HandleFunc("POST /schemas", otelhttp.NewHandler(http.HandlerFunc(handler.CreateSchemaHandler), "CreateSchema").ServeHTTP)
+	mux.HandleFunc("GET /schemas", otelhttp.NewHandler(http.HandlerFunc(handler.ListSchemasHandler), "ListSchemas").ServeHTTP)
+	mux.HandleFunc("GET /schemas/{id}", otelhttp.NewHandler(http.HandlerFunc(handler.GetSchemaHandler), "GetSchema").ServeHTTP)
+	mux.HandleFunc("PUT /schemas/{id}", otelhttp.NewHandler(http.HandlerFunc(handler.UpdateSchemaHandler), "UpdateSchema").ServeHTTP)
+	mux.HandleFunc("DELETE /schemas/{id}", otelhttp.NewHandler(http.HandlerFunc(handler.DeleteSchemaHandler), "DeleteSchema").ServeHTTP)
+}
+
+func (h *SchemaHandler) CreateSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "CreateSchemaHandler")
+	defer span.End()
+
+	var payload model.Schema
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("invalid request payload: %s", err.Error())))
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid request payload")
+		return
+	}
+
+	span.SetAttributes(attribute.String("request.id", payload.ID))
+
+	s, err := h.schemas.CreateSchema(ctx, payload)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to create schema")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusCreated, "Schema created successfully", s)
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *SchemaHandler) ListSchemasHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "ListSchemasHandler")
+	defer span.End()
+
+	schemas, err := h.schemas.ListSchemas(ctx)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to list schemas")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("response.count", len(schemas)))
+	http_handler.JSON(ctx, w, http.StatusOK, "Schemas retrieved successfully", schemas)
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *SchemaHandler) GetSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "GetSchemaHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id))
+
+	s, err := h.schemas.GetSchema(ctx, id)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to get schema")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "ok", s)
+	span.SetStatus(codes.Ok, "success")
+}
+
+// UpdateSchemaHandler replaces a schema. Items that are already stored are
+// not validated again.
+func (h *SchemaHandler) UpdateSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "UpdateSchemaHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id))
+
+	var payload model.Schema
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("invalid request payload: %s", err.Error())))
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid request payload")
+		return
+	}
+
+	s, err := h.schemas.UpdateSchema(ctx, id, payload)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to update schema")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "Schema updated successfully", s)
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *SchemaHandler) DeleteSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "DeleteSchemaHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id))
+
+	if err := h.schemas.DeleteSchema(ctx, id); err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to delete schema")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "Schema deleted successfully", nil)
+	span.SetStatus(codes.Ok, "success")
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute labels the requests that match no route, whose paths would
+// otherwise each create a series.
+const unmatchedRoute = "unmatched"
+
+// Metrics records the requests served by a ServeMux by the pattern of the
+// route that matched them, such as "GET /data/{id}", rather than by their
+// path, so that the number of series does not grow with the ids requested.
+type Metrics struct {
+	mux      *http.ServeMux
+	inFlight atomic.Int64
+
+	// requestCounter is labeled by method, route, code and class, such as
+	// "2xx", latencyHistogram by method, route and class, inFlightGauge by
+	// method and route and sizeHistogram by method, route and direction,
+	// "request" or "response".
+	requestCounter   *prometheus.CounterVec
+	latencyHistogram *prometheus.HistogramVec
+	inFlightGauge    *prometheus.GaugeVec
+	sizeHistogram    *prometheus.HistogramVec
+}
+
+func NewMetrics(mux *http.ServeMux, requestCounter *prometheus.CounterVec, latencyHistogram *prometheus.HistogramVec, inFlightGauge *prometheus.GaugeVec, sizeHistogram *prometheus.HistogramVec) *Metrics {
+	return &Metrics{
+		mux:              mux,
+		requestCounter:   requestCounter,
+		latencyHistogram: latencyHistogram,
+		inFlightGauge:    inFlightGauge,
+		sizeHistogram:    sizeHistogram,
+	}
+}
+
+// ServeHTTP serves r by the mux of m and records its metrics. m must be
+// served through TenantPrefix, so that routes are matched without the tenant
+// of the path.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	method, route := m.route(r)
+
+	m.inFlight.Add(1)
+	inFlight := m.inFlightGauge.WithLabelValues(method, route)
+	inFlight.Inc()
+	defer func() {
+		m.inFlight.Add(-1)
+		inFlight.Dec()
+	}()
+
+	var body *countingReader
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &countingReader{r: r.Body}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{body, r.Body}
+	}
+	cw := &countingResponseWriter{ResponseWriter: w}
+
+	defer func() {
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		code, class := strconv.Itoa(status), strconv.Itoa(status/100)+"xx"
+		m.requestCounter.WithLabelValues(method, route, code, class).Inc()
+		m.latencyHistogram.WithLabelValues(method, route, class).Observe(time.Since(start).Seconds())
+		var requestSize int64
+		if body != nil {
+			requestSize = body.n
+		}
+		m.sizeHistogram.WithLabelValues(method, route, "request").Observe(float64(requestSize))
+		m.sizeHistogram.WithLabelValues(method, route, "response").Observe(float64(cw.n))
+	}()
+
+	m.mux.ServeHTTP(cw, r)
+}
+
+// route returns the method and the pattern of the route that matches r. The
+// method of a request that matches no route is only kept if it is a standard
+// one, since clients can send any.
+func (m *Metrics) route(r *http.Request) (method string, route string) {
+	_, pattern := m.mux.Handler(r)
+	if pattern != "" {
+		return r.Method, pattern
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return r.Method, unmatchedRoute
+	default:
+		return "OTHER", unmatchedRoute
+	}
+}
+
+// Count returns the number of requests being served.
+func (m *Metrics) Count() int64 {
+	return m.inFlight.Load()
+}
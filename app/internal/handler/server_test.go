@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	requestCounter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"method", "route", "code", "class"})
+	latencyHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"method", "route", "class"})
+	inFlightGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "in_flight"}, []string{"method", "route"})
+	sizeHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "size"}, []string{"method", "route", "direction"})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.PathValue("id"))
+	})
+	mux.HandleFunc("POST /items", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		// Both only work if the middleware lets the ResponseController reach
+		// the ResponseWriter of the server.
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, "event")
+		if err := rc.Flush(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	m := NewMetrics(mux, requestCounter, latencyHistogram, inFlightGauge, sizeHistogram)
+	server := httptest.NewServer(TenantPrefix(m))
+	t.Cleanup(server.Close)
+
+	do := func(method, path, body string) *http.Response {
+		t.Helper()
+		r, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := server.Client().Do(r)
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("RoutePattern", func(t *testing.T) {
+		do(http.MethodGet, "/items/1", "")
+		do(http.MethodGet, "/items/2", "")
+		do(http.MethodGet, "/t/acme/items/3", "")
+
+		assert.Equal(t, 3.0, testutil.ToFloat64(requestCounter.WithLabelValues("GET", "GET /items/{id}", "200", "2xx")))
+		assert.Equal(t, 1, testutil.CollectAndCount(requestCounter))
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		resp := do(http.MethodPost, "/items", `{"id":"1"}`)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, 1.0, testutil.ToFloat64(requestCounter.WithLabelValues("POST", "POST /items", "201", "2xx")))
+
+		do(http.MethodGet, "/missing/1", "")
+		do(http.MethodGet, "/missing/2", "")
+		assert.Equal(t, 2.0, testutil.ToFloat64(requestCounter.WithLabelValues("GET", unmatchedRoute, "404", "4xx")))
+
+		do("BREW", "/items", "")
+		assert.Equal(t, 1.0, testutil.ToFloat64(requestCounter.WithLabelValues("OTHER", unmatchedRoute, "405", "4xx")))
+	})
+
+	t.Run("Unwrap", func(t *testing.T) {
+		resp := do(http.MethodGet, "/stream", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 1.0, testutil.ToFloat64(requestCounter.WithLabelValues("GET", "GET /stream", "200", "2xx")))
+
+		var w http.ResponseWriter = &countingResponseWriter{ResponseWriter: httptest.NewRecorder()}
+		assert.NoError(t, http.NewResponseController(w).Flush())
+	})
+
+	t.Run("InFlight", func(t *testing.T) {
+		assert.Equal(t, int64(0), m.Count())
+		assert.Equal(t, 0.0, testutil.ToFloat64(inFlightGauge.WithLabelValues("GET", "GET /items/{id}")))
+	})
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+	"simple_lgtm/pkg/http_handler"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tenantHeader names the tenant of a request. Requests without it belong to
+// the default tenant.
+const tenantHeader = "X-Tenant"
+
+// tenantPathPrefix is an alternative to tenantHeader for clients that cannot
+// set headers: /t/acme/data/1 is /data/1 in the tenant acme.
+const tenantPathPrefix = "/t/"
+
+// TenantPrefix serves requests under tenantPathPrefix by next as if they had
+// been made without the prefix but with tenantHeader set.
+func TenantPrefix(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, tenantPathPrefix)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name, path, _ := strings.Cut(rest, "/")
+		if header := r.Header.Get(tenantHeader); header != "" && header != name {
+			http_handler.AbortJSON(r.Context(), w, errs.NewInvalidInput(fmt.Errorf("tenant %q of the path does not match tenant %q of the %s header", name, header, tenantHeader)))
+			return
+		}
+
+		scoped := r.Clone(r.Context())
+		scoped.URL.Path = "/" + path
+		scoped.URL.RawPath = ""
+		scoped.Header.Set(tenantHeader, name)
+		next.ServeHTTP(w, scoped)
+	})
+}
+
+// tenantScoped runs fn in the tenant of the request, which must exist. The
+// tenant is set on the span of the request and counted.
+func (h *Handler) tenantScoped(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		name := r.Header.Get(tenantHeader)
+		if name == "" {
+			name = tenant.Default
+		}
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(attribute.String("tenant", name))
+
+		if err := tenant.Validate(name); err != nil {
+			http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(err))
+			span.SetStatus(codes.Error, "invalid tenant")
+			return
+		}
+		if _, err := h.service.GetTenant(ctx, name); err != nil {
+			http_handler.AbortJSON(ctx, w, err)
+			span.SetStatus(codes.Error, "unknown tenant")
+			return
+		}
+
+		// Only existing tenants are counted, whose number is bounded.
+		h.tenantRequestCounter.WithLabelValues(name).Inc()
+		fn(w, r.WithContext(tenant.WithTenant(ctx, name)))
+	}
+}
+
+type tenantRequest struct {
+	Name string `json:"name"`
+}
+
+func (h *Handler) CreateTenantHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "CreateTenantHandler")
+	defer span.End()
+
+	var payload tenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("invalid request payload: %s", err.Error())))
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid request payload")
+		return
+	}
+
+	span.SetAttributes(attribute.String("request.tenant", payload.Name))
+
+	t, err := h.service.CreateTenant(ctx, payload.Name)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to create tenant")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusCreated, "Tenant created successfully", t)
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *Handler) ListTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "ListTenantsHandler")
+	defer span.End()
+
+	tenants, err := h.service.ListTenants(ctx)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to list tenants")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("response.count", len(tenants)))
+	http_handler.JSON(ctx, w, http.StatusOK, "Tenants retrieved successfully", tenants)
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *Handler) DeleteTenantHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "DeleteTenantHandler")
+	defer span.End()
+
+	name := r.PathValue("name")
+	span.SetAttributes(attribute.String("request.tenant", name))
+
+	removed, err := h.service.DeleteTenant(ctx, name)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to delete tenant")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("response.removed", removed))
+	http_handler.JSON(ctx, w, http.StatusOK, "Tenant deleted successfully", map[string]int{"removed": removed})
+	span.SetStatus(codes.Ok, "success")
+}
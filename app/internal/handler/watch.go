@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/pkg/errs"
+	"simple_lgtm/pkg/http_handler"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// watchHeartbeat is how often an idle watch sends a comment, so that proxies
+// keep the connection open.
+const watchHeartbeat = 15 * time.Second
+
+// WatchHandler streams the changes of the items of the tenant as Server-Sent
+// Events, optionally only those whose id starts with the prefix query
+// parameter. The id of every event is its sequence number, so a client that
+// reconnects with Last-Event-ID receives the events it missed while they are
+// still buffered. Otherwise the stream starts with a "reset" event.
+//
+// The stream is not subject to the write timeout of the server. It ends when
+// the client falls too far behind or the server shuts down, and is resumed by
+// reconnecting.
+func (h *Handler) WatchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "WatchHandler")
+	defer span.End()
+
+	var after int64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		var err error
+		after, err = strconv.ParseInt(header, 10, 64)
+		if err != nil || after < 0 {
+			err := errs.NewInvalidInput(fmt.Errorf("Last-Event-ID must be the id of an event"))
+			http_handler.AbortJSON(ctx, w, err)
+			span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+			span.SetStatus(codes.Error, "invalid Last-Event-ID")
+			return
+		}
+	}
+	prefix := r.URL.Query().Get("prefix")
+	span.SetAttributes(attribute.Int64("request.after", after), attribute.String("request.prefix", prefix))
+
+	sub, complete := h.service.Watch(ctx, after, prefix)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	if !complete {
+		fmt.Fprintf(w, "event: reset\ndata: {\"after\":%d}\n\n", after)
+	}
+	if err := rc.Flush(); err != nil {
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "streaming is not supported")
+		return
+	}
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	sent := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("response.events", sent))
+	}()
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				span.SetStatus(codes.Error, "watch fell behind or feed closed")
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+				span.SetStatus(codes.Error, "failed to write event")
+				return
+			}
+			sent++
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-ctx.Done():
+			span.SetStatus(codes.Ok, "success")
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			span.SetStatus(codes.Ok, "client went away")
+			return
+		}
+	}
+}
+
+// writeEvent writes event in the Server-Sent Events format, named after its
+// operation.
+func writeEvent(w http.ResponseWriter, event model.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Operation, data)
+	return err
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/webhook"
+	"simple_lgtm/pkg/errs"
+	"simple_lgtm/pkg/http_handler"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WebhookHandler serves the webhook subscriptions under /webhooks. Webhooks
+// are not scoped to the tenant of the request but may name one.
+type WebhookHandler struct {
+	webhooks *webhook.Dispatcher
+}
+
+func NewWebhookHandler(webhooks *webhook.Dispatcher) *WebhookHandler {
+	return &WebhookHandler{
+		webhooks: webhooks,
+	}
+}
+
+func WebhookRoutes(mux *http.ServeMux, handler *WebhookHandler) {
+	mux.HandleFunc("POST /webhooks", otelhttp.NewHandler(http.HandlerFunc(handler.CreateWebhookHandler), "CreateWebhook").ServeHTTP)
+	mux.HandleFunc("GET /webhooks", otelhttp.NewHandler(http.HandlerFunc(handler.ListWebhooksHandler), "ListWebhooks").ServeHTTP)
+	mux.HandleFunc("GET /webhooks/_dead_letters", otelhttp.NewHandler(http.HandlerFunc(handler.ListDeadLettersHandler), "ListDeadLetters").ServeHTTP)
+	mux.HandleFunc("GET /webhooks/{id}", otelhttp.NewHandler(http.HandlerFunc(handler.GetWebhookHandler), "GetWebhook").ServeHTTP)
+	mux.HandleFunc("PUT /webhooks/{id}", otelhttp.NewHandler(http.HandlerFunc(handler.UpdateWebhookHandler), "UpdateWebhook").ServeHTTP)
+	mux.HandleFunc("DELETE /webhooks/{id}", otelhttp.NewHandler(http.HandlerFunc(handler.DeleteWebhookHandler), "DeleteWebhook").ServeHTTP)
+}
+
+// CreateWebhookHandler returns the new webhook with its secret, which is not
+// returned again.
+func (h *WebhookHandler) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "CreateWebhookHandler")
+	defer span.End()
+
+	var payload model.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("invalid request payload: %s", err.Error())))
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid request payload")
+		return
+	}
+
+	span.SetAttributes(attribute.String("request.url", payload.URL))
+
+	hook, err := h.webhooks.CreateWebhook(ctx, payload)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to create webhook")
+		return
+	}
+
+	span.SetAttributes(attribute.String("response.id", hook.ID))
+	http_handler.JSON(ctx, w, http.StatusCreated, "Webhook created successfully", hook)
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *WebhookHandler) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "ListWebhooksHandler")
+	defer span.End()
+
+	hooks, err := h.webhooks.ListWebhooks(ctx)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to list webhooks")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("response.count", len(hooks)))
+	http_handler.JSON(ctx, w, http.StatusOK, "Webhooks retrieved successfully", hooks)
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *WebhookHandler) GetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "GetWebhookHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id))
+
+	hook, err := h.webhooks.GetWebhook(ctx, id)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to get webhook")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "ok", hook)
+	span.SetStatus(codes.Ok, "success")
+}
+
+// UpdateWebhookHandler replaces a webhook. Its secret is kept unless the
+// payload has one.
+func (h *WebhookHandler) UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "UpdateWebhookHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id))
+
+	var payload model.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http_handler.AbortJSON(ctx, w, errs.NewInvalidInput(fmt.Errorf("invalid request payload: %s", err.Error())))
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "invalid request payload")
+		return
+	}
+
+	hook, err := h.webhooks.UpdateWebhook(ctx, id, payload)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to update webhook")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "Webhook updated successfully", hook)
+	span.SetStatus(codes.Ok, "success")
+}
+
+func (h *WebhookHandler) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "DeleteWebhookHandler")
+	defer span.End()
+
+	id := r.PathValue("id")
+	span.SetAttributes(attribute.String("request.id", id))
+
+	if err := h.webhooks.DeleteWebhook(ctx, id); err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to delete webhook")
+		return
+	}
+
+	http_handler.JSON(ctx, w, http.StatusOK, "Webhook deleted successfully", nil)
+	span.SetStatus(codes.Ok, "success")
+}
+
+// ListDeadLettersHandler returns the deliveries that failed for good, oldest
+// first.
+func (h *WebhookHandler) ListDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("app-tracer").Start(r.Context(), "ListDeadLettersHandler")
+	defer span.End()
+
+	letters, err := h.webhooks.DeadLetters(ctx)
+	if err != nil {
+		http_handler.AbortJSON(ctx, w, err)
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to list dead letters")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("response.count", len(letters)))
+	http_handler.JSON(ctx, w, http.StatusOK, "Dead letters retrieved successfully", letters)
+	span.SetStatus(codes.Ok, "success")
+}
@@ -1,18 +1,275 @@
 package model
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"simple_lgtm/pkg/errs"
+)
 
 type DataItem struct {
-	ID    string `json:"id"`
-	Value string `json:"value"`
+	// Tenant is the namespace of the item, which is set from the request
+	// rather than read from it.
+	Tenant string `json:"tenant,omitempty"`
+	ID     string `json:"id"`
+	Value  Value  `json:"value"`
+	// Labels are set by clients to describe the item.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Version starts at 1 and is incremented by every update.
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt and DeletedBy are set while the item is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+	// ExpiresAt is when the item stops being visible. Clients may set it
+	// directly or through TTL, a duration such as "90s" or "24h" that is only
+	// read from requests.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       string     `json:"ttl,omitempty"`
+}
+
+func (v *DataItem) Deleted() bool {
+	return v.DeletedAt != nil
+}
+
+func (v *DataItem) Expired(now time.Time) bool {
+	return v.ExpiresAt != nil && !v.ExpiresAt.After(now)
+}
+
+// Expiry returns when an item requested at now expires, or nil if it does
+// not. It must only be called after Validate.
+func (v *DataItem) Expiry(now time.Time) *time.Time {
+	if v.TTL != "" {
+		ttl, _ := time.ParseDuration(v.TTL)
+		expiresAt := now.Add(ttl).UTC()
+		return &expiresAt
+	}
+	if v.ExpiresAt != nil {
+		expiresAt := v.ExpiresAt.UTC()
+		return &expiresAt
+	}
+	return nil
+}
+
+// ValidationError lists every invalid field of an item, by the JSON pointer
+// of the field within the item.
+type ValidationError struct {
+	Fields []errs.FieldError
 }
 
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return "invalid item: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) FieldErrors() []errs.FieldError {
+	return e.Fields
+}
+
+// At returns e with its fields moved below the JSON pointer prefix, for items
+// that are part of a larger document.
+func (e *ValidationError) At(prefix string) *ValidationError {
+	fields := make([]errs.FieldError, len(e.Fields))
+	for i, f := range e.Fields {
+		f.Field = prefix + f.Field
+		fields[i] = f
+	}
+	return &ValidationError{Fields: fields}
+}
+
+func (e *ValidationError) add(field, message string) {
+	e.Fields = append(e.Fields, errs.FieldError{Field: field, Message: message})
+}
+
+// err returns e, or nil if no field is invalid.
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the fields a client sets on an item. The error is a
+// *ValidationError.
 func (v *DataItem) Validate() error {
+	var invalid ValidationError
 	if v.ID == "" {
-		return fmt.Errorf("ID is required")
+		invalid.add("/id", "is required")
 	}
-	if v.Value == "" {
-		return fmt.Errorf("Value is required")
+	if v.Value.Null() {
+		invalid.add("/value", "is required")
 	}
-	return nil
+	invalid.labels(v.Labels)
+	if v.TTL != "" {
+		if v.ExpiresAt != nil {
+			invalid.add("/ttl", "must not be set together with expires_at")
+		}
+		ttl, err := time.ParseDuration(v.TTL)
+		if err != nil {
+			invalid.add("/ttl", fmt.Sprintf("must be a duration such as \"90s\" or \"24h\": %s", err))
+		} else if ttl <= 0 {
+			invalid.add("/ttl", "must be positive")
+		}
+	}
+	if v.ExpiresAt != nil && !v.ExpiresAt.After(time.Now()) {
+		invalid.add("/expires_at", "must be in the future")
+	}
+	return invalid.err()
+}
+
+// Tenant is a namespace of items. Ids are only unique within a tenant.
+type Tenant struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Blob is the metadata of a binary value stored under an id. Its content is
+// streamed separately. SHA256 is the hex SHA-256 of the content.
+type Blob struct {
+	Tenant      string    `json:"tenant,omitempty"`
+	ID          string    `json:"id"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Schema is a JSON Schema that the values of items must conform to. It
+// applies to the items whose id starts with Prefix in Tenant. Empty Tenant
+// and Prefix match every tenant and every id.
+type Schema struct {
+	ID        string          `json:"id"`
+	Tenant    string          `json:"tenant,omitempty"`
+	Prefix    string          `json:"prefix,omitempty"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Webhook subscribes a URL to the changes of items. Empty Tenant and
+// Operations match every tenant and every operation of create, update and
+// delete.
+type Webhook struct {
+	ID         string      `json:"id"`
+	URL        string      `json:"url"`
+	Tenant     string      `json:"tenant,omitempty"`
+	Operations []Operation `json:"operations,omitempty"`
+	// Secret signs the deliveries. It is only returned when the webhook is
+	// created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Notification is the body of a webhook delivery. Its ID is kept across
+// retries so that receivers can discard duplicates. Data is the item after a
+// create or update, and nil after a delete.
+type Notification struct {
+	ID        string    `json:"id"`
+	Operation Operation `json:"operation"`
+	Tenant    string    `json:"tenant"`
+	DataID    string    `json:"data_id"`
+	Data      *DataItem `json:"data,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DeadLetter is a notification that could not be delivered to a webhook.
+type DeadLetter struct {
+	WebhookID    string       `json:"webhook_id"`
+	Notification Notification `json:"notification"`
+	Attempts     int          `json:"attempts"`
+	Error        string       `json:"error"`
+	FailedAt     time.Time    `json:"failed_at"`
+}
+
+type Operation string
+
+const (
+	OperationCreate  Operation = "create"
+	OperationUpdate  Operation = "update"
+	OperationDelete  Operation = "delete"
+	OperationRestore Operation = "restore"
+)
+
+// Revision is the state of an item after one mutation. TraceID identifies the
+// trace of the request that made it.
+type Revision struct {
+	ID        string            `json:"id"`
+	Version   int64             `json:"version"`
+	Operation Operation         `json:"operation"`
+	Value     Value             `json:"value"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+	Actor     string            `json:"actor,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+	TraceID   string            `json:"trace_id,omitempty"`
+}
+
+// NewRevision records item as it is after op.
+func NewRevision(item DataItem, op Operation, traceID string) Revision {
+	return Revision{
+		ID:        item.ID,
+		Version:   item.Version,
+		Operation: op,
+		Value:     item.Value,
+		Labels:    maps.Clone(item.Labels),
+		ExpiresAt: item.ExpiresAt,
+		Actor:     item.DeletedBy,
+		Timestamp: item.UpdatedAt,
+		TraceID:   traceID,
+	}
+}
+
+// Event is a revision of an item as delivered by the change feed. Seq orders
+// the events of all tenants and identifies them when a watch is resumed.
+type Event struct {
+	Seq    int64  `json:"seq"`
+	Tenant string `json:"tenant"`
+	Revision
+}
+
+// Change is a field that differs between two revisions.
+type Change struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// Diff lists the changes from one revision of an item to another.
+type Diff struct {
+	ID          string   `json:"id"`
+	FromVersion int64    `json:"from_version"`
+	ToVersion   int64    `json:"to_version"`
+	Changes     []Change `json:"changes"`
+}
+
+func NewDiff(from Revision, to Revision) Diff {
+	diff := Diff{ID: from.ID, FromVersion: from.Version, ToVersion: to.Version, Changes: make([]Change, 0)}
+	if from.Value != to.Value {
+		diff.Changes = append(diff.Changes, Change{Field: "value", From: from.Value, To: to.Value})
+	}
+	if !maps.Equal(from.Labels, to.Labels) {
+		diff.Changes = append(diff.Changes, Change{Field: "labels", From: from.Labels, To: to.Labels})
+	}
+	if !equalTime(from.ExpiresAt, to.ExpiresAt) {
+		diff.Changes = append(diff.Changes, Change{Field: "expires_at", From: from.ExpiresAt, To: to.ExpiresAt})
+	}
+	if deleted, wasDeleted := to.Operation == OperationDelete, from.Operation == OperationDelete; deleted != wasDeleted {
+		diff.Changes = append(diff.Changes, Change{Field: "deleted", From: wasDeleted, To: deleted})
+	}
+	return diff
+}
+
+func equalTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
 }
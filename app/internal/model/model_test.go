@@ -0,0 +1,94 @@
+package model_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/pkg/errs"
+	"simple_lgtm/pkg/http_handler"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		item   model.DataItem
+		fields []string
+	}{
+		{"Valid", model.DataItem{ID: "1", Value: model.Value(`1`), TTL: "1h", Labels: map[string]string{"app.kubernetes.io/name": "api"}}, nil},
+		{"MissingID", model.DataItem{Value: model.Value(`1`)}, []string{"/id"}},
+		{"MissingValue", model.DataItem{ID: "1", Value: model.Value(`null`)}, []string{"/value"}},
+		{"InvalidTTL", model.DataItem{ID: "1", Value: model.Value(`1`), TTL: "soon"}, []string{"/ttl"}},
+		{"NegativeTTL", model.DataItem{ID: "1", Value: model.Value(`1`), TTL: "-1h"}, []string{"/ttl"}},
+		{"TTLAndExpiresAt", model.DataItem{ID: "1", Value: model.Value(`1`), TTL: "1h", ExpiresAt: &future}, []string{"/ttl"}},
+		{"PastExpiresAt", model.DataItem{ID: "1", Value: model.Value(`1`), ExpiresAt: &past}, []string{"/expires_at"}},
+		{"InvalidLabelKey", model.DataItem{ID: "1", Value: model.Value(`1`), Labels: map[string]string{"-a/b~": "x"}}, []string{"/labels/-a~1b~0"}},
+		{"LongLabelValue", model.DataItem{ID: "1", Value: model.Value(`1`), Labels: map[string]string{"env": strings.Repeat("x", 257)}}, []string{"/labels/env"}},
+		{"Several", model.DataItem{Value: model.Value(`1`), TTL: "soon", Labels: map[string]string{"b": "x", "-": "y"}}, []string{"/id", "/labels/-", "/ttl"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.item.Validate()
+			if tt.fields == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var invalid *model.ValidationError
+			require.ErrorAs(t, err, &invalid)
+			fields := make([]string, len(invalid.Fields))
+			for i, f := range invalid.Fields {
+				fields[i] = f.Field
+				assert.NotEmpty(t, f.Message)
+			}
+			assert.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, model.ValidateLabels(nil))
+
+	labels := make(map[string]string)
+	for i := range 65 {
+		labels[strings.Repeat("a", i+1)] = ""
+	}
+	var invalid *model.ValidationError
+	require.ErrorAs(t, model.ValidateLabels(labels), &invalid)
+	assert.Equal(t, "/labels", invalid.Fields[0].Field)
+}
+
+func TestValidationErrorProblem(t *testing.T) {
+	item := model.DataItem{Value: model.Value(`1`), TTL: "soon"}
+	w := httptest.NewRecorder()
+	http_handler.AbortJSON(t.Context(), w, errs.NewInvalidInput(item.Validate()))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	var body struct {
+		Code   string            `json:"code"`
+		Errors []errs.FieldError `json:"errors"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, "INVALID_INPUT", body.Code)
+	require.Len(t, body.Errors, 2)
+	assert.Equal(t, "/id", body.Errors[0].Field)
+	assert.Equal(t, "is required", body.Errors[0].Message)
+	assert.Equal(t, "/ttl", body.Errors[1].Field)
+
+	var invalid *model.ValidationError
+	require.ErrorAs(t, item.Validate(), &invalid)
+	w = httptest.NewRecorder()
+	http_handler.AbortJSON(t.Context(), w, errs.NewInvalidInput(invalid.At("/operations/2")))
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, "/operations/2/id", body.Errors[0].Field)
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Value is the JSON value of an item, kept in its compact encoding. Values
+// written before items held arbitrary JSON were strings, which is why a plain
+// string is still the most common value.
+type Value string
+
+// StringValue returns s as a JSON string.
+func StringValue(s string) Value {
+	b, _ := json.Marshal(s)
+	return Value(b)
+}
+
+// Null reports whether v is missing or JSON null.
+func (v Value) Null() bool {
+	return v == "" || v == "null"
+}
+
+// Text returns the string v holds if it is a JSON string, and its encoding
+// otherwise. Filters compare values by their text.
+func (v Value) Text() string {
+	var s string
+	if len(v) > 0 && v[0] == '"' && json.Unmarshal([]byte(v), &s) == nil {
+		return s
+	}
+	return string(v)
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v == "" {
+		return []byte("null"), nil
+	}
+	return []byte(v), nil
+}
+
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return err
+	}
+	*v = Value(buf.String())
+	return nil
+}
+
+const (
+	maxLabels          = 64
+	maxLabelValueBytes = 256
+)
+
+// labelKeyPattern allows keys such as "env" or "app.kubernetes.io/name".
+var labelKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_./-]{0,62}[a-zA-Z0-9])?$`)
+
+// pointerEscaper escapes a member name as a reference token of a JSON
+// pointer (RFC 6901).
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// ValidateLabels checks the labels a client sets on an item. The error is a
+// *ValidationError of the fields below "/labels".
+func ValidateLabels(labels map[string]string) error {
+	var invalid ValidationError
+	invalid.labels(labels)
+	return invalid.err()
+}
+
+func (e *ValidationError) labels(labels map[string]string) {
+	if len(labels) > maxLabels {
+		e.add("/labels", fmt.Sprintf("at most %d labels may be set", maxLabels))
+	}
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		field := "/labels/" + pointerEscaper.Replace(key)
+		if !labelKeyPattern.MatchString(key) {
+			e.add(field, "key must be 1 to 64 letters, digits, '_', '.', '/' or '-', starting and ending with a letter or digit")
+		}
+		if len(labels[key]) > maxLabelValueBytes {
+			e.add(field, fmt.Sprintf("must be at most %d bytes", maxLabelValueBytes))
+		}
+	}
+}
@@ -0,0 +1,441 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to the values of items.
+//
+// Values are decoded with numbers kept as json.Number, so that the parts of a
+// value that a patch does not touch are encoded again unchanged apart from
+// whitespace and the order of object members.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/pkg/errs"
+)
+
+const (
+	// MergePatchType is the media type of a JSON Merge Patch.
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is the media type of a JSON Patch.
+	JSONPatchType = "application/json-patch+json"
+)
+
+// ErrTestFailed is wrapped in the conflict error returned when a test
+// operation of a JSON Patch does not hold.
+var ErrTestFailed = errors.New("test operation failed")
+
+// Patch computes the new value of an item from its current one.
+type Patch interface {
+	Apply(current model.Value) (model.Value, error)
+}
+
+// Parse parses body as a patch of the given media type, which is typically
+// the Content-Type of a request.
+func Parse(mediaType string, body []byte) (Patch, error) {
+	mt, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return nil, errs.NewInvalidInput(fmt.Errorf("invalid media type %q of patch: %w", mediaType, err))
+	}
+	switch mt {
+	case MergePatchType:
+		return ParseMergePatch(body)
+	case JSONPatchType:
+		return ParseJSONPatch(body)
+	default:
+		return nil, errs.NewInvalidInput(fmt.Errorf("unsupported media type %q of patch, use %s or %s", mt, MergePatchType, JSONPatchType))
+	}
+}
+
+// MergePatch is a JSON Merge Patch. Members of an object patch that are null
+// remove the member, others are merged recursively, and any other patch
+// replaces the value.
+type MergePatch struct {
+	patch any
+}
+
+func ParseMergePatch(body []byte) (*MergePatch, error) {
+	patch, err := decode(body)
+	if err != nil {
+		return nil, errs.NewInvalidInput(fmt.Errorf("invalid merge patch: %w", err))
+	}
+	return &MergePatch{patch: patch}, nil
+}
+
+func (p *MergePatch) Apply(current model.Value) (model.Value, error) {
+	doc, err := decode([]byte(current))
+	if err != nil {
+		return "", errs.NewInternal(fmt.Errorf("failed to decode value: %w", err))
+	}
+	return encode(merge(doc, p.patch))
+}
+
+func merge(target any, patch any) any {
+	members, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	object, ok := target.(map[string]any)
+	if !ok {
+		object = make(map[string]any, len(members))
+	}
+	for name, value := range members {
+		if value == nil {
+			delete(object, name)
+		} else {
+			object[name] = merge(object[name], value)
+		}
+	}
+	return object
+}
+
+// Operation is one operation of a JSON Patch.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+
+	path  []string
+	from  []string
+	value any
+}
+
+// JSONPatch is a JSON Patch, whose operations are applied in order. If one
+// fails, the patch fails as a whole.
+type JSONPatch []Operation
+
+// ParseJSONPatch parses and checks every operation of body, so that a patch
+// that cannot apply to any value is rejected before the value is read.
+func ParseJSONPatch(body []byte) (JSONPatch, error) {
+	var ops JSONPatch
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, errs.NewInvalidInput(fmt.Errorf("invalid JSON patch: %w", err))
+	}
+	if ops == nil {
+		return nil, errs.NewInvalidInput(fmt.Errorf("invalid JSON patch: must be an array of operations"))
+	}
+	for i := range ops {
+		if err := ops[i].compile(); err != nil {
+			return nil, errs.NewInvalidInput(fmt.Errorf("invalid operation %d of JSON patch: %w", i, err))
+		}
+	}
+	return ops, nil
+}
+
+func (op *Operation) compile() error {
+	var err error
+	if op.path, err = parsePointer(op.Path); err != nil {
+		return fmt.Errorf("path: %w", err)
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("%s requires a value", op.Op)
+		}
+		if op.value, err = decode(op.Value); err != nil {
+			return fmt.Errorf("value: %w", err)
+		}
+	case "remove":
+		if len(op.path) == 0 {
+			return fmt.Errorf("cannot remove the whole value")
+		}
+	case "move", "copy":
+		if op.from, err = parsePointer(op.From); err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+		if op.Op == "move" && op.From != op.Path && strings.HasPrefix(op.Path, op.From+"/") {
+			return fmt.Errorf("cannot move %s into itself", pointer(op.From))
+		}
+	default:
+		return fmt.Errorf("unknown op %q", op.Op)
+	}
+	return nil
+}
+
+func (p JSONPatch) Apply(current model.Value) (model.Value, error) {
+	doc, err := decode([]byte(current))
+	if err != nil {
+		return "", errs.NewInternal(fmt.Errorf("failed to decode value: %w", err))
+	}
+	for i, op := range p {
+		if doc, err = op.apply(doc); err != nil {
+			if errors.Is(err, ErrTestFailed) {
+				return "", errs.NewConflict(fmt.Errorf("operation %d of JSON patch: %w", i, err))
+			}
+			return "", errs.NewInvalidInput(fmt.Errorf("operation %d of JSON patch: %w", i, err))
+		}
+	}
+	return encode(doc)
+}
+
+func (op *Operation) apply(doc any) (any, error) {
+	switch op.Op {
+	case "add":
+		return add(doc, op.path, deepCopy(op.value))
+	case "remove":
+		doc, _, err := remove(doc, op.path)
+		return doc, err
+	case "replace":
+		if _, err := get(doc, op.path); err != nil {
+			return nil, err
+		}
+		if len(op.path) == 0 {
+			return deepCopy(op.value), nil
+		}
+		doc, _, err := remove(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, deepCopy(op.value))
+	case "move":
+		if op.From == op.Path {
+			_, err := get(doc, op.from)
+			return doc, err
+		}
+		doc, value, err := remove(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, value)
+	case "copy":
+		value, err := get(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, deepCopy(value))
+	case "test":
+		value, err := get(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(value, op.value) {
+			return nil, fmt.Errorf("%w: %s is not %s", ErrTestFailed, pointer(op.Path), op.Value)
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// parsePointer splits a JSON pointer (RFC 6901) into its unescaped reference
+// tokens. The empty pointer refers to the whole value.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("%q is not a JSON pointer", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// pointer returns p for messages, where the whole value is "/".
+func pointer(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return p
+}
+
+// index returns the array index of token in an array of length n. With end
+// set, "-" and n refer to the position after the last element.
+func index(token string, n int, end bool) (int, error) {
+	if end && token == "-" {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || strings.Trim(token, "0123456789") != "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	if i > n || (i == n && !end) {
+		return 0, fmt.Errorf("index %d is out of range", i)
+	}
+	return i, nil
+}
+
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			doc = value
+		case []any:
+			i, err := index(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[i]
+		default:
+			return nil, fmt.Errorf("cannot find %q in a scalar", token)
+		}
+	}
+	return doc, nil
+}
+
+// modify returns doc with the container at the parent of path replaced by
+// the result of fn on it and the last token of path.
+func modify(doc any, path []string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	child, err := get(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = modify(child, path[1:], fn); err != nil {
+		return nil, err
+	}
+	switch container := doc.(type) {
+	case map[string]any:
+		container[path[0]] = child
+	case []any:
+		i, _ := index(path[0], len(container), false)
+		container[i] = child
+	}
+	return doc, nil
+}
+
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modify(doc, path, func(container any, token string) (any, error) {
+		switch container := container.(type) {
+		case map[string]any:
+			container[token] = value
+			return container, nil
+		case []any:
+			i, err := index(token, len(container), true)
+			if err != nil {
+				return nil, err
+			}
+			return append(container[:i], append([]any{value}, container[i:]...)...), nil
+		default:
+			return nil, fmt.Errorf("cannot add %q to a scalar", token)
+		}
+	})
+}
+
+// remove returns doc without the value at path, and that value.
+func remove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole value")
+	}
+	var removed any
+	doc, err := modify(doc, path, func(container any, token string) (any, error) {
+		switch container := container.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			removed = value
+			delete(container, token)
+			return container, nil
+		case []any:
+			i, err := index(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = container[i]
+			return append(container[:i:i], container[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("cannot remove %q from a scalar", token)
+		}
+	})
+	return doc, removed, err
+}
+
+// equal compares JSON values, where numbers are equal if their values are.
+func equal(a any, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for name, value := range a {
+			other, ok := b[name]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+		return errA == nil && errB == nil && x == y
+	default:
+		return a == b
+	}
+}
+
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for name, value := range v {
+			c[name] = deepCopy(value)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, value := range v {
+			c[i] = deepCopy(value)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func decode(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+func encode(v any) (model.Value, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", errs.NewInternal(fmt.Errorf("failed to encode value: %w", err))
+	}
+	return model.Value(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
@@ -0,0 +1,123 @@
+package patch_test
+
+import (
+	"errors"
+	"testing"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/patch"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	for _, tc := range []struct {
+		current string
+		patch   string
+		want    string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`"a"`, `{"b":"c","d":null}`, `{"b":"c"}`},
+		{`{"n":12345678901234567890}`, `{"m":"<&>"}`, `{"m":"<&>","n":12345678901234567890}`},
+	} {
+		p, err := patch.Parse(patch.MergePatchType, []byte(tc.patch))
+		require.NoError(t, err, tc.patch)
+		got, err := p.Apply(model.Value(tc.current))
+		require.NoError(t, err, tc.patch)
+		assert.Equal(t, model.Value(tc.want), got, "%s merged into %s", tc.patch, tc.current)
+	}
+
+	_, err := patch.Parse(patch.MergePatchType, []byte(`{"a":`))
+	assert.True(t, errs.IsInvalidInput(err))
+}
+
+func TestJSONPatch(t *testing.T) {
+	const doc = `{"foo":"bar","list":[1,2,3],"obj":{"a/b":1,"m~n":2}}`
+
+	for _, tc := range []struct {
+		patch string
+		want  string
+	}{
+		{`[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar","list":[1,2,3],"obj":{"a/b":1,"m~n":2}}`},
+		{`[{"op":"add","path":"/list/1","value":9}]`, `{"foo":"bar","list":[1,9,2,3],"obj":{"a/b":1,"m~n":2}}`},
+		{`[{"op":"add","path":"/list/-","value":null}]`, `{"foo":"bar","list":[1,2,3,null],"obj":{"a/b":1,"m~n":2}}`},
+		{`[{"op":"remove","path":"/list/0"},{"op":"remove","path":"/obj/a~1b"}]`, `{"foo":"bar","list":[2,3],"obj":{"m~n":2}}`},
+		{`[{"op":"replace","path":"/obj/m~0n","value":[true]}]`, `{"foo":"bar","list":[1,2,3],"obj":{"a/b":1,"m~n":[true]}}`},
+		{`[{"op":"move","from":"/foo","path":"/obj/foo"}]`, `{"list":[1,2,3],"obj":{"a/b":1,"foo":"bar","m~n":2}}`},
+		{`[{"op":"move","from":"/list/0","path":"/list/-"}]`, `{"foo":"bar","list":[2,3,1],"obj":{"a/b":1,"m~n":2}}`},
+		{`[{"op":"copy","from":"/obj","path":"/copy"},{"op":"remove","path":"/copy/a~1b"}]`, `{"copy":{"m~n":2},"foo":"bar","list":[1,2,3],"obj":{"a/b":1,"m~n":2}}`},
+		{`[{"op":"test","path":"/list","value":[1,2,3.0]},{"op":"test","path":"/obj","value":{"m~n":2,"a/b":1}}]`, doc},
+		{`[{"op":"replace","path":"","value":{"new":1}}]`, `{"new":1}`},
+		{`[]`, doc},
+	} {
+		p, err := patch.Parse(patch.JSONPatchType, []byte(tc.patch))
+		require.NoError(t, err, tc.patch)
+		got, err := p.Apply(model.Value(doc))
+		require.NoError(t, err, tc.patch)
+		assert.Equal(t, model.Value(tc.want), got, tc.patch)
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	const doc = `{"foo":"bar","list":[1,2,3]}`
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, body := range []string{
+			`{"op":"add"}`,
+			`[{"op":"jump","path":"/a"}]`,
+			`[{"op":"add","path":"a","value":1}]`,
+			`[{"op":"add","path":"/a"}]`,
+			`[{"op":"remove","path":""}]`,
+			`[{"op":"move","from":"/a","path":"/a/b"}]`,
+			`null`,
+		} {
+			_, err := patch.Parse(patch.JSONPatchType, []byte(body))
+			assert.True(t, errs.IsInvalidInput(err), body)
+		}
+	})
+
+	t.Run("NotApplicable", func(t *testing.T) {
+		for _, body := range []string{
+			`[{"op":"remove","path":"/missing"}]`,
+			`[{"op":"replace","path":"/missing","value":1}]`,
+			`[{"op":"add","path":"/list/4","value":1}]`,
+			`[{"op":"add","path":"/list/01","value":1}]`,
+			`[{"op":"add","path":"/foo/bar","value":1}]`,
+			`[{"op":"add","path":"/missing/a","value":1}]`,
+			`[{"op":"copy","from":"/list/3","path":"/a"}]`,
+		} {
+			p, err := patch.Parse(patch.JSONPatchType, []byte(body))
+			require.NoError(t, err, body)
+			_, err = p.Apply(model.Value(doc))
+			assert.True(t, errs.IsInvalidInput(err), body)
+		}
+	})
+
+	t.Run("TestFailed", func(t *testing.T) {
+		p, err := patch.Parse(patch.JSONPatchType, []byte(`[{"op":"replace","path":"/foo","value":"baz"},{"op":"test","path":"/foo","value":"bar"}]`))
+		require.NoError(t, err)
+		_, err = p.Apply(model.Value(doc))
+		assert.True(t, errs.IsConflict(err))
+		assert.True(t, errors.Is(err, patch.ErrTestFailed))
+	})
+}
+
+func TestParse(t *testing.T) {
+	_, err := patch.Parse("application/json-patch+json; charset=utf-8", []byte(`[]`))
+	assert.NoError(t, err)
+	_, err = patch.Parse("application/json", []byte(`{}`))
+	assert.True(t, errs.IsInvalidInput(err))
+	_, err = patch.Parse("", []byte(`{}`))
+	assert.True(t, errs.IsInvalidInput(err))
+}
@@ -0,0 +1,285 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"simple_lgtm/internal/model"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type CacheOptions struct {
+	// Size is the maximum number of cached items.
+	Size int
+	// TTL is how long an item is cached at most. Items that expire earlier
+	// are only cached until they expire.
+	TTL time.Duration
+	// RequestCounter counts lookups by result, "hit" or "miss".
+	RequestCounter *prometheus.CounterVec
+	// EvictionCounter counts removed items by reason, "capacity", "expired"
+	// or "invalidated".
+	EvictionCounter *prometheus.CounterVec
+}
+
+// cachingRepository is a read-through cache of GetData in front of another
+// Repository. Writes go to the backend and invalidate the item, and
+// concurrent misses of the same item share a single read.
+type cachingRepository struct {
+	Repository
+	opts CacheOptions
+
+	mu       sync.Mutex
+	items    map[itemKey]*list.Element
+	lru      *list.List
+	inflight map[itemKey]*cacheCall
+}
+
+type cacheEntry struct {
+	item      model.DataItem
+	expiresAt time.Time
+}
+
+// cacheCall is a read of the backend that other misses of the same item wait
+// for. It is not cached if the item was invalidated while it ran.
+type cacheCall struct {
+	done  chan struct{}
+	item  model.DataItem
+	err   error
+	stale bool
+}
+
+// NewCachingRepository returns repo with a cache in front of GetData. It
+// keeps the snapshots of repo, if it supports them.
+func NewCachingRepository(repo Repository, opts CacheOptions) Repository {
+	c := &cachingRepository{
+		Repository: repo,
+		opts:       opts,
+		items:      make(map[itemKey]*list.Element),
+		lru:        list.New(),
+		inflight:   make(map[itemKey]*cacheCall),
+	}
+	if snapshotter, ok := repo.(Snapshotter); ok {
+		return &cachingSnapshotRepository{c, snapshotter}
+	}
+	return c
+}
+
+func (c *cachingRepository) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	caller := trace.SpanFromContext(ctx)
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "GetDataFromCache")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("data.id", id))
+
+	key := scopedKey(ctx, id)
+	c.mu.Lock()
+	if item, ok := c.lookup(key, time.Now()); ok {
+		c.mu.Unlock()
+		c.opts.RequestCounter.WithLabelValues("hit").Inc()
+		caller.SetAttributes(attribute.Bool("cache.hit", true))
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		span.SetStatus(codes.Ok, "success")
+		return item, nil
+	}
+	c.opts.RequestCounter.WithLabelValues("miss").Inc()
+	caller.SetAttributes(attribute.Bool("cache.hit", false))
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+
+	call, coalesced := c.inflight[key]
+	if !coalesced {
+		call = &cacheCall{done: make(chan struct{})}
+		c.inflight[key] = call
+	}
+	c.mu.Unlock()
+
+	span.SetAttributes(attribute.Bool("cache.coalesced", coalesced))
+	if coalesced {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			span.SetStatus(codes.Error, "canceled")
+			return model.DataItem{}, ctx.Err()
+		}
+	} else {
+		call.item, call.err = c.Repository.GetData(ctx, id)
+		c.mu.Lock()
+		if c.inflight[key] == call {
+			delete(c.inflight, key)
+		}
+		if call.err == nil && !call.stale {
+			c.store(call.item, time.Now())
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}
+
+	if call.err != nil {
+		span.SetStatus(codes.Error, "failed to get data")
+		return model.DataItem{}, call.err
+	}
+	span.SetStatus(codes.Ok, "success")
+	return call.item, nil
+}
+
+func (c *cachingRepository) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	defer c.invalidate(scopedKey(ctx, id))
+	return c.Repository.CreateData(ctx, id, value, labels, expiresAt)
+}
+
+func (c *cachingRepository) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	defer c.invalidate(scopedKey(ctx, id))
+	return c.Repository.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
+}
+
+func (c *cachingRepository) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	defer c.invalidate(scopedKey(ctx, id))
+	return c.Repository.DeleteData(ctx, id, expectedVersion, actor)
+}
+
+func (c *cachingRepository) RestoreData(ctx context.Context, id string) (model.DataItem, error) {
+	defer c.invalidate(scopedKey(ctx, id))
+	return c.Repository.RestoreData(ctx, id)
+}
+
+// DeleteTenant drops the whole cache rather than finding the items of the
+// tenant in it.
+func (c *cachingRepository) DeleteTenant(ctx context.Context, name string) (int, error) {
+	defer c.clear()
+	return c.Repository.DeleteTenant(ctx, name)
+}
+
+// lookup returns the cached item of key unless it is missing or outdated.
+// c.mu must be held.
+func (c *cachingRepository) lookup(key itemKey, now time.Time) (model.DataItem, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return model.DataItem{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !now.Before(entry.expiresAt) {
+		c.remove(elem, "expired")
+		return model.DataItem{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.item, true
+}
+
+// store caches item and evicts the least recently used items beyond the
+// size. c.mu must be held.
+func (c *cachingRepository) store(item model.DataItem, now time.Time) {
+	if c.opts.Size <= 0 {
+		return
+	}
+	entry := &cacheEntry{item: item, expiresAt: now.Add(c.opts.TTL)}
+	if item.ExpiresAt != nil && item.ExpiresAt.Before(entry.expiresAt) {
+		entry.expiresAt = *item.ExpiresAt
+	}
+	key := itemKey{tenant: item.Tenant, id: item.ID}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.opts.Size {
+		c.remove(c.lru.Back(), "capacity")
+	}
+}
+
+// remove evicts elem for reason. c.mu must be held.
+func (c *cachingRepository) remove(elem *list.Element, reason string) {
+	c.lru.Remove(elem)
+	item := elem.Value.(*cacheEntry).item
+	delete(c.items, itemKey{tenant: item.Tenant, id: item.ID})
+	c.opts.EvictionCounter.WithLabelValues(reason).Inc()
+}
+
+// invalidate drops the cached item of key and keeps a read of it that is
+// still running from being cached.
+func (c *cachingRepository) invalidate(key itemKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if call, ok := c.inflight[key]; ok {
+		call.stale = true
+		delete(c.inflight, key)
+	}
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem, "invalidated")
+	}
+}
+
+// clear drops every cached item.
+func (c *cachingRepository) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, call := range c.inflight {
+		call.stale = true
+	}
+	clear(c.inflight)
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back(), "invalidated")
+	}
+}
+
+// InTx invalidates every item written by the transaction, whether it commits
+// or not.
+func (c *cachingRepository) InTx(ctx context.Context, fn func(tx Tx) error) error {
+	var written []itemKey
+	defer func() {
+		for _, key := range written {
+			c.invalidate(key)
+		}
+	}()
+	return c.Repository.InTx(ctx, func(tx Tx) error {
+		return fn(&cachingTx{Tx: tx, written: &written})
+	})
+}
+
+// cachingTx records the items written in a transaction. Reads bypass the
+// cache so that they see the writes of the transaction.
+type cachingTx struct {
+	Tx
+	written *[]itemKey
+}
+
+func (tx *cachingTx) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	*tx.written = append(*tx.written, scopedKey(ctx, id))
+	return tx.Tx.CreateData(ctx, id, value, labels, expiresAt)
+}
+
+func (tx *cachingTx) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	*tx.written = append(*tx.written, scopedKey(ctx, id))
+	return tx.Tx.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
+}
+
+func (tx *cachingTx) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	*tx.written = append(*tx.written, scopedKey(ctx, id))
+	return tx.Tx.DeleteData(ctx, id, expectedVersion, actor)
+}
+
+// cachingSnapshotRepository is a cachingRepository of a repository that is
+// also a Snapshotter.
+type cachingSnapshotRepository struct {
+	*cachingRepository
+	snapshotter Snapshotter
+}
+
+func (c *cachingSnapshotRepository) WriteSnapshot(ctx context.Context, w io.Writer) (int, error) {
+	return c.snapshotter.WriteSnapshot(ctx, w)
+}
+
+// ReadSnapshot drops the whole cache, as every item may have changed.
+func (c *cachingSnapshotRepository) ReadSnapshot(ctx context.Context, r io.Reader) (int, error) {
+	defer c.clear()
+	return c.snapshotter.ReadSnapshot(ctx, r)
+}
@@ -0,0 +1,122 @@
+package repository_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCacheOptions(size int, ttl time.Duration) repository.CacheOptions {
+	return repository.CacheOptions{
+		Size:            size,
+		TTL:             ttl,
+		RequestCounter:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"result"}),
+		EvictionCounter: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "evictions"}, []string{"reason"}),
+	}
+}
+
+// slowRepository counts the reads that reach it and blocks them until
+// release is closed.
+type slowRepository struct {
+	repository.Repository
+	reads   atomic.Int32
+	release chan struct{}
+}
+
+func (r *slowRepository) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	r.reads.Add(1)
+	<-r.release
+	return r.Repository.GetData(ctx, id)
+}
+
+func TestCachingRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T, expiry repository.ExpiryOptions) repository.Repository {
+		repo := repository.NewCachingRepository(repository.NewInMemoryRepository(expiry), newCacheOptions(100, time.Minute))
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+
+	t.Run("HitsAndInvalidation", func(t *testing.T) {
+		ctx := context.Background()
+		opts := newCacheOptions(100, time.Minute)
+		repo := repository.NewCachingRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), opts)
+		defer repo.Close()
+
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		repo.GetData(ctx, "1")
+		item, err := repo.GetData(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "value1", item.Value.Text())
+		assert.Equal(t, 1.0, testutil.ToFloat64(opts.RequestCounter.WithLabelValues("miss")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(opts.RequestCounter.WithLabelValues("hit")))
+
+		repo.UpdateData(ctx, "1", model.StringValue("newValue1"), nil, nil, repository.AnyVersion)
+		assert.Equal(t, 1.0, testutil.ToFloat64(opts.EvictionCounter.WithLabelValues("invalidated")))
+		item, err = repo.GetData(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "newValue1", item.Value.Text())
+
+		err = repo.InTx(ctx, func(tx repository.Tx) error {
+			_, err := tx.DeleteData(ctx, "1", repository.AnyVersion, "tester")
+			return err
+		})
+		assert.NoError(t, err)
+		_, err = repo.GetData(ctx, "1")
+		assert.Error(t, err)
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		ctx := context.Background()
+		opts := newCacheOptions(2, 20*time.Millisecond)
+		repo := repository.NewCachingRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), opts)
+		defer repo.Close()
+
+		for _, id := range []string{"1", "2", "3"} {
+			repo.CreateData(ctx, id, model.StringValue("value"), nil, nil)
+			repo.GetData(ctx, id)
+		}
+		assert.Equal(t, 1.0, testutil.ToFloat64(opts.EvictionCounter.WithLabelValues("capacity")))
+
+		time.Sleep(30 * time.Millisecond)
+		repo.GetData(ctx, "3")
+		assert.Equal(t, 1.0, testutil.ToFloat64(opts.EvictionCounter.WithLabelValues("expired")))
+		assert.Equal(t, 4.0, testutil.ToFloat64(opts.RequestCounter.WithLabelValues("miss")))
+	})
+
+	t.Run("CoalescesMisses", func(t *testing.T) {
+		ctx := context.Background()
+		backend := &slowRepository{
+			Repository: repository.NewInMemoryRepository(repository.ExpiryOptions{}),
+			release:    make(chan struct{}),
+		}
+		repo := repository.NewCachingRepository(backend, newCacheOptions(100, time.Minute))
+		defer repo.Close()
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				item, err := repo.GetData(ctx, "1")
+				assert.NoError(t, err)
+				assert.Equal(t, "value1", item.Value.Text())
+			}()
+		}
+		assert.Eventually(t, func() bool { return backend.reads.Load() > 0 }, time.Second, time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+		close(backend.release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), backend.reads.Load())
+	})
+}
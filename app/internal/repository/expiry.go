@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExpiryOptions configures how a repository removes expired items. Expired
+// items are hidden from reads whether or not they have been removed yet.
+type ExpiryOptions struct {
+	// SweepInterval is how often expired items are removed. Zero disables
+	// the sweeper.
+	SweepInterval time.Duration
+	// ExpiredCounter counts the removed items. It may be nil.
+	ExpiredCounter prometheus.Counter
+}
+
+// sweeper periodically calls sweep until it is closed. Every run starts its
+// own trace, as it is not caused by a request.
+type sweeper struct {
+	opts  ExpiryOptions
+	sweep func(ctx context.Context, now time.Time) (int, error)
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startSweeper(opts ExpiryOptions, sweep func(ctx context.Context, now time.Time) (int, error)) *sweeper {
+	s := &sweeper{
+		opts:  opts,
+		sweep: sweep,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *sweeper) run() {
+	defer close(s.done)
+	if s.opts.SweepInterval <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(s.opts.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.runOnce()
+		}
+	}
+}
+
+func (s *sweeper) runOnce() {
+	ctx, span := otel.Tracer("app-tracer").Start(context.Background(), "SweepExpiredData", trace.WithNewRoot())
+	defer span.End()
+
+	swept, err := s.sweep(ctx, time.Now())
+	if s.opts.ExpiredCounter != nil {
+		s.opts.ExpiredCounter.Add(float64(swept))
+	}
+	span.SetAttributes(attribute.Int("data.expired", swept))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to sweep expired data", slog.Any("error", err))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to sweep expired data")
+		return
+	}
+	span.SetStatus(codes.Ok, "success")
+}
+
+// close stops the sweeper and waits for a running sweep to finish.
+func (s *sweeper) close() {
+	close(s.stop)
+	<-s.done
+}
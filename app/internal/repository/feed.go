@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"sync"
+
+	"simple_lgtm/internal/model"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// subscriptionBuffer is how many live events a subscription holds before it
+// is considered too slow and dropped.
+const subscriptionBuffer = 64
+
+// Feed numbers the changes written through NewPublishingRepository and
+// delivers them to subscriptions. The latest changes are kept in a ring
+// buffer, so that a subscriber that reconnects with the sequence number of
+// the last event it saw receives the ones it missed.
+//
+// Sequence numbers start over when the process restarts.
+type Feed struct {
+	mu     sync.Mutex
+	seq    int64
+	buffer []model.Event
+	subs   map[*Subscription]struct{}
+	closed bool
+
+	subscribers prometheus.Gauge
+	dropped     prometheus.Counter
+}
+
+// Subscription receives the events of a Feed that it matches.
+type Subscription struct {
+	feed   *Feed
+	match  func(model.Event) bool
+	events chan model.Event
+}
+
+// NewFeed returns a Feed that replays up to size events. subscribers tracks
+// the open subscriptions and dropped counts those that were closed because
+// they fell behind.
+func NewFeed(size int, subscribers prometheus.Gauge, dropped prometheus.Counter) *Feed {
+	return &Feed{
+		buffer:      make([]model.Event, size),
+		subs:        make(map[*Subscription]struct{}),
+		subscribers: subscribers,
+		dropped:     dropped,
+	}
+}
+
+// publish numbers events in order and delivers them.
+func (f *Feed) publish(events ...model.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, event := range events {
+		f.seq++
+		event.Seq = f.seq
+		if len(f.buffer) > 0 {
+			f.buffer[f.seq%int64(len(f.buffer))] = event
+		}
+		for sub := range f.subs {
+			if !sub.match(event) {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+				f.remove(sub)
+				f.dropped.Inc()
+			}
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events that match and come after
+// the one numbered after, or to all new events if after is 0. It reports
+// false if some of the events after it are no longer buffered, in which case
+// the subscription starts with the new events. Once f is closed, the
+// subscription is closed from the start.
+func (f *Feed) Subscribe(after int64, match func(model.Event) bool) (*Subscription, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		sub := &Subscription{feed: f, match: match, events: make(chan model.Event)}
+		close(sub.events)
+		return sub, true
+	}
+
+	oldest := max(f.seq-int64(len(f.buffer))+1, 1)
+	complete := after == 0 || (after >= oldest-1 && after <= f.seq)
+
+	var backlog []model.Event
+	if after != 0 && complete {
+		for seq := after + 1; seq <= f.seq; seq++ {
+			if event := f.buffer[seq%int64(len(f.buffer))]; match(event) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	sub := &Subscription{feed: f, match: match, events: make(chan model.Event, len(backlog)+subscriptionBuffer)}
+	for _, event := range backlog {
+		sub.events <- event
+	}
+	f.subs[sub] = struct{}{}
+	f.subscribers.Inc()
+	return sub, complete
+}
+
+// Close closes all subscriptions of f and any made later, so that watchers
+// stop waiting for events when the server shuts down. Events are still
+// numbered and buffered after f is closed.
+func (f *Feed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for sub := range f.subs {
+		f.remove(sub)
+	}
+}
+
+// Events delivers the events of s in order. It is closed when s is closed,
+// dropped for falling behind or when its feed is closed.
+func (s *Subscription) Events() <-chan model.Event {
+	return s.events
+}
+
+// Close stops the delivery of events to s.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	s.feed.remove(s)
+}
+
+// remove closes sub unless it was already removed. f.mu must be held.
+func (f *Feed) remove(sub *Subscription) {
+	if _, ok := f.subs[sub]; !ok {
+		return
+	}
+	delete(f.subs, sub)
+	close(sub.events)
+	f.subscribers.Dec()
+}
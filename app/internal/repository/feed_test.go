@@ -0,0 +1,279 @@
+package repository_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/internal/tenant"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newFeed(size int) (*repository.Feed, prometheus.Gauge, prometheus.Counter) {
+	subscribers := prometheus.NewGauge(prometheus.GaugeOpts{Name: "subscribers"})
+	dropped := prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"})
+	return repository.NewFeed(size, subscribers, dropped), subscribers, dropped
+}
+
+func all(model.Event) bool { return true }
+
+// receive returns the events that are ready on sub.
+func receive(sub *repository.Subscription) []model.Event {
+	var events []model.Event
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestPublishingRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T, expiry repository.ExpiryOptions) repository.Repository {
+		feed, _, _ := newFeed(16)
+		repo := repository.NewPublishingRepository(repository.NewInMemoryRepository(expiry), feed)
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+
+	t.Run("PublishesWrites", func(t *testing.T) {
+		feed, subscribers, _ := newFeed(16)
+		repo := repository.NewPublishingRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), feed)
+		defer repo.Close()
+
+		sub, complete := feed.Subscribe(0, all)
+		assert.True(t, complete)
+		assert.Equal(t, 1.0, testutil.ToFloat64(subscribers))
+
+		traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+		spanID, _ := trace.SpanIDFromHex("0102030405060708")
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+		ctx = tenant.WithTenant(ctx, "acme")
+		_, err := repo.CreateTenant(ctx, "acme", 10)
+		require.NoError(t, err)
+
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		repo.UpdateData(ctx, "1", model.StringValue("value2"), nil, nil, repository.AnyVersion)
+		_, err = repo.UpdateData(ctx, "missing", model.StringValue("value"), nil, nil, repository.AnyVersion)
+		assert.Error(t, err)
+		deleted, err := repo.DeleteData(ctx, "1", repository.AnyVersion, "tester")
+		require.NoError(t, err)
+		repo.RestoreData(ctx, "1")
+		err = repo.InTx(ctx, func(tx repository.Tx) error {
+			tx.CreateData(ctx, "2", model.StringValue("value"), nil, nil)
+			_, err := tx.DeleteData(ctx, "2", repository.AnyVersion, "tester")
+			return err
+		})
+		require.NoError(t, err)
+
+		events := receive(sub)
+		if !assert.Len(t, events, 6) {
+			return
+		}
+		operations := []model.Operation{model.OperationCreate, model.OperationUpdate, model.OperationDelete, model.OperationRestore, model.OperationCreate, model.OperationDelete}
+		for i, event := range events {
+			assert.Equal(t, int64(i+1), event.Seq)
+			assert.Equal(t, operations[i], event.Operation)
+			assert.Equal(t, "acme", event.Tenant)
+			assert.Equal(t, traceID.String(), event.TraceID)
+		}
+		assert.Equal(t, int64(3), events[2].Version)
+		assert.Equal(t, "tester", events[2].Actor)
+
+		assert.True(t, deleted.UpdatedAt.Equal(events[2].Timestamp))
+
+		history, _ := repo.GetHistory(ctx, "1")
+		for i, rev := range history {
+			assert.Equal(t, rev.Version, events[i].Version)
+			assert.True(t, rev.Timestamp.Equal(events[i].Timestamp))
+		}
+
+		sub.Close()
+		sub.Close()
+		assert.Equal(t, 0.0, testutil.ToFloat64(subscribers))
+	})
+
+	t.Run("DiscardsFailedTransaction", func(t *testing.T) {
+		feed, _, _ := newFeed(16)
+		repo := repository.NewPublishingRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), feed)
+		defer repo.Close()
+
+		sub, _ := feed.Subscribe(0, all)
+		defer sub.Close()
+
+		ctx := context.Background()
+		err := repo.InTx(ctx, func(tx repository.Tx) error {
+			tx.CreateData(ctx, "1", model.StringValue("value"), nil, nil)
+			_, err := tx.UpdateData(ctx, "missing", model.StringValue("value"), nil, nil, repository.AnyVersion)
+			return err
+		})
+		assert.Error(t, err)
+		assert.Empty(t, receive(sub))
+	})
+
+	t.Run("OrdersWritesOfAnItem", func(t *testing.T) {
+		feed, _, _ := newFeed(16)
+		backend := newHeldUpdateRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), 2)
+		repo := repository.NewPublishingRepository(backend, feed)
+		defer repo.Close()
+
+		sub, _ := feed.Subscribe(0, all)
+		defer sub.Close()
+
+		ctx := context.Background()
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repo.UpdateData(ctx, "1", model.StringValue("value2"), nil, nil, repository.AnyVersion)
+		}()
+		// Version 3 is written while the write of version 2 is yet to
+		// return.
+		<-backend.written
+		_, err := repo.UpdateData(ctx, "1", model.StringValue("value3"), nil, nil, repository.AnyVersion)
+		require.NoError(t, err)
+
+		// Other items are not held back by the running write.
+		err = repo.InTx(ctx, func(tx repository.Tx) error {
+			_, err := tx.CreateData(ctx, "2", model.StringValue("value"), nil, nil)
+			return err
+		})
+		require.NoError(t, err)
+		events := receive(sub)
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, "1", events[0].ID)
+			assert.Equal(t, "2", events[1].ID)
+		}
+
+		close(backend.release)
+		wg.Wait()
+		events = receive(sub)
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, int64(2), events[0].Version)
+			assert.Equal(t, int64(3), events[1].Version)
+		}
+	})
+}
+
+// heldUpdateRepository holds back the return of the update that writes
+// version, once it is stored, until release is closed. It closes written
+// meanwhile.
+type heldUpdateRepository struct {
+	repository.Repository
+	version int64
+	written chan struct{}
+	release chan struct{}
+}
+
+func newHeldUpdateRepository(repo repository.Repository, version int64) *heldUpdateRepository {
+	return &heldUpdateRepository{Repository: repo, version: version, written: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (r *heldUpdateRepository) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	item, err := r.Repository.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
+	if err == nil && item.Version == r.version {
+		close(r.written)
+		<-r.release
+	}
+	return item, err
+}
+
+func TestFeed(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Resume", func(t *testing.T) {
+		feed, _, _ := newFeed(4)
+		repo := repository.NewPublishingRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), feed)
+		defer repo.Close()
+
+		for _, id := range []string{"a1", "b1", "a2", "b2", "a3", "a4"} {
+			repo.CreateData(ctx, id, model.StringValue("value"), nil, nil)
+		}
+
+		// Events 3 to 6 are buffered.
+		sub, complete := feed.Subscribe(2, func(event model.Event) bool { return event.ID[0] == 'a' })
+		assert.True(t, complete)
+		events := receive(sub)
+		if assert.Len(t, events, 3) {
+			assert.Equal(t, "a2", events[0].ID)
+			assert.Equal(t, int64(3), events[0].Seq)
+			assert.Equal(t, "a4", events[2].ID)
+		}
+		sub.Close()
+
+		sub, complete = feed.Subscribe(6, all)
+		assert.True(t, complete)
+		assert.Empty(t, receive(sub))
+		sub.Close()
+
+		// Event 2 is no longer buffered, and event 100 is from before a restart.
+		for _, after := range []int64{1, 100} {
+			sub, complete = feed.Subscribe(after, all)
+			assert.False(t, complete)
+			assert.Empty(t, receive(sub))
+			repo.CreateData(ctx, "c", model.StringValue("value"), nil, nil)
+			assert.Len(t, receive(sub), 1)
+			repo.DeleteData(ctx, "c", repository.AnyVersion, "tester")
+			sub.Close()
+		}
+	})
+
+	t.Run("DropsSlowSubscription", func(t *testing.T) {
+		feed, subscribers, dropped := newFeed(4)
+		repo := repository.NewPublishingRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), feed)
+		defer repo.Close()
+
+		sub, _ := feed.Subscribe(0, all)
+		item, _ := repo.CreateData(ctx, "1", model.StringValue("value"), nil, nil)
+		for i := 0; i < 100; i++ {
+			item, _ = repo.UpdateData(ctx, "1", model.StringValue("value"), nil, nil, item.Version)
+		}
+
+		events := 0
+		for range sub.Events() {
+			events++
+		}
+		assert.Less(t, events, 101)
+		assert.Equal(t, 1.0, testutil.ToFloat64(dropped))
+		assert.Equal(t, 0.0, testutil.ToFloat64(subscribers))
+		sub.Close()
+	})
+	t.Run("Close", func(t *testing.T) {
+		feed, subscribers, _ := newFeed(4)
+		repo := repository.NewPublishingRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), feed)
+		defer repo.Close()
+
+		sub, _ := feed.Subscribe(0, all)
+		repo.CreateData(ctx, "1", model.StringValue("value"), nil, nil)
+		feed.Close()
+
+		events := 0
+		for range sub.Events() {
+			events++
+		}
+		assert.Equal(t, 1, events)
+		assert.Equal(t, 0.0, testutil.ToFloat64(subscribers))
+		sub.Close()
+
+		_, err := repo.CreateData(ctx, "2", model.StringValue("value"), nil, nil)
+		require.NoError(t, err)
+		sub, _ = feed.Subscribe(0, all)
+		_, ok := <-sub.Events()
+		assert.False(t, ok)
+		sub.Close()
+	})
+}
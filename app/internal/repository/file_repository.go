@@ -0,0 +1,424 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/pkg/errs"
+)
+
+type FileOptions struct {
+	// Path of the write-ahead log.
+	Path string
+	// SyncBatchSize is the number of records that triggers an fsync.
+	SyncBatchSize int
+	// SyncInterval is the longest a record waits to be fsynced.
+	SyncInterval time.Duration
+	// CompactInterval is how often the log is rewritten from the current
+	// state. Zero disables compaction.
+	CompactInterval time.Duration
+	Expiry          ExpiryOptions
+}
+
+// fileRepository keeps its state in an inMemoryRepository and makes every
+// mutation durable through a write-ahead log that is replayed on startup.
+//
+// A failure to write or fsync the log is fatal: the in-memory state may then
+// hold writes that are not durable, so every later read and write reports
+// the repository as unavailable until it is restarted and the log replayed.
+type fileRepository struct {
+	mu      sync.Mutex
+	mem     *inMemoryRepository
+	wal     *wal
+	sweeper *sweeper
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewFileRepository(ctx context.Context, opts FileOptions) (Repository, error) {
+	mem := newInMemoryRepository()
+	w, err := openWAL(ctx, opts.Path, opts.SyncBatchSize, opts.SyncInterval, mem.replay)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &fileRepository{
+		mem:  mem,
+		wal:  w,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go r.runCompaction(opts.CompactInterval)
+	r.sweeper = startSweeper(opts.Expiry, r.sweepExpired)
+	return r, nil
+}
+
+// replay applies a record of the log to the in-memory state.
+func (r *inMemoryRepository) replay(rec walRecord) {
+	next := &entry{item: withTenant(rec.Item)}
+	switch rec.Op {
+	case walOpPut:
+		if current, ok := r.load(next.key()); ok {
+			next.history = current.history
+		}
+		if rec.Revision != nil {
+			next.history = &revisionNode{Revision: *rec.Revision, prev: next.history}
+		}
+		r.data.Store(next.key(), next)
+	case walOpRevision:
+		if current, ok := r.load(next.key()); ok && rec.Revision != nil {
+			current.history = &revisionNode{Revision: *rec.Revision, prev: current.history}
+		}
+	case walOpDelete:
+		r.data.Delete(next.key())
+	case walOpBatch:
+		for _, nested := range rec.Records {
+			r.replay(nested)
+		}
+	case walOpCreateTenant:
+		r.tenants.Store(rec.Tenant.Name, *rec.Tenant)
+	case walOpDeleteTenant:
+		r.tenants.Delete(rec.Tenant.Name)
+		r.removeTenantItems(rec.Tenant.Name)
+	}
+}
+
+// mutate runs fn against the in-memory state and logs the resulting state of
+// id in the tenant of ctx together with the revision fn made. Writers are
+// serialized so the log order matches the order in which mutations were
+// applied, but the fsync is awaited outside the lock so concurrent writers
+// share it.
+func (r *fileRepository) mutate(ctx context.Context, id string, fn func() error) error {
+	if err := r.unavailable(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+
+	key := scopedKey(ctx, id)
+	prev, existed := r.mem.data.Load(key)
+	if err := fn(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+
+	rec := walRecord{Op: walOpDelete, Item: model.DataItem{Tenant: key.tenant, ID: id}}
+	if current, ok := r.mem.load(key); ok {
+		rec = walRecord{Op: walOpPut, Item: current.item}
+		if current.history != nil {
+			rec.Revision = &current.history.Revision
+		}
+	}
+
+	batch, err := r.wal.append(ctx, rec)
+	if err != nil {
+		if existed {
+			r.mem.data.Store(key, prev)
+		} else {
+			r.mem.data.Delete(key)
+		}
+		r.mu.Unlock()
+		return errs.NewInternal(fmt.Errorf("failed to append to wal: %w", err))
+	}
+	r.mu.Unlock()
+
+	if err := batch.wait(context.WithoutCancel(ctx)); err != nil {
+		return errs.NewInternal(fmt.Errorf("failed to sync wal: %w", err))
+	}
+	return nil
+}
+
+func (r *fileRepository) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	var item model.DataItem
+	err := r.mutate(ctx, id, func() (err error) {
+		item, err = r.mem.CreateData(ctx, id, value, labels, expiresAt)
+		return err
+	})
+	return item, err
+}
+
+func (r *fileRepository) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	if err := r.unavailable(); err != nil {
+		return model.DataItem{}, err
+	}
+	return r.mem.GetData(ctx, id)
+}
+
+func (r *fileRepository) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	var item model.DataItem
+	err := r.mutate(ctx, id, func() (err error) {
+		item, err = r.mem.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
+		return err
+	})
+	return item, err
+}
+
+func (r *fileRepository) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	var item model.DataItem
+	err := r.mutate(ctx, id, func() (err error) {
+		item, err = r.mem.DeleteData(ctx, id, expectedVersion, actor)
+		return err
+	})
+	return item, err
+}
+
+// InTx logs the writes of a transaction as one batch record, so that a crash
+// never leaves part of them behind.
+func (r *fileRepository) InTx(ctx context.Context, fn func(tx Tx) error) error {
+	if err := r.unavailable(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	var batch *walBatch
+	err := r.mem.inTx(func(tx *memTx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if len(tx.log) == 0 {
+			return nil
+		}
+
+		rec := walRecord{Op: walOpBatch, Records: make([]walRecord, 0, len(tx.log))}
+		for _, e := range tx.log {
+			rec.Records = append(rec.Records, walRecord{Op: walOpPut, Item: e.item, Revision: &e.history.Revision})
+		}
+		var err error
+		batch, err = r.wal.append(ctx, rec)
+		if err != nil {
+			return errs.NewInternal(fmt.Errorf("failed to append to wal: %w", err))
+		}
+		return nil
+	})
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if batch != nil {
+		if err := batch.wait(context.WithoutCancel(ctx)); err != nil {
+			return errs.NewInternal(fmt.Errorf("failed to sync wal: %w", err))
+		}
+	}
+	return nil
+}
+
+func (r *fileRepository) ListAllData(ctx context.Context, opts ListOptions) (Page, error) {
+	if err := r.unavailable(); err != nil {
+		return Page{}, err
+	}
+	return r.mem.ListAllData(ctx, opts)
+}
+
+func (r *fileRepository) ListTrash(ctx context.Context, opts ListOptions) (Page, error) {
+	if err := r.unavailable(); err != nil {
+		return Page{}, err
+	}
+	return r.mem.ListTrash(ctx, opts)
+}
+
+func (r *fileRepository) RestoreData(ctx context.Context, id string) (model.DataItem, error) {
+	var item model.DataItem
+	err := r.mutate(ctx, id, func() (err error) {
+		item, err = r.mem.RestoreData(ctx, id)
+		return err
+	})
+	return item, err
+}
+
+func (r *fileRepository) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	return r.removeIf(ctx, trashedBefore(before))
+}
+
+func (r *fileRepository) GetHistory(ctx context.Context, id string) ([]model.Revision, error) {
+	if err := r.unavailable(); err != nil {
+		return nil, err
+	}
+	return r.mem.GetHistory(ctx, id)
+}
+
+func (r *fileRepository) sweepExpired(ctx context.Context, now time.Time) (int, error) {
+	return r.removeIf(ctx, expiredAt(now))
+}
+
+// removeIf logs a delete record for every item for which match returns true.
+// The log is synced once after the last of them.
+func (r *fileRepository) removeIf(ctx context.Context, match func(*model.DataItem) bool) (int, error) {
+	if err := r.unavailable(); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	var (
+		batch   *walBatch
+		removed int
+		err     error
+	)
+	r.mem.data.Range(func(key, value any) bool {
+		current := value.(*entry)
+		if !match(&current.item) || !r.mem.data.CompareAndDelete(key, current) {
+			return true
+		}
+		batch, err = r.wal.append(ctx, walRecord{Op: walOpDelete, Item: model.DataItem{Tenant: current.item.Tenant, ID: current.item.ID}})
+		if err != nil {
+			r.mem.data.Store(key, current)
+			return false
+		}
+		removed++
+		return true
+	})
+	r.mu.Unlock()
+
+	if err != nil {
+		return removed, errs.NewInternal(fmt.Errorf("failed to append to wal: %w", err))
+	}
+	if batch != nil {
+		if err := batch.wait(context.WithoutCancel(ctx)); err != nil {
+			return removed, errs.NewInternal(fmt.Errorf("failed to sync wal: %w", err))
+		}
+	}
+	return removed, nil
+}
+
+// CreateTenant logs the tenant before it is registered, which is safe as r.mu
+// keeps out every other write meanwhile.
+func (r *fileRepository) CreateTenant(ctx context.Context, name string, limit int) (model.Tenant, error) {
+	if err := r.unavailable(); err != nil {
+		return model.Tenant{}, err
+	}
+	r.mu.Lock()
+	if _, err := r.mem.GetTenant(ctx, name); err == nil {
+		r.mu.Unlock()
+		return model.Tenant{}, errs.NewConflict(fmt.Errorf("tenant %s already exists", name), errs.D("tenant", name))
+	}
+	if err := r.mem.checkTenantLimit(limit); err != nil {
+		r.mu.Unlock()
+		return model.Tenant{}, err
+	}
+	t := model.Tenant{Name: name, CreatedAt: time.Now().UTC()}
+	batch, err := r.wal.append(ctx, walRecord{Op: walOpCreateTenant, Tenant: &t})
+	if err != nil {
+		r.mu.Unlock()
+		return model.Tenant{}, errs.NewInternal(fmt.Errorf("failed to append to wal: %w", err))
+	}
+	r.mem.tenants.Store(name, t)
+	r.mu.Unlock()
+
+	if err := batch.wait(context.WithoutCancel(ctx)); err != nil {
+		return model.Tenant{}, errs.NewInternal(fmt.Errorf("failed to sync wal: %w", err))
+	}
+	return t, nil
+}
+
+func (r *fileRepository) GetTenant(ctx context.Context, name string) (model.Tenant, error) {
+	if err := r.unavailable(); err != nil {
+		return model.Tenant{}, err
+	}
+	return r.mem.GetTenant(ctx, name)
+}
+
+func (r *fileRepository) ListTenants(ctx context.Context) ([]model.Tenant, error) {
+	if err := r.unavailable(); err != nil {
+		return nil, err
+	}
+	return r.mem.ListTenants(ctx)
+}
+
+// DeleteTenant logs the tenant before it is removed, like CreateTenant.
+func (r *fileRepository) DeleteTenant(ctx context.Context, name string) (int, error) {
+	if err := r.unavailable(); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	t, err := r.mem.GetTenant(ctx, name)
+	if err != nil {
+		r.mu.Unlock()
+		return 0, err
+	}
+	batch, err := r.wal.append(ctx, walRecord{Op: walOpDeleteTenant, Tenant: &t})
+	if err != nil {
+		r.mu.Unlock()
+		return 0, errs.NewInternal(fmt.Errorf("failed to append to wal: %w", err))
+	}
+	removed, err := r.mem.DeleteTenant(ctx, name)
+	r.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := batch.wait(context.WithoutCancel(ctx)); err != nil {
+		return removed, errs.NewInternal(fmt.Errorf("failed to sync wal: %w", err))
+	}
+	return removed, nil
+}
+
+func (r *fileRepository) runCompaction(interval time.Duration) {
+	defer close(r.done)
+	if interval <= 0 {
+		<-r.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.compact(context.Background()); err != nil {
+				slog.Error("failed to compact wal", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// compact rewrites the log as one record per tenant, followed by one put
+// record per item, including the items in the trash, followed by the history
+// of the item. It is skipped when the log holds nothing else already.
+func (r *fileRepository) compact(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := make([]walRecord, 0)
+	r.mem.tenants.Range(func(key, value any) bool {
+		t := value.(model.Tenant)
+		records = append(records, walRecord{Op: walOpCreateTenant, Tenant: &t})
+		return true
+	})
+	r.mem.data.Range(func(key, value any) bool {
+		current := value.(*entry)
+		records = append(records, walRecord{Op: walOpPut, Item: current.item})
+		for _, revision := range current.revisions() {
+			records = append(records, walRecord{Op: walOpRevision, Item: model.DataItem{Tenant: current.item.Tenant, ID: current.item.ID}, Revision: &revision})
+		}
+		return true
+	})
+	if r.wal.size() <= len(records) {
+		return nil
+	}
+	return r.wal.compact(ctx, records)
+}
+
+// Ping reports a failure of the wal, after which no read or write succeeds.
+func (r *fileRepository) Ping(ctx context.Context) error {
+	return r.wal.failure()
+}
+
+// unavailable reports the repository as unavailable once the wal has failed.
+func (r *fileRepository) unavailable() error {
+	if err := r.wal.failure(); err != nil {
+		return errs.NewUnavailable(err)
+	}
+	return nil
+}
+
+func (r *fileRepository) Close() error {
+	r.sweeper.close()
+	close(r.stop)
+	<-r.done
+	return r.wal.close()
+}
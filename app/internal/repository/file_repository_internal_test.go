@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileRepositorySyncFailure(t *testing.T) {
+	ctx := context.Background()
+	repo, err := NewFileRepository(ctx, FileOptions{Path: filepath.Join(t.TempDir(), "wal.log"), SyncBatchSize: 1, SyncInterval: time.Millisecond})
+	require.NoError(t, err)
+	r := repo.(*fileRepository)
+	t.Cleanup(func() { r.Close() })
+
+	_, err = r.CreateData(ctx, "1", model.StringValue("durable"), nil, nil)
+	require.NoError(t, err)
+
+	// Every later flush of the log fails.
+	r.wal.mu.Lock()
+	r.wal.file.Close()
+	r.wal.mu.Unlock()
+
+	_, err = r.CreateData(ctx, "2", model.StringValue("lost"), nil, nil)
+	assert.Error(t, err)
+	assert.Error(t, r.Ping(ctx))
+
+	// The write that failed to sync is not served, and neither is anything
+	// else until the log is replayed.
+	_, err = r.GetData(ctx, "2")
+	assert.True(t, errs.IsUnavailable(err))
+	_, err = r.GetData(ctx, "1")
+	assert.True(t, errs.IsUnavailable(err))
+	_, err = r.ListAllData(ctx, ListOptions{})
+	assert.True(t, errs.IsUnavailable(err))
+	_, err = r.UpdateData(ctx, "1", model.StringValue("value"), nil, nil, AnyVersion)
+	assert.True(t, errs.IsUnavailable(err))
+}
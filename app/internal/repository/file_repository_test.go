@@ -0,0 +1,224 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/internal/tenant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFileRepository(t *testing.T, path string, expiry repository.ExpiryOptions) repository.Repository {
+	t.Helper()
+	repo, err := repository.NewFileRepository(context.Background(), repository.FileOptions{
+		Path:          path,
+		SyncBatchSize: 4,
+		SyncInterval:  time.Millisecond,
+		Expiry:        expiry,
+	})
+	require.NoError(t, err)
+	return repo
+}
+
+func TestFileRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T, expiry repository.ExpiryOptions) repository.Repository {
+		repo := newFileRepository(t, filepath.Join(t.TempDir(), "wal.log"), expiry)
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+
+	t.Run("CanceledContext", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		path := filepath.Join(t.TempDir(), "wal.log")
+
+		// A write is not turned into an error once it is applied, even if the
+		// caller is gone before it is synced.
+		repo := newFileRepository(t, path, repository.ExpiryOptions{})
+		_, err := repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		assert.NoError(t, err)
+		require.NoError(t, repo.Close())
+
+		repo = newFileRepository(t, path, repository.ExpiryOptions{})
+		defer repo.Close()
+		_, err = repo.GetData(context.Background(), "1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("ReplayOnReopen", func(t *testing.T) {
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "wal.log")
+
+		repo := newFileRepository(t, path, repository.ExpiryOptions{})
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		repo.CreateData(ctx, "2", model.StringValue("value2"), nil, nil)
+		repo.UpdateData(ctx, "1", model.Value(`{"new":"value1"}`), map[string]string{"env": "prod"}, nil, repository.AnyVersion)
+		repo.DeleteData(ctx, "2", repository.AnyVersion, "tester")
+		require.NoError(t, repo.Close())
+
+		repo = newFileRepository(t, path, repository.ExpiryOptions{})
+		defer repo.Close()
+
+		page, err := repo.ListAllData(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, model.Value(`{"new":"value1"}`), page.Items[0].Value)
+			assert.Equal(t, map[string]string{"env": "prod"}, page.Items[0].Labels)
+			assert.Equal(t, int64(2), page.Items[0].Version)
+		}
+
+		page, err = repo.ListTrash(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, "2", page.Items[0].ID)
+			assert.Equal(t, "tester", page.Items[0].DeletedBy)
+		}
+
+		revisions, err := repo.GetHistory(ctx, "1")
+		assert.NoError(t, err)
+		assert.Len(t, revisions, 2)
+	})
+
+	t.Run("ReplayTransaction", func(t *testing.T) {
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "wal.log")
+
+		repo := newFileRepository(t, path, repository.ExpiryOptions{})
+		err := repo.InTx(ctx, func(tx repository.Tx) error {
+			tx.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+			tx.UpdateData(ctx, "1", model.StringValue("newValue1"), nil, nil, repository.AnyVersion)
+			_, err := tx.CreateData(ctx, "2", model.StringValue("value2"), nil, nil)
+			return err
+		})
+		require.NoError(t, err)
+		require.NoError(t, repo.Close())
+
+		repo = newFileRepository(t, path, repository.ExpiryOptions{})
+		defer repo.Close()
+
+		item, err := repo.GetData(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "newValue1", item.Value.Text())
+		revisions, err := repo.GetHistory(ctx, "1")
+		assert.NoError(t, err)
+		assert.Len(t, revisions, 2)
+		_, err = repo.GetData(ctx, "2")
+		assert.NoError(t, err)
+	})
+
+	t.Run("ReplayTenants", func(t *testing.T) {
+		ctx := context.Background()
+		acme := tenant.WithTenant(ctx, "acme")
+		path := filepath.Join(t.TempDir(), "wal.log")
+
+		repo := newFileRepository(t, path, repository.ExpiryOptions{})
+		repo.CreateTenant(ctx, "acme", 10)
+		repo.CreateTenant(ctx, "globex", 10)
+		repo.CreateData(ctx, "1", model.StringValue("default value"), nil, nil)
+		repo.CreateData(acme, "1", model.StringValue("acme value"), nil, nil)
+		repo.CreateData(tenant.WithTenant(ctx, "globex"), "1", model.StringValue("globex value"), nil, nil)
+		_, err := repo.DeleteTenant(ctx, "globex")
+		require.NoError(t, err)
+		require.NoError(t, repo.Close())
+
+		repo = newFileRepository(t, path, repository.ExpiryOptions{})
+		defer repo.Close()
+
+		tenants, err := repo.ListTenants(ctx)
+		assert.NoError(t, err)
+		if assert.Len(t, tenants, 1) {
+			assert.Equal(t, "acme", tenants[0].Name)
+		}
+		item, err := repo.GetData(acme, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "acme value", item.Value.Text())
+		item, err = repo.GetData(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "default value", item.Value.Text())
+		_, err = repo.GetData(tenant.WithTenant(ctx, "globex"), "1")
+		assert.Error(t, err)
+	})
+
+	t.Run("TruncatesTornTail", func(t *testing.T) {
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "wal.log")
+
+		repo := newFileRepository(t, path, repository.ExpiryOptions{})
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		require.NoError(t, repo.Close())
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		_, err = f.Write([]byte{0xff, 0x00, 0x00})
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		repo = newFileRepository(t, path, repository.ExpiryOptions{})
+		item, err := repo.GetData(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "value1", item.Value.Text())
+
+		_, err = repo.CreateData(ctx, "2", model.StringValue("value2"), nil, nil)
+		assert.NoError(t, err)
+		require.NoError(t, repo.Close())
+
+		repo = newFileRepository(t, path, repository.ExpiryOptions{})
+		defer repo.Close()
+		page, err := repo.ListAllData(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		assert.Len(t, page.Items, 2)
+	})
+
+	t.Run("Compaction", func(t *testing.T) {
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "wal.log")
+
+		repo := newFileRepository(t, path, repository.ExpiryOptions{})
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		for i := 0; i < 5; i++ {
+			repo.UpdateData(ctx, "1", model.StringValue("value1"), nil, nil, repository.AnyVersion)
+		}
+		for i := 0; i < 50; i++ {
+			id := fmt.Sprintf("tmp-%d", i)
+			repo.CreateData(ctx, id, model.StringValue("value"), nil, nil)
+			repo.DeleteData(ctx, id, repository.AnyVersion, "tester")
+		}
+		_, err := repo.PurgeDeleted(ctx, time.Now().Add(time.Second))
+		require.NoError(t, err)
+		require.NoError(t, repo.Close())
+		before, err := os.Stat(path)
+		require.NoError(t, err)
+
+		repo, err = repository.NewFileRepository(ctx, repository.FileOptions{
+			Path:            path,
+			SyncBatchSize:   1,
+			SyncInterval:    time.Millisecond,
+			CompactInterval: 10 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			after, err := os.Stat(path)
+			return err == nil && after.Size() < before.Size()/5
+		}, time.Second, 10*time.Millisecond)
+		require.NoError(t, repo.Close())
+
+		repo = newFileRepository(t, path, repository.ExpiryOptions{})
+		defer repo.Close()
+		item, err := repo.GetData(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "value1", item.Value.Text())
+		assert.Equal(t, int64(6), item.Version)
+
+		revisions, err := repo.GetHistory(ctx, "1")
+		assert.NoError(t, err)
+		assert.Len(t, revisions, 6)
+	})
+}
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/instrument"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentedRepository traces every operation of another Repository as
+// "<operation>InRepo" and records its latency and errors, so that backends
+// only implement storage.
+type instrumentedRepository struct {
+	repo Repository
+	in   *instrument.Instrumentation
+	// tx instruments the operations of transactions as "<operation>InTx".
+	tx *instrument.Instrumentation
+}
+
+// NewInstrumentedRepository wraps repo of the named backend. latencyHistogram
+// is labeled by backend, operation and result, errorCounter by backend,
+// operation and error code. It keeps the snapshots of repo, if it supports
+// them.
+func NewInstrumentedRepository(repo Repository, backend string, latencyHistogram *prometheus.HistogramVec, errorCounter *prometheus.CounterVec) Repository {
+	labels := prometheus.Labels{"backend": backend}
+	latency := latencyHistogram.MustCurryWith(labels)
+	errors := errorCounter.MustCurryWith(labels)
+	attr := attribute.String("repository.backend", backend)
+
+	r := &instrumentedRepository{
+		repo: repo,
+		in:   instrument.New("InRepo", latency, errors, attr).WithContext(tenantAttributes),
+		tx:   instrument.New("InTx", latency, errors, attr).WithContext(tenantAttributes),
+	}
+	if snapshotter, ok := repo.(Snapshotter); ok {
+		return &instrumentedSnapshotRepository{r, snapshotter}
+	}
+	return r
+}
+
+func (r *instrumentedRepository) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	return traceCreateData(ctx, r.in, r.repo, id, value, labels, expiresAt)
+}
+
+func (r *instrumentedRepository) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	return traceGetData(ctx, r.in, r.repo, id)
+}
+
+func (r *instrumentedRepository) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	return traceUpdateData(ctx, r.in, r.repo, id, newValue, labels, expiresAt, expectedVersion)
+}
+
+func (r *instrumentedRepository) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	return traceDeleteData(ctx, r.in, r.repo, id, expectedVersion, actor)
+}
+
+func (r *instrumentedRepository) ListAllData(ctx context.Context, opts ListOptions) (Page, error) {
+	return instrument.Call(ctx, r.in, "ListAllData", listAttributes(opts), func(ctx context.Context) (Page, error) {
+		page, err := r.repo.ListAllData(ctx, opts)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("list.count", len(page.Items)))
+		return page, err
+	})
+}
+
+func (r *instrumentedRepository) ListTrash(ctx context.Context, opts ListOptions) (Page, error) {
+	return instrument.Call(ctx, r.in, "ListTrash", listAttributes(opts), func(ctx context.Context) (Page, error) {
+		page, err := r.repo.ListTrash(ctx, opts)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("list.count", len(page.Items)))
+		return page, err
+	})
+}
+
+func (r *instrumentedRepository) RestoreData(ctx context.Context, id string) (model.DataItem, error) {
+	return instrument.Call(ctx, r.in, "RestoreData", []attribute.KeyValue{attribute.String("data.id", id)}, func(ctx context.Context) (model.DataItem, error) {
+		item, err := r.repo.RestoreData(ctx, id)
+		if err == nil {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("data.version", item.Version))
+		}
+		return item, err
+	})
+}
+
+func (r *instrumentedRepository) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	return instrument.Call(ctx, r.in, "PurgeDeleted", []attribute.KeyValue{attribute.String("data.before", before.Format(time.RFC3339))}, func(ctx context.Context) (int, error) {
+		purged, err := r.repo.PurgeDeleted(ctx, before)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("data.purged", purged))
+		return purged, err
+	})
+}
+
+func (r *instrumentedRepository) GetHistory(ctx context.Context, id string) ([]model.Revision, error) {
+	return instrument.Call(ctx, r.in, "GetHistory", []attribute.KeyValue{attribute.String("data.id", id)}, func(ctx context.Context) ([]model.Revision, error) {
+		revisions, err := r.repo.GetHistory(ctx, id)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("history.count", len(revisions)))
+		return revisions, err
+	})
+}
+
+func (r *instrumentedRepository) InTx(ctx context.Context, fn func(tx Tx) error) error {
+	return instrument.Do(ctx, r.in, "InTx", nil, func(ctx context.Context) error {
+		return r.repo.InTx(ctx, func(tx Tx) error {
+			return fn(&instrumentedTx{tx: tx, in: r.tx})
+		})
+	})
+}
+
+func (r *instrumentedRepository) CreateTenant(ctx context.Context, name string, limit int) (model.Tenant, error) {
+	return instrument.Call(ctx, r.in, "CreateTenant", []attribute.KeyValue{attribute.String("tenant.name", name)}, func(ctx context.Context) (model.Tenant, error) {
+		return r.repo.CreateTenant(ctx, name, limit)
+	})
+}
+
+func (r *instrumentedRepository) GetTenant(ctx context.Context, name string) (model.Tenant, error) {
+	return instrument.Call(ctx, r.in, "GetTenant", []attribute.KeyValue{attribute.String("tenant.name", name)}, func(ctx context.Context) (model.Tenant, error) {
+		return r.repo.GetTenant(ctx, name)
+	})
+}
+
+func (r *instrumentedRepository) ListTenants(ctx context.Context) ([]model.Tenant, error) {
+	return instrument.Call(ctx, r.in, "ListTenants", nil, func(ctx context.Context) ([]model.Tenant, error) {
+		tenants, err := r.repo.ListTenants(ctx)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("tenant.count", len(tenants)))
+		return tenants, err
+	})
+}
+
+func (r *instrumentedRepository) DeleteTenant(ctx context.Context, name string) (int, error) {
+	return instrument.Call(ctx, r.in, "DeleteTenant", []attribute.KeyValue{attribute.String("tenant.name", name)}, func(ctx context.Context) (int, error) {
+		removed, err := r.repo.DeleteTenant(ctx, name)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("data.purged", removed))
+		return removed, err
+	})
+}
+
+// Ping is not instrumented, since health checks call it continuously.
+func (r *instrumentedRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
+
+func (r *instrumentedRepository) Close() error {
+	return r.repo.Close()
+}
+
+// instrumentedTx traces the operations of a transaction like those of the
+// repository it belongs to.
+type instrumentedTx struct {
+	tx Tx
+	in *instrument.Instrumentation
+}
+
+func (tx *instrumentedTx) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	return traceCreateData(ctx, tx.in, tx.tx, id, value, labels, expiresAt)
+}
+
+func (tx *instrumentedTx) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	return traceGetData(ctx, tx.in, tx.tx, id)
+}
+
+func (tx *instrumentedTx) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	return traceUpdateData(ctx, tx.in, tx.tx, id, newValue, labels, expiresAt, expectedVersion)
+}
+
+func (tx *instrumentedTx) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	return traceDeleteData(ctx, tx.in, tx.tx, id, expectedVersion, actor)
+}
+
+// The operations below are shared by repositories and transactions.
+
+func traceCreateData(ctx context.Context, in *instrument.Instrumentation, tx Tx, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	attrs := []attribute.KeyValue{attribute.String("data.id", id), attribute.String("data.value", string(value))}
+	return instrument.Call(ctx, in, "CreateData", attrs, func(ctx context.Context) (model.DataItem, error) {
+		return tx.CreateData(ctx, id, value, labels, expiresAt)
+	})
+}
+
+func traceGetData(ctx context.Context, in *instrument.Instrumentation, tx Tx, id string) (model.DataItem, error) {
+	return instrument.Call(ctx, in, "GetData", []attribute.KeyValue{attribute.String("data.id", id)}, func(ctx context.Context) (model.DataItem, error) {
+		return tx.GetData(ctx, id)
+	})
+}
+
+func traceUpdateData(ctx context.Context, in *instrument.Instrumentation, tx Tx, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("data.id", id),
+		attribute.String("data.newValue", string(newValue)),
+		attribute.Int64("data.expectedVersion", expectedVersion),
+	}
+	return instrument.Call(ctx, in, "UpdateData", attrs, func(ctx context.Context) (model.DataItem, error) {
+		item, err := tx.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
+		if err == nil {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("data.version", item.Version))
+		}
+		return item, err
+	})
+}
+
+func traceDeleteData(ctx context.Context, in *instrument.Instrumentation, tx Tx, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("data.id", id),
+		attribute.Int64("data.expectedVersion", expectedVersion),
+		attribute.String("data.actor", actor),
+	}
+	return instrument.Call(ctx, in, "DeleteData", attrs, func(ctx context.Context) (model.DataItem, error) {
+		item, err := tx.DeleteData(ctx, id, expectedVersion, actor)
+		if err == nil {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("data.version", item.Version))
+		}
+		return item, err
+	})
+}
+
+func tenantAttributes(ctx context.Context) []attribute.KeyValue {
+	return []attribute.KeyValue{attribute.String("tenant", tenant.FromContext(ctx))}
+}
+
+func listAttributes(opts ListOptions) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.Int("list.limit", opts.Limit),
+		attribute.String("list.order", string(opts.OrderBy)),
+		attribute.Bool("list.descending", opts.Descending),
+	}
+	if opts.Filter != nil {
+		attrs = append(attrs, attribute.String("list.filter", opts.Filter.String()))
+	}
+	return attrs
+}
+
+// instrumentedSnapshotRepository is an instrumentedRepository of a repository
+// that is also a Snapshotter.
+type instrumentedSnapshotRepository struct {
+	*instrumentedRepository
+	snapshotter Snapshotter
+}
+
+func (r *instrumentedSnapshotRepository) WriteSnapshot(ctx context.Context, w io.Writer) (int, error) {
+	return instrument.Call(ctx, r.in, "WriteSnapshot", nil, func(ctx context.Context) (int, error) {
+		n, err := r.snapshotter.WriteSnapshot(ctx, w)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("snapshot.items", n))
+		return n, err
+	})
+}
+
+func (r *instrumentedSnapshotRepository) ReadSnapshot(ctx context.Context, rd io.Reader) (int, error) {
+	return instrument.Call(ctx, r.in, "ReadSnapshot", nil, func(ctx context.Context) (int, error) {
+		n, err := r.snapshotter.ReadSnapshot(ctx, rd)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("snapshot.items", n))
+		return n, err
+	})
+}
@@ -0,0 +1,20 @@
+package repository_test
+
+import (
+	"testing"
+
+	"simple_lgtm/internal/repository"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestInstrumentedRepository(t *testing.T) {
+	latencyHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"backend", "operation", "result"})
+	errorCounter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "errors"}, []string{"backend", "operation", "code"})
+
+	testRepository(t, func(t *testing.T, expiry repository.ExpiryOptions) repository.Repository {
+		repo := repository.NewInstrumentedRepository(repository.NewInMemoryRepository(expiry), "memory", latencyHistogram, errorCounter)
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+}
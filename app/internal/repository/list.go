@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"simple_lgtm/internal/filter"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/pkg/errs"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+type Order string
+
+const (
+	OrderByID        Order = "id"
+	OrderByCreatedAt Order = "created_at"
+	OrderByUpdatedAt Order = "updated_at"
+)
+
+// ListOptions selects one page of ListAllData. Items are always sorted by
+// OrderBy and then by ID, so the order is stable even for equal timestamps.
+type ListOptions struct {
+	Limit      int
+	Cursor     string
+	OrderBy    Order
+	Descending bool
+	// Filter restricts the listing to matching items when it is not nil.
+	Filter filter.Expr
+}
+
+type Page struct {
+	Items []model.DataItem
+	// NextCursor is empty on the last page.
+	NextCursor string
+}
+
+// cursor is the position after the last item of a page. It is handed to
+// clients base64 encoded and must not be interpreted by them.
+type cursor struct {
+	OrderBy    Order  `json:"o"`
+	Descending bool   `json:"d,omitempty"`
+	Key        int64  `json:"k,omitempty"`
+	ID         string `json:"i"`
+}
+
+// normalize applies the defaults of opts and decodes its cursor, which is
+// nil for the first page.
+func (opts *ListOptions) normalize() (*cursor, error) {
+	if opts.OrderBy == "" {
+		opts.OrderBy = OrderByID
+	}
+	switch opts.OrderBy {
+	case OrderByID, OrderByCreatedAt, OrderByUpdatedAt:
+	default:
+		return nil, errs.NewInvalidInput(fmt.Errorf("unknown order %q", opts.OrderBy))
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultListLimit
+	}
+	if opts.Limit > MaxListLimit {
+		opts.Limit = MaxListLimit
+	}
+	if opts.Cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return nil, errs.NewInvalidInput(fmt.Errorf("invalid cursor: %w", err))
+	}
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, errs.NewInvalidInput(fmt.Errorf("invalid cursor: %w", err))
+	}
+	if c.OrderBy != opts.OrderBy || c.Descending != opts.Descending {
+		return nil, errs.NewInvalidInput(fmt.Errorf("cursor was issued for a different order"))
+	}
+	return &c, nil
+}
+
+func sortKey(item model.DataItem, orderBy Order) int64 {
+	switch orderBy {
+	case OrderByCreatedAt:
+		return item.CreatedAt.UnixNano()
+	case OrderByUpdatedAt:
+		return item.UpdatedAt.UnixNano()
+	default:
+		return 0
+	}
+}
+
+func newCursor(item model.DataItem, opts ListOptions) string {
+	raw, _ := json.Marshal(cursor{
+		OrderBy:    opts.OrderBy,
+		Descending: opts.Descending,
+		Key:        sortKey(item, opts.OrderBy),
+		ID:         item.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// less reports whether a sorts before b in the order of opts.
+func less(a model.DataItem, b model.DataItem, opts ListOptions) bool {
+	ka, kb := sortKey(a, opts.OrderBy), sortKey(b, opts.OrderBy)
+	if ka != kb {
+		return (ka < kb) != opts.Descending
+	}
+	return (a.ID < b.ID) != opts.Descending
+}
+
+// paginate sorts items in place and cuts the page that follows c out of them.
+func paginate(items []model.DataItem, opts ListOptions, c *cursor) Page {
+	sort.Slice(items, func(i, j int) bool {
+		return less(items[i], items[j], opts)
+	})
+
+	start := 0
+	if c != nil {
+		start = sort.Search(len(items), func(i int) bool {
+			key := sortKey(items[i], opts.OrderBy)
+			if key != c.Key {
+				return (key > c.Key) != opts.Descending
+			}
+			return items[i].ID != c.ID && (items[i].ID > c.ID) != opts.Descending
+		})
+	}
+	items = items[start:]
+	return newPage(items, opts)
+}
+
+// newPage trims items, which may hold one more than opts.Limit to signal that
+// there is a next page, and sets the cursor accordingly.
+func newPage(items []model.DataItem, opts ListOptions) Page {
+	if len(items) <= opts.Limit {
+		return Page{Items: items}
+	}
+	items = items[:opts.Limit]
+	return Page{Items: items, NextCursor: newCursor(items[len(items)-1], opts)}
+}
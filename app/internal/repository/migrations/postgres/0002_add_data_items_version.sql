@@ -0,0 +1 @@
+ALTER TABLE data_items ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,16 @@
+CREATE TABLE data_item_revisions (
+    id          TEXT NOT NULL,
+    version     BIGINT NOT NULL,
+    operation   TEXT NOT NULL,
+    value       TEXT NOT NULL,
+    expires_at  BIGINT,
+    actor       TEXT NOT NULL DEFAULT '',
+    recorded_at BIGINT NOT NULL,
+    trace_id    TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (id, version)
+);
+INSERT INTO data_item_revisions (id, version, operation, value, expires_at, actor, recorded_at)
+SELECT id, version,
+       CASE WHEN deleted_at IS NOT NULL THEN 'delete' WHEN version = 1 THEN 'create' ELSE 'update' END,
+       value, expires_at, deleted_by, updated_at
+FROM data_items;
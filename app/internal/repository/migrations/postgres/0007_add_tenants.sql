@@ -0,0 +1,14 @@
+CREATE TABLE tenants (
+    name       TEXT PRIMARY KEY,
+    created_at BIGINT NOT NULL
+);
+ALTER TABLE data_items ADD COLUMN tenant TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE data_items DROP CONSTRAINT data_items_pkey;
+ALTER TABLE data_items ADD PRIMARY KEY (tenant, id);
+DROP INDEX data_items_created_at_idx;
+DROP INDEX data_items_updated_at_idx;
+CREATE INDEX data_items_created_at_idx ON data_items (tenant, created_at, id);
+CREATE INDEX data_items_updated_at_idx ON data_items (tenant, updated_at, id);
+ALTER TABLE data_item_revisions ADD COLUMN tenant TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE data_item_revisions DROP CONSTRAINT data_item_revisions_pkey;
+ALTER TABLE data_item_revisions ADD PRIMARY KEY (tenant, id, version);
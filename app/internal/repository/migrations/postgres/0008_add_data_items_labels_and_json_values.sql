@@ -0,0 +1,4 @@
+ALTER TABLE data_items ADD COLUMN labels TEXT;
+ALTER TABLE data_item_revisions ADD COLUMN labels TEXT;
+UPDATE data_items SET value = to_json(value)::text;
+UPDATE data_item_revisions SET value = to_json(value)::text;
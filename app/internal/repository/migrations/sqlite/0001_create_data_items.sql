@@ -0,0 +1,4 @@
+CREATE TABLE data_items (
+    id    TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
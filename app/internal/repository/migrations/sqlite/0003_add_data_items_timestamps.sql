@@ -0,0 +1,4 @@
+ALTER TABLE data_items ADD COLUMN created_at BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE data_items ADD COLUMN updated_at BIGINT NOT NULL DEFAULT 0;
+CREATE INDEX data_items_created_at_idx ON data_items (created_at, id);
+CREATE INDEX data_items_updated_at_idx ON data_items (updated_at, id);
@@ -0,0 +1,3 @@
+ALTER TABLE data_items ADD COLUMN deleted_at BIGINT;
+ALTER TABLE data_items ADD COLUMN deleted_by TEXT NOT NULL DEFAULT '';
+CREATE INDEX data_items_deleted_at_idx ON data_items (deleted_at);
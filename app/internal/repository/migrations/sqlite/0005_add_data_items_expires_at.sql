@@ -0,0 +1,2 @@
+ALTER TABLE data_items ADD COLUMN expires_at BIGINT;
+CREATE INDEX data_items_expires_at_idx ON data_items (expires_at);
@@ -0,0 +1,40 @@
+CREATE TABLE tenants (
+    name       TEXT PRIMARY KEY,
+    created_at BIGINT NOT NULL
+);
+CREATE TABLE data_items_by_tenant (
+    tenant     TEXT NOT NULL DEFAULT 'default',
+    id         TEXT NOT NULL,
+    value      TEXT NOT NULL,
+    version    BIGINT NOT NULL DEFAULT 1,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    updated_at BIGINT NOT NULL DEFAULT 0,
+    deleted_at BIGINT,
+    deleted_by TEXT NOT NULL DEFAULT '',
+    expires_at BIGINT,
+    PRIMARY KEY (tenant, id)
+);
+INSERT INTO data_items_by_tenant (id, value, version, created_at, updated_at, deleted_at, deleted_by, expires_at)
+SELECT id, value, version, created_at, updated_at, deleted_at, deleted_by, expires_at FROM data_items;
+DROP TABLE data_items;
+ALTER TABLE data_items_by_tenant RENAME TO data_items;
+CREATE INDEX data_items_created_at_idx ON data_items (tenant, created_at, id);
+CREATE INDEX data_items_updated_at_idx ON data_items (tenant, updated_at, id);
+CREATE INDEX data_items_deleted_at_idx ON data_items (deleted_at);
+CREATE INDEX data_items_expires_at_idx ON data_items (expires_at);
+CREATE TABLE data_item_revisions_by_tenant (
+    tenant      TEXT NOT NULL DEFAULT 'default',
+    id          TEXT NOT NULL,
+    version     BIGINT NOT NULL,
+    operation   TEXT NOT NULL,
+    value       TEXT NOT NULL,
+    expires_at  BIGINT,
+    actor       TEXT NOT NULL DEFAULT '',
+    recorded_at BIGINT NOT NULL,
+    trace_id    TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (tenant, id, version)
+);
+INSERT INTO data_item_revisions_by_tenant (id, version, operation, value, expires_at, actor, recorded_at, trace_id)
+SELECT id, version, operation, value, expires_at, actor, recorded_at, trace_id FROM data_item_revisions;
+DROP TABLE data_item_revisions;
+ALTER TABLE data_item_revisions_by_tenant RENAME TO data_item_revisions;
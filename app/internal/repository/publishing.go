@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/tenant"
+)
+
+// publishingRepository publishes the revisions written through it to a Feed
+// once they are stored. Every event carries the trace ID of the write.
+//
+// Writes of an item are counted from before they are stored until they
+// return, and the events of an item are held back until none is running, at
+// which point they are published in the order of their versions. So the feed
+// numbers the revisions of an item in the order they were written without
+// keeping writes of other items waiting. Purges, the expiry sweeper and
+// DeleteTenant publish nothing.
+type publishingRepository struct {
+	Repository
+	feed *Feed
+
+	mu sync.Mutex
+	// pending maps the itemKey of every item that is being written to its
+	// running writes and held back events.
+	pending map[itemKey]*pendingEvents
+}
+
+// pendingEvents are the events of an item that wait for its running writes.
+type pendingEvents struct {
+	writes int
+	events []model.Event
+}
+
+// NewPublishingRepository returns repo publishing to feed. It keeps the
+// snapshots of repo, if it supports them.
+func NewPublishingRepository(repo Repository, feed *Feed) Repository {
+	p := &publishingRepository{Repository: repo, feed: feed, pending: make(map[itemKey]*pendingEvents)}
+	if snapshotter, ok := repo.(Snapshotter); ok {
+		return &publishingSnapshotRepository{p, snapshotter}
+	}
+	return p
+}
+
+// newEvent returns the event of item after op in the tenant of ctx.
+func newEvent(ctx context.Context, item model.DataItem, op model.Operation) model.Event {
+	return model.Event{Tenant: tenant.FromContext(ctx), Revision: model.NewRevision(item, op, traceID(ctx))}
+}
+
+// begin counts a write of key that is about to be stored.
+func (r *publishingRepository) begin(key itemKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.pending[key]
+	if !ok {
+		p = &pendingEvents{}
+		r.pending[key] = p
+	}
+	p.writes++
+}
+
+// end finishes a write of key that produced events, if it succeeded, and
+// publishes the events of key once no write of it is running. r.mu is held
+// while publishing, so that later events of key cannot overtake them.
+func (r *publishingRepository) end(key itemKey, events ...model.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := r.pending[key]
+	p.writes--
+	p.events = append(p.events, events...)
+	if p.writes > 0 {
+		return
+	}
+	delete(r.pending, key)
+	slices.SortStableFunc(p.events, func(a, b model.Event) int { return cmp.Compare(a.Version, b.Version) })
+	r.feed.publish(p.events...)
+}
+
+func (r *publishingRepository) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	key := scopedKey(ctx, id)
+	r.begin(key)
+	item, err := r.Repository.CreateData(ctx, id, value, labels, expiresAt)
+	if err != nil {
+		r.end(key)
+		return item, err
+	}
+	r.end(key, newEvent(ctx, item, model.OperationCreate))
+	return item, nil
+}
+
+func (r *publishingRepository) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	key := scopedKey(ctx, id)
+	r.begin(key)
+	item, err := r.Repository.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
+	if err != nil {
+		r.end(key)
+		return item, err
+	}
+	r.end(key, newEvent(ctx, item, model.OperationUpdate))
+	return item, nil
+}
+
+func (r *publishingRepository) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	key := scopedKey(ctx, id)
+	r.begin(key)
+	item, err := r.Repository.DeleteData(ctx, id, expectedVersion, actor)
+	if err != nil {
+		r.end(key)
+		return item, err
+	}
+	r.end(key, newEvent(ctx, item, model.OperationDelete))
+	return item, nil
+}
+
+func (r *publishingRepository) RestoreData(ctx context.Context, id string) (model.DataItem, error) {
+	key := scopedKey(ctx, id)
+	r.begin(key)
+	item, err := r.Repository.RestoreData(ctx, id)
+	if err != nil {
+		r.end(key)
+		return item, err
+	}
+	r.end(key, newEvent(ctx, item, model.OperationRestore))
+	return item, nil
+}
+
+// InTx publishes the writes of the transaction once it commits. Every item
+// is counted as being written from the first write of the transaction to it.
+func (r *publishingRepository) InTx(ctx context.Context, fn func(tx Tx) error) error {
+	ptx := &publishingTx{r: r, events: make(map[itemKey][]model.Event)}
+	err := r.Repository.InTx(ctx, func(tx Tx) error {
+		ptx.Tx = tx
+		clear(ptx.events)
+		return fn(ptx)
+	})
+	for _, key := range ptx.keys {
+		if err != nil {
+			r.end(key)
+			continue
+		}
+		r.end(key, ptx.events[key]...)
+	}
+	return err
+}
+
+// publishingTx collects the events of the writes of a transaction by item.
+type publishingTx struct {
+	Tx
+	r *publishingRepository
+	// keys are the items written in the order of their first write, which
+	// may have been made by an earlier attempt of the transaction.
+	keys   []itemKey
+	events map[itemKey][]model.Event
+}
+
+// begin counts the transaction as a write of id in the tenant of ctx unless
+// it already is, and returns its key.
+func (tx *publishingTx) begin(ctx context.Context, id string) itemKey {
+	key := scopedKey(ctx, id)
+	if !slices.Contains(tx.keys, key) {
+		tx.r.begin(key)
+		tx.keys = append(tx.keys, key)
+	}
+	return key
+}
+
+func (tx *publishingTx) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	key := tx.begin(ctx, id)
+	item, err := tx.Tx.CreateData(ctx, id, value, labels, expiresAt)
+	if err == nil {
+		tx.events[key] = append(tx.events[key], newEvent(ctx, item, model.OperationCreate))
+	}
+	return item, err
+}
+
+func (tx *publishingTx) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	key := tx.begin(ctx, id)
+	item, err := tx.Tx.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
+	if err == nil {
+		tx.events[key] = append(tx.events[key], newEvent(ctx, item, model.OperationUpdate))
+	}
+	return item, err
+}
+
+func (tx *publishingTx) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	key := tx.begin(ctx, id)
+	item, err := tx.Tx.DeleteData(ctx, id, expectedVersion, actor)
+	if err == nil {
+		tx.events[key] = append(tx.events[key], newEvent(ctx, item, model.OperationDelete))
+	}
+	return item, err
+}
+
+// publishingSnapshotRepository is a publishingRepository of a repository that
+// is also a Snapshotter. Restoring a snapshot publishes nothing.
+type publishingSnapshotRepository struct {
+	*publishingRepository
+	snapshotter Snapshotter
+}
+
+func (r *publishingSnapshotRepository) WriteSnapshot(ctx context.Context, w io.Writer) (int, error) {
+	return r.snapshotter.WriteSnapshot(ctx, w)
+}
+
+func (r *publishingSnapshotRepository) ReadSnapshot(ctx context.Context, rd io.Reader) (int, error) {
+	return r.snapshotter.ReadSnapshot(ctx, rd)
+}
@@ -4,111 +4,453 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"sync"
+	"time"
 
 	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/tenant"
 	"simple_lgtm/pkg/errs"
 
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// AnyVersion disables the version check of UpdateData and DeleteData.
+const AnyVersion int64 = 0
+
+// Repository stores the items of every tenant. Items are read and written in
+// the tenant carried by ctx, see package tenant, while PurgeDeleted and the
+// expiry sweeper span all tenants.
 type Repository interface {
-	CreateData(ctx context.Context, id string, value string) error
-	GetData(ctx context.Context, id string) (string, error)
-	UpdateData(ctx context.Context, id string, newValue string) error
-	DeleteData(ctx context.Context, id string) error
-	ListAllData(ctx context.Context) ([]model.DataItem, error)
+	// CreateData adds id, replacing it if it is in the trash or expired. A
+	// nil expiresAt never expires.
+	CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error)
+	GetData(ctx context.Context, id string) (model.DataItem, error)
+	// UpdateData replaces the value, labels and expiry of id if its current version
+	// equals expectedVersion, or unconditionally for AnyVersion, and returns
+	// the item with its new version.
+	UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error)
+	// DeleteData moves id to the trash on behalf of actor if its current
+	// version equals expectedVersion, or unconditionally for AnyVersion, and
+	// returns the item as it is in the trash.
+	DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error)
+	// ListAllData returns one page of items in the order of opts.
+	ListAllData(ctx context.Context, opts ListOptions) (Page, error)
+	// ListTrash is like ListAllData for the items in the trash.
+	ListTrash(ctx context.Context, opts ListOptions) (Page, error)
+	// RestoreData moves id out of the trash.
+	RestoreData(ctx context.Context, id string) (model.DataItem, error)
+	// PurgeDeleted permanently removes the items that were moved to the
+	// trash before the given time and returns how many there were.
+	PurgeDeleted(ctx context.Context, before time.Time) (int, error)
+	// GetHistory returns the revisions of id, oldest first. The history
+	// outlives deletes and is only removed with the item by a purge or the
+	// expiry sweeper.
+	GetHistory(ctx context.Context, id string) ([]model.Revision, error)
+	// InTx runs fn in a transaction. Its writes are applied together if fn
+	// returns nil and discarded otherwise. fn must only use tx, as the
+	// repository itself may be locked until fn returns.
+	InTx(ctx context.Context, fn func(tx Tx) error) error
+	// CreateTenant registers a tenant unless limit tenants, counting the
+	// default one, exist already, which is reported as forbidden. The
+	// default tenant exists without being registered.
+	CreateTenant(ctx context.Context, name string, limit int) (model.Tenant, error)
+	GetTenant(ctx context.Context, name string) (model.Tenant, error)
+	// ListTenants returns the registered tenants ordered by name.
+	ListTenants(ctx context.Context) ([]model.Tenant, error)
+	// DeleteTenant unregisters a tenant and permanently removes its items
+	// together with their history. It returns how many items there were.
+	DeleteTenant(ctx context.Context, name string) (int, error)
+	// Ping reports an error if the repository cannot serve requests, such as
+	// when its database is unreachable.
+	Ping(ctx context.Context) error
+	// Close stops the background work of the repository and releases it.
+	Close() error
+}
+
+// Tx is the part of Repository that can be used inside a transaction. Reads
+// see the writes made earlier in the same transaction.
+type Tx interface {
+	CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error)
+	GetData(ctx context.Context, id string) (model.DataItem, error)
+	UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error)
+	DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error)
+}
+
+// PatchFunc computes the new value of an item from its current one.
+type PatchFunc func(current model.Value) (model.Value, error)
+
+// PatchData replaces the value of id with the result of patch in one
+// transaction of repo, keeping its labels and expiry, so that no other write
+// can come between reading the value and writing the patched one. Like
+// UpdateData, it only applies if the version of id equals expectedVersion,
+// unless that is AnyVersion.
+func PatchData(ctx context.Context, repo Repository, id string, patch PatchFunc, expectedVersion int64) (model.DataItem, error) {
+	var item model.DataItem
+	err := repo.InTx(ctx, func(tx Tx) error {
+		current, err := tx.GetData(ctx, id)
+		if err != nil {
+			return err
+		}
+		if expectedVersion != AnyVersion && current.Version != expectedVersion {
+			return errs.NewConflict(fmt.Errorf("data with ID %s is at version %d, expected %d", id, current.Version, expectedVersion), errs.D("id", id), errs.D("version", current.Version), errs.D("expected_version", expectedVersion))
+		}
+		value, err := patch(current.Value)
+		if err != nil {
+			return err
+		}
+		item, err = tx.UpdateData(ctx, id, value, current.Labels, current.ExpiresAt, current.Version)
+		return err
+	})
+	if err != nil {
+		return model.DataItem{}, err
+	}
+	return item, nil
 }
 
+// inMemoryRepository stores immutable *entry values so that writers can
+// compare-and-swap on the pointer they read. Items in the trash stay in the
+// map with DeletedAt set, and expired items until they are swept.
+//
+// Single writes hold txMu for reading and still race each other through the
+// compare-and-swap, while a transaction holds it for writing so that it sees
+// no concurrent writes.
 type inMemoryRepository struct {
+	// data maps the itemKey of every item to its *entry.
 	data sync.Map
+	// tenants maps the name of every registered tenant to its model.Tenant.
+	tenants sync.Map
+	txMu    sync.RWMutex
+	sweeper *sweeper
 }
 
-func NewInMemoryRepository() Repository {
-	return &inMemoryRepository{
-		data: sync.Map{},
+// entry is an item together with its history. Writers replace the whole
+// entry, so an item and its history always change together.
+type entry struct {
+	item    model.DataItem
+	history *revisionNode
+}
+
+// revisionNode is a revision in a list that is newest first. Entries share
+// the nodes of older revisions.
+type revisionNode struct {
+	model.Revision
+	prev *revisionNode
+}
+
+// next returns the entry that holds item after op, which e may be nil for.
+func (e *entry) next(ctx context.Context, item model.DataItem, op model.Operation) *entry {
+	next := &entry{item: item}
+	if e != nil {
+		next.history = e.history
 	}
+	next.history = &revisionNode{Revision: model.NewRevision(item, op, traceID(ctx)), prev: next.history}
+	return next
 }
 
-func (r *inMemoryRepository) CreateData(ctx context.Context, id string, value string) error {
-	_, span := otel.Tracer("app-tracer").Start(ctx, "CreateDataInRepo")
-	defer span.End()
+func (e *entry) revisions() []model.Revision {
+	var revisions []model.Revision
+	for node := e.history; node != nil; node = node.prev {
+		revisions = append(revisions, node.Revision)
+	}
+	slices.Reverse(revisions)
+	return revisions
+}
 
-	span.SetAttributes(attribute.String("data.id", id), attribute.String("data.value", value))
+// traceID returns the ID of the trace of the span in ctx, if it is sampled
+// or otherwise valid.
+func traceID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
 
-	if _, exists := r.data.Load(id); exists {
-		span.SetStatus(codes.Error, "data already exists")
-		return errs.NewInvalidInput(fmt.Errorf("data with ID %s already exists", id))
+func NewInMemoryRepository(expiry ExpiryOptions) Repository {
+	r := newInMemoryRepository()
+	r.sweeper = startSweeper(expiry, r.sweepExpired)
+	return r
+}
+
+func newInMemoryRepository() *inMemoryRepository {
+	return &inMemoryRepository{
+		data: sync.Map{},
 	}
+}
 
-	r.data.Store(id, value)
-	span.SetStatus(codes.Ok, "success")
-	return nil
+// load returns the stored entry of key, which may be in the trash or expired.
+func (r *inMemoryRepository) load(key itemKey) (*entry, bool) {
+	value, ok := r.data.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return value.(*entry), true
 }
 
-func (r *inMemoryRepository) GetData(ctx context.Context, id string) (string, error) {
-	_, span := otel.Tracer("app-tracer").Start(ctx, "GetDataFromRepo")
-	defer span.End()
+// loadLive returns the entry of key unless it does not exist, is in the trash
+// or has expired.
+func (r *inMemoryRepository) loadLive(key itemKey) (*entry, bool) {
+	current, ok := r.load(key)
+	if !ok || current.item.Deleted() || current.item.Expired(time.Now()) {
+		return nil, false
+	}
+	return current, true
+}
 
-	span.SetAttributes(attribute.String("data.id", id))
+func (r *inMemoryRepository) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
+	if err := r.checkTenant(ctx); err != nil {
+		return model.DataItem{}, err
+	}
 
-	value, ok := r.data.Load(id)
+	key := scopedKey(ctx, id)
+	for {
+		current, _ := r.load(key)
+		next, err := current.create(ctx, id, value, labels, expiresAt)
+		if err != nil {
+			return model.DataItem{}, err
+		}
+		if r.swap(key, current, next) {
+			return next.item, nil
+		}
+	}
+}
+
+func (r *inMemoryRepository) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	current, ok := r.loadLive(scopedKey(ctx, id))
 	if !ok {
-		span.SetStatus(codes.Error, "data not found")
-		return "", errs.NewNotFound(fmt.Errorf("data with ID %s not found", id))
+		return model.DataItem{}, errs.NewNotFound(fmt.Errorf("data with ID %s not found", id), errs.D("id", id))
 	}
+	return current.item, nil
+}
+
+func (r *inMemoryRepository) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
 
-	span.SetStatus(codes.Ok, "success")
-	return value.(string), nil
+	key := scopedKey(ctx, id)
+	for {
+		current, _ := r.load(key)
+		next, err := current.update(ctx, id, newValue, labels, expiresAt, expectedVersion)
+		if err != nil {
+			return model.DataItem{}, err
+		}
+		if r.swap(key, current, next) {
+			return next.item, nil
+		}
+	}
 }
 
-func (r *inMemoryRepository) UpdateData(ctx context.Context, id string, newValue string) error {
-	_, span := otel.Tracer("app-tracer").Start(ctx, "UpdateDataInRepo")
-	defer span.End()
+func (r *inMemoryRepository) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
 
-	span.SetAttributes(attribute.String("data.id", id), attribute.String("data.newValue", newValue))
+	key := scopedKey(ctx, id)
+	for {
+		current, _ := r.load(key)
+		next, err := current.delete(ctx, id, expectedVersion, actor)
+		if err != nil {
+			return model.DataItem{}, err
+		}
+		if r.swap(key, current, next) {
+			return next.item, nil
+		}
+	}
+}
 
-	if _, exists := r.data.Load(id); !exists {
-		span.SetStatus(codes.Error, "data not found")
-		return errs.NewInvalidInput(fmt.Errorf("data with ID %s not found", id))
+// swap replaces current, which is nil if key does not exist, with next unless
+// key was changed meanwhile.
+func (r *inMemoryRepository) swap(key itemKey, current *entry, next *entry) bool {
+	if current == nil {
+		_, loaded := r.data.LoadOrStore(key, next)
+		return !loaded
 	}
+	return r.data.CompareAndSwap(key, current, next)
+}
 
-	r.data.Store(id, newValue)
-	span.SetStatus(codes.Ok, "success")
-	return nil
+// live reports whether e holds an item that is neither in the trash nor
+// expired. e may be nil.
+func (e *entry) live(now time.Time) bool {
+	return e != nil && !e.item.Deleted() && !e.item.Expired(now)
 }
 
-func (r *inMemoryRepository) DeleteData(ctx context.Context, id string) error {
-	_, span := otel.Tracer("app-tracer").Start(ctx, "DeleteDataInRepo")
-	defer span.End()
+// create returns the entry that creates id in the tenant of ctx over e, which
+// is nil if id does not exist.
+func (e *entry) create(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (*entry, error) {
+	now := time.Now().UTC()
+	if e.live(now) {
+		return nil, errs.NewConflict(fmt.Errorf("data with ID %s already exists", id), errs.D("id", id))
+	}
 
-	span.SetAttributes(attribute.String("data.id", id))
+	item := model.DataItem{Tenant: tenant.FromContext(ctx), ID: id, Value: value, Labels: cloneLabels(labels), Version: 1, CreatedAt: now, UpdatedAt: now, ExpiresAt: expiresAt}
+	if e != nil {
+		// Keep counting versions of a trashed or expired item so that ETags
+		// of the old item never match the new one.
+		item.Version = e.item.Version + 1
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("data.replacedTrash", true))
+	}
+	return e.next(ctx, item, model.OperationCreate), nil
+}
 
-	if _, exists := r.data.Load(id); !exists {
-		span.SetStatus(codes.Error, "data not found")
-		return errs.NewNotFound(fmt.Errorf("data with ID %s not found", id))
+// update returns the entry that replaces the value, labels and expiry of e.
+func (e *entry) update(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (*entry, error) {
+	now := time.Now().UTC()
+	if !e.live(now) {
+		return nil, errs.NewNotFound(fmt.Errorf("data with ID %s not found", id), errs.D("id", id))
+	}
+	if expectedVersion != AnyVersion && e.item.Version != expectedVersion {
+		return nil, errs.NewConflict(fmt.Errorf("data with ID %s is at version %d, expected %d", id, e.item.Version, expectedVersion), errs.D("id", id), errs.D("version", e.item.Version), errs.D("expected_version", expectedVersion))
 	}
 
-	r.data.Delete(id)
-	span.SetStatus(codes.Ok, "success")
-	return nil
+	item := model.DataItem{
+		Tenant:    e.item.Tenant,
+		ID:        id,
+		Value:     newValue,
+		Labels:    cloneLabels(labels),
+		Version:   e.item.Version + 1,
+		CreatedAt: e.item.CreatedAt,
+		UpdatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	return e.next(ctx, item, model.OperationUpdate), nil
 }
 
-func (r *inMemoryRepository) ListAllData(ctx context.Context) ([]model.DataItem, error) {
-	_, span := otel.Tracer("app-tracer").Start(ctx, "ListAllDataInRepo")
-	defer span.End()
+// cloneLabels copies labels so that callers cannot change stored items, and
+// treats no labels like the SQL repository does, as nil.
+func cloneLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	return maps.Clone(labels)
+}
+
+// delete returns the entry that moves e to the trash on behalf of actor.
+func (e *entry) delete(ctx context.Context, id string, expectedVersion int64, actor string) (*entry, error) {
+	now := time.Now().UTC()
+	if !e.live(now) {
+		return nil, errs.NewNotFound(fmt.Errorf("data with ID %s not found", id), errs.D("id", id))
+	}
+	if expectedVersion != AnyVersion && e.item.Version != expectedVersion {
+		return nil, errs.NewConflict(fmt.Errorf("data with ID %s is at version %d, expected %d", id, e.item.Version, expectedVersion), errs.D("id", id), errs.D("version", e.item.Version), errs.D("expected_version", expectedVersion))
+	}
 
-	// TODO: maybe debug SQL query here
+	item := e.item
+	item.Version++
+	item.UpdatedAt = now
+	item.DeletedAt = &now
+	item.DeletedBy = actor
+	return e.next(ctx, item, model.OperationDelete), nil
+}
+
+func (r *inMemoryRepository) ListAllData(ctx context.Context, opts ListOptions) (Page, error) {
 	slog.DebugContext(ctx, "Listing all data items")
+	return r.list(tenant.FromContext(ctx), opts, false)
+}
+
+func (r *inMemoryRepository) ListTrash(ctx context.Context, opts ListOptions) (Page, error) {
+	return r.list(tenant.FromContext(ctx), opts, true)
+}
+
+// list returns a page of the items of a tenant that are in the trash or not.
+func (r *inMemoryRepository) list(name string, opts ListOptions, deleted bool) (Page, error) {
+	c, err := opts.normalize()
+	if err != nil {
+		return Page{}, err
+	}
 
+	now := time.Now()
 	dataList := make([]model.DataItem, 0)
 	r.data.Range(func(key, value any) bool {
-		dataList = append(dataList, model.DataItem{ID: key.(string), Value: value.(string)})
+		item := value.(*entry).item
+		if item.Tenant == name && item.Deleted() == deleted && !item.Expired(now) && (opts.Filter == nil || opts.Filter.Match(item)) {
+			dataList = append(dataList, item)
+		}
+		return true
+	})
+	return paginate(dataList, opts, c), nil
+}
+
+func (r *inMemoryRepository) RestoreData(ctx context.Context, id string) (model.DataItem, error) {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
+
+	key := scopedKey(ctx, id)
+	for {
+		current, exists := r.load(key)
+		if !exists || !current.item.Deleted() || current.item.Expired(time.Now()) {
+			return model.DataItem{}, errs.NewNotFound(fmt.Errorf("data with ID %s not found in trash", id), errs.D("id", id))
+		}
+
+		item := current.item
+		item.Version++
+		item.UpdatedAt = time.Now().UTC()
+		item.DeletedAt = nil
+		item.DeletedBy = ""
+		if r.data.CompareAndSwap(key, current, current.next(ctx, item, model.OperationRestore)) {
+			return item, nil
+		}
+	}
+}
+
+func (r *inMemoryRepository) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	return r.removeIf(trashedBefore(before)), nil
+}
+
+func (r *inMemoryRepository) GetHistory(ctx context.Context, id string) ([]model.Revision, error) {
+	current, ok := r.load(scopedKey(ctx, id))
+	if !ok {
+		return nil, errs.NewNotFound(fmt.Errorf("data with ID %s not found", id), errs.D("id", id))
+	}
+	revisions := current.revisions()
+	if len(revisions) == 0 {
+		return nil, errs.NewNotFound(fmt.Errorf("data with ID %s has no history", id), errs.D("id", id))
+	}
+	return revisions, nil
+}
+
+func (r *inMemoryRepository) sweepExpired(ctx context.Context, now time.Time) (int, error) {
+	return r.removeIf(expiredAt(now)), nil
+}
+
+// removeIf removes the items for which match returns true and returns how
+// many there were. An item is only removed if it was not replaced meanwhile.
+func (r *inMemoryRepository) removeIf(match func(*model.DataItem) bool) int {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
+
+	removed := 0
+	r.data.Range(func(key, value any) bool {
+		current := value.(*entry)
+		if match(&current.item) && r.data.CompareAndDelete(key, current) {
+			removed++
+		}
 		return true
 	})
+	return removed
+}
 
-	span.SetStatus(codes.Ok, "success")
-	return dataList, nil
+func trashedBefore(before time.Time) func(*model.DataItem) bool {
+	return func(item *model.DataItem) bool {
+		return item.Deleted() && item.DeletedAt.Before(before)
+	}
+}
+
+func expiredAt(now time.Time) func(*model.DataItem) bool {
+	return func(item *model.DataItem) bool {
+		return item.Expired(now)
+	}
+}
+
+func (r *inMemoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (r *inMemoryRepository) Close() error {
+	if r.sweeper != nil {
+		r.sweeper.close()
+	}
+	return nil
 }
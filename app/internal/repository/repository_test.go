@@ -2,35 +2,86 @@ package repository_test
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"simple_lgtm/internal/filter"
 	"simple_lgtm/internal/model"
 	"simple_lgtm/internal/repository"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestInMemoryRepository(t *testing.T) {
+	testRepository(t, func(t *testing.T, expiry repository.ExpiryOptions) repository.Repository {
+		return repository.NewInMemoryRepository(expiry)
+	})
+}
+
+// testRepository is the behaviour every Repository implementation must have.
+func testRepository(t *testing.T, newRepo func(t *testing.T, expiry repository.ExpiryOptions) repository.Repository) {
 
 	t.Run("CreateData", func(t *testing.T) {
 		ctx := context.Background()
-		repo := repository.NewInMemoryRepository()
+		repo := newRepo(t, repository.ExpiryOptions{})
 
-		err := repo.CreateData(ctx, "1", "value1")
+		item, err := repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
 		assert.NoError(t, err)
+		assert.Equal(t, "1", item.ID)
+		assert.Equal(t, "value1", item.Value.Text())
+		assert.Equal(t, int64(1), item.Version)
+		assert.False(t, item.CreatedAt.IsZero())
+		assert.Equal(t, item.CreatedAt, item.UpdatedAt)
 
-		err = repo.CreateData(ctx, "1", "value2")
-		assert.Error(t, err)
+		_, err = repo.CreateData(ctx, "1", model.StringValue("value2"), nil, nil)
+		assert.True(t, errs.IsConflict(err))
+		assert.Equal(t, map[string]any{"id": "1"}, errs.Details(err))
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		repo := newRepo(t, repository.ExpiryOptions{})
+		assert.NoError(t, repo.Ping(context.Background()))
+	})
+
+	t.Run("ConcurrentCreateData", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		created := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := repo.CreateData(ctx, "1", model.StringValue("value"), nil, nil); err == nil {
+					mu.Lock()
+					created++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, created)
 	})
 
 	t.Run("GetData", func(t *testing.T) {
 		ctx := context.Background()
-		repo := repository.NewInMemoryRepository()
+		repo := newRepo(t, repository.ExpiryOptions{})
 
-		repo.CreateData(ctx, "2", "value2")
-		value, err := repo.GetData(ctx, "2")
+		repo.CreateData(ctx, "2", model.StringValue("value2"), nil, nil)
+		item, err := repo.GetData(ctx, "2")
 		assert.NoError(t, err)
-		assert.Equal(t, "value2", value)
+		assert.Equal(t, "value2", item.Value.Text())
+		assert.Equal(t, int64(1), item.Version)
 
 		_, err = repo.GetData(ctx, "nonexistent")
 		assert.Error(t, err)
@@ -38,46 +89,709 @@ func TestInMemoryRepository(t *testing.T) {
 
 	t.Run("UpdateData", func(t *testing.T) {
 		ctx := context.Background()
-		repo := repository.NewInMemoryRepository()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "3", model.StringValue("value3"), nil, nil)
+		created, _ := repo.GetData(ctx, "3")
+		item, err := repo.UpdateData(ctx, "3", model.StringValue("newValue3"), nil, nil, repository.AnyVersion)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), item.Version)
+
+		item, _ = repo.GetData(ctx, "3")
+		assert.Equal(t, "newValue3", item.Value.Text())
+		assert.Equal(t, int64(2), item.Version)
+		assert.Equal(t, created.CreatedAt, item.CreatedAt)
+		assert.True(t, item.UpdatedAt.After(created.UpdatedAt))
+
+		_, err = repo.UpdateData(ctx, "nonexistent", model.StringValue("value"), nil, nil, repository.AnyVersion)
+		assert.True(t, errs.IsNotFound(err))
+	})
+
+	t.Run("JSONValuesAndLabels", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
 
-		repo.CreateData(ctx, "3", "value3")
-		err := repo.UpdateData(ctx, "3", "newValue3")
+		labels := map[string]string{"env": "prod"}
+		repo.CreateData(ctx, "1", model.Value(`{"a":[1,2],"b":null}`), labels, nil)
+		repo.CreateData(ctx, "2", model.Value(`42`), nil, nil)
+		labels["env"] = "changed"
+
+		item, err := repo.GetData(ctx, "1")
 		assert.NoError(t, err)
+		assert.Equal(t, model.Value(`{"a":[1,2],"b":null}`), item.Value)
+		assert.Equal(t, map[string]string{"env": "prod"}, item.Labels)
 
-		value, _ := repo.GetData(ctx, "3")
-		assert.Equal(t, "newValue3", value)
+		item, err = repo.UpdateData(ctx, "1", model.Value(`[true]`), map[string]string{"env": "dev", "team": "core"}, nil, repository.AnyVersion)
+		assert.NoError(t, err)
+		item, _ = repo.GetData(ctx, "1")
+		assert.Equal(t, model.Value(`[true]`), item.Value)
+		assert.Equal(t, map[string]string{"env": "dev", "team": "core"}, item.Labels)
 
-		err = repo.UpdateData(ctx, "nonexistent", "value")
+		page, err := repo.ListAllData(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 2) {
+			assert.Equal(t, item.Labels, page.Items[0].Labels)
+			assert.Nil(t, page.Items[1].Labels)
+		}
+
+		revisions, _ := repo.GetHistory(ctx, "1")
+		if assert.Len(t, revisions, 2) {
+			assert.Equal(t, map[string]string{"env": "prod"}, revisions[0].Labels)
+			assert.Equal(t, model.Value(`[true]`), revisions[1].Value)
+		}
+
+		// Values that are not strings are filtered by their encoding.
+		expr, _ := filter.Parse(`value = "42"`)
+		page, err = repo.ListAllData(ctx, repository.ListOptions{Filter: expr})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, "2", page.Items[0].ID)
+		}
+	})
+
+	t.Run("UpdateDataWithVersion", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "3", model.StringValue("value3"), nil, nil)
+		item, err := repo.UpdateData(ctx, "3", model.StringValue("newValue3"), nil, nil, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), item.Version)
+
+		_, err = repo.UpdateData(ctx, "3", model.StringValue("staleValue3"), nil, nil, 1)
+		assert.True(t, errs.IsConflict(err))
+		assert.Equal(t, map[string]any{"id": "3", "version": int64(2), "expected_version": int64(1)}, errs.Details(err))
+
+		item, _ = repo.GetData(ctx, "3")
+		assert.Equal(t, "newValue3", item.Value.Text())
+
+		_, err = repo.UpdateData(ctx, "nonexistent", model.StringValue("value"), nil, nil, 1)
+		assert.True(t, errs.IsNotFound(err))
+	})
+
+	t.Run("ConcurrentUpdateData", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "3", model.StringValue("value3"), nil, nil)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		updated := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := repo.UpdateData(ctx, "3", model.StringValue("newValue3"), nil, nil, 1); err == nil {
+					mu.Lock()
+					updated++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, updated)
+
+		item, _ := repo.GetData(ctx, "3")
+		assert.Equal(t, int64(2), item.Version)
+	})
+
+	t.Run("PatchData", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+		repo.CreateData(ctx, "p", model.Value(`{"count":0,"name":"p"}`), map[string]string{"env": "prod"}, &expiresAt)
+
+		item, err := repository.PatchData(ctx, repo, "p", func(current model.Value) (model.Value, error) {
+			assert.Equal(t, model.Value(`{"count":0,"name":"p"}`), current)
+			return model.Value(`{"count":1,"name":"p"}`), nil
+		}, 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), item.Version)
+		assert.Equal(t, model.Value(`{"count":1,"name":"p"}`), item.Value)
+		assert.Equal(t, map[string]string{"env": "prod"}, item.Labels)
+		assert.True(t, expiresAt.Equal(*item.ExpiresAt))
+
+		_, err = repository.PatchData(ctx, repo, "p", func(current model.Value) (model.Value, error) {
+			t.Error("patch applied at a stale version")
+			return current, nil
+		}, 1)
+		assert.True(t, errs.IsConflict(err))
+
+		// An error of the patch leaves the item unchanged.
+		_, err = repository.PatchData(ctx, repo, "p", func(model.Value) (model.Value, error) {
+			return "", errs.NewInvalidInput(fmt.Errorf("invalid patch"))
+		}, repository.AnyVersion)
 		assert.Error(t, err)
+		item, _ = repo.GetData(ctx, "p")
+		assert.Equal(t, int64(2), item.Version)
+
+		_, err = repository.PatchData(ctx, repo, "nonexistent", func(current model.Value) (model.Value, error) {
+			return current, nil
+		}, repository.AnyVersion)
+		assert.True(t, errs.IsNotFound(err))
+	})
+
+	t.Run("ConcurrentPatchData", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "counter", model.Value(`0`), nil, nil)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		patched := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := repository.PatchData(ctx, repo, "counter", func(current model.Value) (model.Value, error) {
+					n, err := strconv.Atoi(string(current))
+					return model.Value(strconv.Itoa(n + 1)), err
+				}, repository.AnyVersion)
+				if err == nil {
+					mu.Lock()
+					patched++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		// Patches may fail with a conflict, but none is lost.
+		item, _ := repo.GetData(ctx, "counter")
+		assert.Equal(t, model.Value(strconv.Itoa(patched)), item.Value)
+		assert.Equal(t, int64(patched+1), item.Version)
 	})
 
 	t.Run("DeleteData", func(t *testing.T) {
 		ctx := context.Background()
-		repo := repository.NewInMemoryRepository()
+		repo := newRepo(t, repository.ExpiryOptions{})
 
-		repo.CreateData(ctx, "4", "value4")
-		err := repo.DeleteData(ctx, "4")
+		repo.CreateData(ctx, "4", model.StringValue("value4"), nil, nil)
+		item, err := repo.DeleteData(ctx, "4", repository.AnyVersion, "tester")
 		assert.NoError(t, err)
+		assert.Equal(t, "4", item.ID)
+		assert.Equal(t, int64(2), item.Version)
+		assert.Equal(t, "tester", item.DeletedBy)
+		if assert.NotNil(t, item.DeletedAt) {
+			assert.Equal(t, item.UpdatedAt, *item.DeletedAt)
+		}
 
 		_, err = repo.GetData(ctx, "4")
 		assert.Error(t, err)
 
-		err = repo.DeleteData(ctx, "nonexistent")
+		history, err := repo.GetHistory(ctx, "4")
+		require.NoError(t, err)
+		if assert.Len(t, history, 2) {
+			assert.Equal(t, item.Version, history[1].Version)
+			assert.True(t, item.UpdatedAt.Equal(history[1].Timestamp))
+		}
+
+		_, err = repo.DeleteData(ctx, "nonexistent", repository.AnyVersion, "tester")
 		assert.Error(t, err)
 	})
 
+	t.Run("DeleteDataWithVersion", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "4", model.StringValue("value4"), nil, nil)
+		repo.UpdateData(ctx, "4", model.StringValue("newValue4"), nil, nil, repository.AnyVersion)
+
+		_, err := repo.DeleteData(ctx, "4", 1, "tester")
+		assert.True(t, errs.IsConflict(err))
+
+		_, err = repo.DeleteData(ctx, "4", 2, "tester")
+		assert.NoError(t, err)
+	})
+
+	t.Run("TrashAndRestore", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		repo.CreateData(ctx, "2", model.StringValue("value2"), nil, nil)
+		_, err := repo.DeleteData(ctx, "1", repository.AnyVersion, "tester")
+		assert.NoError(t, err)
+
+		_, err = repo.GetData(ctx, "1")
+		assert.Error(t, err)
+		_, err = repo.UpdateData(ctx, "1", model.StringValue("newValue1"), nil, nil, repository.AnyVersion)
+		assert.Error(t, err)
+		_, err = repo.DeleteData(ctx, "1", repository.AnyVersion, "tester")
+		assert.Error(t, err)
+
+		page, err := repo.ListAllData(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, "2", page.Items[0].ID)
+		}
+
+		page, err = repo.ListTrash(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, "1", page.Items[0].ID)
+			assert.Equal(t, "tester", page.Items[0].DeletedBy)
+			assert.NotNil(t, page.Items[0].DeletedAt)
+			assert.Equal(t, int64(2), page.Items[0].Version)
+		}
+
+		item, err := repo.RestoreData(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "value1", item.Value.Text())
+		assert.Equal(t, int64(3), item.Version)
+		assert.Nil(t, item.DeletedAt)
+		assert.Empty(t, item.DeletedBy)
+
+		_, err = repo.GetData(ctx, "1")
+		assert.NoError(t, err)
+
+		_, err = repo.RestoreData(ctx, "1")
+		assert.Error(t, err)
+		_, err = repo.RestoreData(ctx, "nonexistent")
+		assert.Error(t, err)
+	})
+
+	t.Run("CreateDataOverTrash", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		repo.DeleteData(ctx, "1", repository.AnyVersion, "tester")
+
+		item, err := repo.CreateData(ctx, "1", model.StringValue("newValue1"), nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "newValue1", item.Value.Text())
+		assert.Equal(t, int64(3), item.Version)
+		assert.Nil(t, item.DeletedAt)
+
+		page, err := repo.ListTrash(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, page.Items)
+	})
+
+	t.Run("PurgeDeleted", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		repo.CreateData(ctx, "2", model.StringValue("value2"), nil, nil)
+		repo.DeleteData(ctx, "1", repository.AnyVersion, "tester")
+
+		purged, err := repo.PurgeDeleted(ctx, time.Now().Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, purged)
+
+		purged, err = repo.PurgeDeleted(ctx, time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, purged)
+
+		page, err := repo.ListTrash(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, page.Items)
+		_, err = repo.RestoreData(ctx, "1")
+		assert.Error(t, err)
+		_, err = repo.GetData(ctx, "2")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		expiresAt := time.Now().Add(200 * time.Millisecond).UTC()
+		item, err := repo.CreateData(ctx, "1", model.StringValue("value1"), nil, &expiresAt)
+		assert.NoError(t, err)
+		if assert.NotNil(t, item.ExpiresAt) {
+			assert.True(t, expiresAt.Equal(*item.ExpiresAt))
+		}
+		repo.CreateData(ctx, "2", model.StringValue("value2"), nil, nil)
+
+		item, err = repo.GetData(ctx, "1")
+		assert.NoError(t, err)
+		assert.NotNil(t, item.ExpiresAt)
+
+		assert.Eventually(t, func() bool {
+			_, err := repo.GetData(ctx, "1")
+			return err != nil
+		}, 2*time.Second, 10*time.Millisecond)
+
+		page, err := repo.ListAllData(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, "2", page.Items[0].ID)
+		}
+		_, err = repo.UpdateData(ctx, "1", model.StringValue("newValue1"), nil, nil, repository.AnyVersion)
+		assert.Error(t, err)
+
+		item, err = repo.CreateData(ctx, "1", model.StringValue("newValue1"), nil, nil)
+		assert.NoError(t, err)
+		assert.Nil(t, item.ExpiresAt)
+	})
+
+	t.Run("UpdateDataExpiry", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		expiresAt := time.Now().Add(time.Hour).UTC()
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		item, err := repo.UpdateData(ctx, "1", model.StringValue("newValue1"), nil, &expiresAt, repository.AnyVersion)
+		assert.NoError(t, err)
+		assert.NotNil(t, item.ExpiresAt)
+
+		item, err = repo.UpdateData(ctx, "1", model.StringValue("newValue1"), nil, nil, repository.AnyVersion)
+		assert.NoError(t, err)
+		assert.Nil(t, item.ExpiresAt)
+	})
+
+	t.Run("SweepExpired", func(t *testing.T) {
+		ctx := context.Background()
+		counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "expired_total"})
+		repo := newRepo(t, repository.ExpiryOptions{SweepInterval: 10 * time.Millisecond, ExpiredCounter: counter})
+
+		expiresAt := time.Now().Add(50 * time.Millisecond)
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, &expiresAt)
+		repo.CreateData(ctx, "2", model.StringValue("value2"), nil, nil)
+
+		assert.Eventually(t, func() bool {
+			return testutil.ToFloat64(counter) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+
+		// Versions start over once the expired item is gone for good.
+		item, err := repo.CreateData(ctx, "1", model.StringValue("newValue1"), nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), item.Version)
+	})
+
+	t.Run("History", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		repo.UpdateData(ctx, "1", model.StringValue("newValue1"), nil, nil, repository.AnyVersion)
+		repo.DeleteData(ctx, "1", repository.AnyVersion, "tester")
+		repo.RestoreData(ctx, "1")
+
+		revisions, err := repo.GetHistory(ctx, "1")
+		assert.NoError(t, err)
+		if assert.Len(t, revisions, 4) {
+			for i, want := range []struct {
+				op    model.Operation
+				value string
+			}{
+				{model.OperationCreate, "value1"},
+				{model.OperationUpdate, "newValue1"},
+				{model.OperationDelete, "newValue1"},
+				{model.OperationRestore, "newValue1"},
+			} {
+				assert.Equal(t, int64(i+1), revisions[i].Version)
+				assert.Equal(t, want.op, revisions[i].Operation)
+				assert.Equal(t, want.value, revisions[i].Value.Text())
+				assert.False(t, revisions[i].Timestamp.IsZero())
+			}
+			assert.Equal(t, "tester", revisions[2].Actor)
+		}
+
+		_, err = repo.GetHistory(ctx, "nonexistent")
+		assert.Error(t, err)
+
+		repo.DeleteData(ctx, "1", repository.AnyVersion, "tester")
+		repo.PurgeDeleted(ctx, time.Now().Add(time.Second))
+		_, err = repo.GetHistory(ctx, "1")
+		assert.Error(t, err)
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+
+		err := repo.InTx(ctx, func(tx repository.Tx) error {
+			if _, err := tx.CreateData(ctx, "2", model.StringValue("value2"), nil, nil); err != nil {
+				return err
+			}
+			item, err := tx.GetData(ctx, "2")
+			assert.NoError(t, err)
+			assert.Equal(t, "value2", item.Value.Text())
+			if _, err := tx.UpdateData(ctx, "2", model.StringValue("newValue2"), nil, nil, 1); err != nil {
+				return err
+			}
+			_, err = tx.DeleteData(ctx, "1", 1, "tester")
+			return err
+		})
+		assert.NoError(t, err)
+
+		item, err := repo.GetData(ctx, "2")
+		assert.NoError(t, err)
+		assert.Equal(t, "newValue2", item.Value.Text())
+		assert.Equal(t, int64(2), item.Version)
+		revisions, err := repo.GetHistory(ctx, "2")
+		assert.NoError(t, err)
+		assert.Len(t, revisions, 2)
+		_, err = repo.GetData(ctx, "1")
+		assert.Error(t, err)
+
+		err = repo.InTx(ctx, func(tx repository.Tx) error {
+			if _, err := tx.CreateData(ctx, "3", model.StringValue("value3"), nil, nil); err != nil {
+				return err
+			}
+			if _, err := tx.UpdateData(ctx, "2", model.StringValue("lost"), nil, nil, repository.AnyVersion); err != nil {
+				return err
+			}
+			_, err := tx.UpdateData(ctx, "2", model.StringValue("stale"), nil, nil, 2)
+			return err
+		})
+		assert.True(t, errs.IsConflict(err))
+
+		_, err = repo.GetData(ctx, "3")
+		assert.Error(t, err)
+		item, err = repo.GetData(ctx, "2")
+		assert.NoError(t, err)
+		assert.Equal(t, "newValue2", item.Value.Text())
+		revisions, err = repo.GetHistory(ctx, "2")
+		assert.NoError(t, err)
+		assert.Len(t, revisions, 2)
+	})
+
 	t.Run("ListAllData", func(t *testing.T) {
 		ctx := context.Background()
-		repo := repository.NewInMemoryRepository()
+		repo := newRepo(t, repository.ExpiryOptions{})
 
-		repo.CreateData(ctx, "5", "value5")
-		repo.CreateData(ctx, "6", "value6")
+		repo.CreateData(ctx, "6", model.StringValue("value6"), nil, nil)
+		repo.CreateData(ctx, "5", model.StringValue("value5"), nil, nil)
 
-		dataList, err := repo.ListAllData(ctx)
+		page, err := repo.ListAllData(ctx, repository.ListOptions{})
 		assert.NoError(t, err)
-		assert.Len(t, dataList, 2)
+		assert.Empty(t, page.NextCursor)
+		if assert.Len(t, page.Items, 2) {
+			assert.Equal(t, "5", page.Items[0].ID)
+			assert.Equal(t, "value5", page.Items[0].Value.Text())
+			assert.Equal(t, "6", page.Items[1].ID)
+			assert.Equal(t, "value6", page.Items[1].Value.Text())
+		}
+	})
+
+	t.Run("ListAllDataPagination", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
 
-		assert.Contains(t, dataList, model.DataItem{ID: "5", Value: "value5"})
-		assert.Contains(t, dataList, model.DataItem{ID: "6", Value: "value6"})
+		for _, id := range []string{"c", "a", "e", "b", "d"} {
+			repo.CreateData(ctx, id, model.StringValue("value"), nil, nil)
+		}
+		repo.UpdateData(ctx, "a", model.StringValue("newValue"), nil, nil, repository.AnyVersion)
+
+		for _, tc := range []struct {
+			opts repository.ListOptions
+			want []string
+		}{
+			{repository.ListOptions{Limit: 2}, []string{"a", "b", "c", "d", "e"}},
+			{repository.ListOptions{Limit: 2, Descending: true}, []string{"e", "d", "c", "b", "a"}},
+			{repository.ListOptions{Limit: 2, OrderBy: repository.OrderByCreatedAt}, []string{"c", "a", "e", "b", "d"}},
+			{repository.ListOptions{Limit: 3, OrderBy: repository.OrderByUpdatedAt, Descending: true}, []string{"a", "d", "b", "e", "c"}},
+		} {
+			var got []string
+			opts := tc.opts
+			for {
+				page, err := repo.ListAllData(ctx, opts)
+				if !assert.NoError(t, err) {
+					break
+				}
+				assert.LessOrEqual(t, len(page.Items), opts.Limit)
+				for _, item := range page.Items {
+					got = append(got, item.ID)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+			assert.Equal(t, tc.want, got, "%+v", tc.opts)
+		}
+	})
+
+	t.Run("ListAllDataFilter", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "order-1", model.StringValue("paid"), nil, nil)
+		repo.CreateData(ctx, "order-2", model.StringValue("payment error"), nil, nil)
+		repo.CreateData(ctx, "order-3", model.StringValue("Error"), nil, nil)
+		repo.CreateData(ctx, "invoice-1", model.StringValue("error"), nil, nil)
+		repo.CreateData(ctx, "ORDER-4", model.StringValue("paid"), nil, nil)
+
+		for _, tc := range []struct {
+			filter string
+			want   []string
+		}{
+			{`id ^= "order-"`, []string{"order-1", "order-2", "order-3"}},
+			{`value *= "error"`, []string{"invoice-1", "order-2"}},
+			{`value = "paid" AND NOT id = "ORDER-4"`, []string{"order-1"}},
+			{`id ^= "invoice-" OR value != "paid"`, []string{"invoice-1", "order-2", "order-3"}},
+			{`value ~ "^[Ee]rror$"`, []string{"invoice-1", "order-3"}},
+			{`id ^= "order-" AND value ~ "rror"`, []string{"order-2", "order-3"}},
+			{`NOT (value ~ "rror" OR id = "order-1")`, []string{"ORDER-4"}},
+		} {
+			expr, err := filter.Parse(tc.filter)
+			if !assert.NoError(t, err, tc.filter) {
+				continue
+			}
+
+			var got []string
+			opts := repository.ListOptions{Limit: 1, Filter: expr}
+			for {
+				page, err := repo.ListAllData(ctx, opts)
+				if !assert.NoError(t, err) {
+					break
+				}
+				for _, item := range page.Items {
+					got = append(got, item.ID)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+			assert.Equal(t, tc.want, got, tc.filter)
+		}
+	})
+
+	t.Run("ListAllDataInvalidOptions", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		repo.CreateData(ctx, "a", model.StringValue("value"), nil, nil)
+		repo.CreateData(ctx, "b", model.StringValue("value"), nil, nil)
+
+		_, err := repo.ListAllData(ctx, repository.ListOptions{OrderBy: "value"})
+		assert.Error(t, err)
+
+		_, err = repo.ListAllData(ctx, repository.ListOptions{Cursor: "not a cursor"})
+		assert.Error(t, err)
+
+		page, _ := repo.ListAllData(ctx, repository.ListOptions{Limit: 1})
+		_, err = repo.ListAllData(ctx, repository.ListOptions{Limit: 1, Cursor: page.NextCursor, Descending: true})
+		assert.Error(t, err)
+	})
+
+	t.Run("Tenants", func(t *testing.T) {
+		ctx := context.Background()
+		acme := tenant.WithTenant(ctx, "acme")
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		_, err := repo.GetTenant(ctx, "acme")
+		assert.True(t, errs.IsNotFound(err))
+
+		created, err := repo.CreateTenant(ctx, "acme", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, "acme", created.Name)
+		_, err = repo.CreateTenant(ctx, "acme", 10)
+		assert.True(t, errs.IsConflict(err))
+		repo.CreateTenant(ctx, "globex", 10)
+
+		got, err := repo.GetTenant(ctx, "acme")
+		assert.NoError(t, err)
+		assert.Equal(t, "acme", got.Name)
+		tenants, err := repo.ListTenants(ctx)
+		assert.NoError(t, err)
+		if assert.Len(t, tenants, 2) {
+			assert.Equal(t, "acme", tenants[0].Name)
+			assert.Equal(t, "globex", tenants[1].Name)
+		}
+
+		// The same id names a different item in every tenant.
+		item, err := repo.CreateData(ctx, "1", model.StringValue("default value"), nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, tenant.Default, item.Tenant)
+		item, err = repo.CreateData(acme, "1", model.StringValue("acme value"), nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "acme", item.Tenant)
+		repo.CreateData(acme, "2", model.StringValue("acme value"), nil, nil)
+
+		item, _ = repo.GetData(ctx, "1")
+		assert.Equal(t, "default value", item.Value.Text())
+		_, err = repo.UpdateData(acme, "1", model.StringValue("acme update"), nil, nil, 1)
+		assert.NoError(t, err)
+		item, _ = repo.GetData(ctx, "1")
+		assert.Equal(t, int64(1), item.Version)
+
+		page, _ := repo.ListAllData(ctx, repository.ListOptions{})
+		assert.Len(t, page.Items, 1)
+		page, _ = repo.ListAllData(acme, repository.ListOptions{})
+		assert.Len(t, page.Items, 2)
+		history, _ := repo.GetHistory(acme, "1")
+		assert.Len(t, history, 2)
+
+		removed, err := repo.DeleteTenant(ctx, "acme")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, removed)
+		_, err = repo.DeleteTenant(ctx, "acme")
+		assert.True(t, errs.IsNotFound(err))
+
+		_, err = repo.GetData(acme, "1")
+		assert.True(t, errs.IsNotFound(err))
+		_, err = repo.GetHistory(acme, "1")
+		assert.True(t, errs.IsNotFound(err))
+		_, err = repo.GetData(ctx, "1")
+		assert.NoError(t, err)
+
+		// Items are only created in tenants that exist.
+		_, err = repo.CreateData(acme, "3", model.StringValue("acme value"), nil, nil)
+		assert.True(t, errs.IsNotFound(err))
+		err = repo.InTx(acme, func(tx repository.Tx) error {
+			_, err := tx.CreateData(acme, "3", model.StringValue("acme value"), nil, nil)
+			return err
+		})
+		assert.True(t, errs.IsNotFound(err))
+	})
+
+	t.Run("ConcurrentDeleteTenant", func(t *testing.T) {
+		ctx := context.Background()
+		acme := tenant.WithTenant(ctx, "acme")
+		repo := newRepo(t, repository.ExpiryOptions{})
+		_, err := repo.CreateTenant(ctx, "acme", 10)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				repo.CreateData(acme, strconv.Itoa(i), model.StringValue("value"), nil, nil)
+			}()
+		}
+		_, err = repo.DeleteTenant(ctx, "acme")
+		assert.NoError(t, err)
+		wg.Wait()
+
+		// Writes either landed before the tenant was deleted, and were
+		// removed with it, or failed.
+		page, err := repo.ListAllData(acme, repository.ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, page.Items)
+	})
+
+	t.Run("ConcurrentCreateTenant", func(t *testing.T) {
+		ctx := context.Background()
+		repo := newRepo(t, repository.ExpiryOptions{})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := repo.CreateTenant(ctx, fmt.Sprintf("tenant-%d", i), 5)
+				if err != nil {
+					assert.True(t, errs.IsForbidden(err))
+				}
+			}()
+		}
+		wg.Wait()
+
+		// The limit counts the default tenant.
+		tenants, err := repo.ListTenants(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, tenants, 4)
 	})
 }
@@ -0,0 +1,404 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A snapshot file is laid out as
+//
+//	[4B magic "SLSN"][4B LE format version][payload][4B LE CRC-32C of payload][8B LE payload length]
+//
+// where the payload is a gzip compressed stream of JSON snapshotEntry values.
+const (
+	snapshotMagic         = "SLSN"
+	snapshotFormatVersion = 1
+	snapshotHeaderSize    = 8
+	snapshotTrailerSize   = 12
+	snapshotExt           = ".snap"
+)
+
+// Snapshotter is implemented by repositories that keep their whole state in
+// memory and can save it to and load it from a snapshot payload.
+type Snapshotter interface {
+	// WriteSnapshot writes every tenant and every item with its history to
+	// w and returns the number of items. Writers are not blocked, so items
+	// changed meanwhile may be written in either state.
+	WriteSnapshot(ctx context.Context, w io.Writer) (int, error)
+	// ReadSnapshot replaces the state with the items read from r and
+	// returns their number.
+	ReadSnapshot(ctx context.Context, r io.Reader) (int, error)
+}
+
+// snapshotEntry is either an item with its history or, if Tenant is set, a
+// registered tenant.
+type snapshotEntry struct {
+	Item    model.DataItem   `json:"item"`
+	History []model.Revision `json:"history,omitempty"`
+	Tenant  *model.Tenant    `json:"tenant,omitempty"`
+}
+
+func (r *inMemoryRepository) WriteSnapshot(ctx context.Context, w io.Writer) (int, error) {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	var err error
+	r.tenants.Range(func(key, value any) bool {
+		t := value.(model.Tenant)
+		err = enc.Encode(snapshotEntry{Tenant: &t})
+		return err == nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	r.data.Range(func(key, value any) bool {
+		current := value.(*entry)
+		if err = enc.Encode(snapshotEntry{Item: current.item, History: current.revisions()}); err != nil {
+			return false
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, zw.Close()
+}
+
+// ReadSnapshot decodes the whole payload before it replaces the state, so a
+// payload that cannot be read leaves the state as it was.
+func (r *inMemoryRepository) ReadSnapshot(ctx context.Context, rd io.Reader) (int, error) {
+	zr, err := gzip.NewReader(rd)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+
+	var (
+		entries []*entry
+		tenants []model.Tenant
+	)
+	dec := json.NewDecoder(zr)
+	for {
+		var e snapshotEntry
+		if err := dec.Decode(&e); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		if e.Tenant != nil {
+			tenants = append(tenants, *e.Tenant)
+			continue
+		}
+		next := &entry{item: withTenant(e.Item)}
+		for _, rev := range e.History {
+			next.history = &revisionNode{Revision: rev, prev: next.history}
+		}
+		entries = append(entries, next)
+	}
+
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+	r.data.Clear()
+	r.tenants.Clear()
+	for _, t := range tenants {
+		r.tenants.Store(t.Name, t)
+	}
+	for _, e := range entries {
+		r.data.Store(e.key(), e)
+	}
+	return len(entries), nil
+}
+
+type SnapshotOptions struct {
+	// Dir holds the snapshot files.
+	Dir string
+	// Interval is how often a snapshot is taken. Zero disables periodic
+	// snapshots.
+	Interval time.Duration
+	// Retain is the number of snapshot files kept.
+	Retain int
+}
+
+// SnapshotInfo describes a snapshot file.
+type SnapshotInfo struct {
+	Name  string `json:"name"`
+	Items int    `json:"items"`
+	Bytes int64  `json:"bytes"`
+}
+
+// SnapshotManager saves snapshots of a Snapshotter to files and restores it
+// from them.
+type SnapshotManager struct {
+	repo             Snapshotter
+	opts             SnapshotOptions
+	latencyHistogram *prometheus.HistogramVec
+	sizeHistogram    *prometheus.HistogramVec
+}
+
+func NewSnapshotManager(repo Snapshotter, opts SnapshotOptions, latencyHistogram *prometheus.HistogramVec, sizeHistogram *prometheus.HistogramVec) *SnapshotManager {
+	if opts.Retain < 1 {
+		opts.Retain = 1
+	}
+	return &SnapshotManager{
+		repo:             repo,
+		opts:             opts,
+		latencyHistogram: latencyHistogram,
+		sizeHistogram:    sizeHistogram,
+	}
+}
+
+// Run takes a snapshot once per interval until ctx is canceled. Every run
+// starts its own trace, as it is not caused by a request.
+func (m *SnapshotManager) Run(ctx context.Context) {
+	if m.opts.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runCtx, span := otel.Tracer("app-tracer").Start(ctx, "PeriodicSnapshot", trace.WithNewRoot())
+			if _, err := m.Save(runCtx); err != nil {
+				slog.ErrorContext(runCtx, "failed to save snapshot", slog.Any("error", err))
+				span.SetStatus(codes.Error, "failed to save snapshot")
+			} else {
+				span.SetStatus(codes.Ok, "success")
+			}
+			span.End()
+		}
+	}
+}
+
+// Save writes a new snapshot file and removes the oldest ones beyond the
+// retention. The file is renamed into place once it is synced, so a crash
+// never leaves a partial snapshot behind.
+func (m *SnapshotManager) Save(ctx context.Context) (SnapshotInfo, error) {
+	start := time.Now()
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "SaveSnapshot")
+	defer span.End()
+
+	info, err := m.save(ctx)
+	m.observe("snapshot", start, info, err)
+	span.SetAttributes(
+		attribute.String("snapshot.name", info.Name),
+		attribute.Int("snapshot.items", info.Items),
+		attribute.Int64("snapshot.bytes", info.Bytes),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to save snapshot")
+		return SnapshotInfo{}, errs.NewInternal(fmt.Errorf("failed to save snapshot: %w", err))
+	}
+
+	slog.InfoContext(ctx, "saved snapshot", slog.String("name", info.Name), slog.Int("items", info.Items))
+	span.SetStatus(codes.Ok, "success")
+	return info, nil
+}
+
+func (m *SnapshotManager) save(ctx context.Context) (SnapshotInfo, error) {
+	if err := os.MkdirAll(m.opts.Dir, 0o755); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	var payload bytes.Buffer
+	items, err := m.repo.WriteSnapshot(ctx, &payload)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	name := fmt.Sprintf("snapshot-%020d%s", time.Now().UnixNano(), snapshotExt)
+	path := filepath.Join(m.opts.Dir, name)
+	tmp := path + ".tmp"
+	if err := writeSnapshotFile(tmp, payload.Bytes()); err != nil {
+		os.Remove(tmp)
+		return SnapshotInfo{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return SnapshotInfo{}, err
+	}
+	if dir, err := os.Open(m.opts.Dir); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+
+	names, err := m.list()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	for _, old := range names[min(m.opts.Retain, len(names)):] {
+		if err := os.Remove(filepath.Join(m.opts.Dir, old)); err != nil {
+			slog.WarnContext(ctx, "failed to remove old snapshot", slog.String("name", old), slog.Any("error", err))
+		}
+	}
+
+	return SnapshotInfo{
+		Name:  name,
+		Items: items,
+		Bytes: int64(snapshotHeaderSize + payload.Len() + snapshotTrailerSize),
+	}, nil
+}
+
+func writeSnapshotFile(path string, payload []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	header := make([]byte, snapshotHeaderSize)
+	copy(header, snapshotMagic)
+	binary.LittleEndian.PutUint32(header[4:], snapshotFormatVersion)
+	trailer := make([]byte, snapshotTrailerSize)
+	binary.LittleEndian.PutUint32(trailer, crc32.Checksum(payload, walCRCTable))
+	binary.LittleEndian.PutUint64(trailer[4:], uint64(len(payload)))
+
+	for _, b := range [][]byte{header, payload, trailer} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// RestoreLatest restores the newest snapshot that can be read, skipping
+// corrupt ones. It fails with NOT_FOUND if there is none.
+func (m *SnapshotManager) RestoreLatest(ctx context.Context) (SnapshotInfo, error) {
+	names, err := m.list()
+	if err != nil {
+		return SnapshotInfo{}, errs.NewInternal(fmt.Errorf("failed to list snapshots: %w", err))
+	}
+	for _, name := range names {
+		info, err := m.Restore(ctx, name)
+		if err == nil {
+			return info, nil
+		}
+		slog.ErrorContext(ctx, "skipping unreadable snapshot", slog.String("name", name), slog.Any("error", err))
+	}
+	return SnapshotInfo{}, errs.NewNotFound(fmt.Errorf("no readable snapshot in %s", m.opts.Dir))
+}
+
+// Restore replaces the state of the repository with the snapshot file name.
+func (m *SnapshotManager) Restore(ctx context.Context, name string) (SnapshotInfo, error) {
+	start := time.Now()
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "RestoreSnapshot")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("snapshot.name", name))
+
+	if name != filepath.Base(name) || !strings.HasSuffix(name, snapshotExt) {
+		span.SetStatus(codes.Error, "invalid snapshot name")
+		return SnapshotInfo{}, errs.NewInvalidInput(fmt.Errorf("invalid snapshot name %q", name))
+	}
+
+	info, err := m.restore(ctx, name)
+	m.observe("restore", start, info, err)
+	span.SetAttributes(attribute.Int("snapshot.items", info.Items), attribute.Int64("snapshot.bytes", info.Bytes))
+	if errors.Is(err, os.ErrNotExist) {
+		span.SetStatus(codes.Error, "snapshot not found")
+		return SnapshotInfo{}, errs.NewNotFound(fmt.Errorf("snapshot %s not found", name))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to restore snapshot")
+		return SnapshotInfo{}, errs.NewInternal(fmt.Errorf("failed to restore snapshot %s: %w", name, err))
+	}
+
+	slog.InfoContext(ctx, "restored snapshot", slog.String("name", name), slog.Int("items", info.Items))
+	span.SetStatus(codes.Ok, "success")
+	return info, nil
+}
+
+func (m *SnapshotManager) restore(ctx context.Context, name string) (SnapshotInfo, error) {
+	data, err := os.ReadFile(filepath.Join(m.opts.Dir, name))
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	info := SnapshotInfo{Name: name, Bytes: int64(len(data))}
+
+	if len(data) < snapshotHeaderSize+snapshotTrailerSize || string(data[:4]) != snapshotMagic {
+		return info, fmt.Errorf("not a snapshot file")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != snapshotFormatVersion {
+		return info, fmt.Errorf("unsupported snapshot format version %d", version)
+	}
+	payload := data[snapshotHeaderSize : len(data)-snapshotTrailerSize]
+	trailer := data[len(data)-snapshotTrailerSize:]
+	if binary.LittleEndian.Uint64(trailer[4:]) != uint64(len(payload)) {
+		return info, fmt.Errorf("snapshot is truncated")
+	}
+	if binary.LittleEndian.Uint32(trailer) != crc32.Checksum(payload, walCRCTable) {
+		return info, fmt.Errorf("snapshot checksum mismatch")
+	}
+
+	info.Items, err = m.repo.ReadSnapshot(ctx, bytes.NewReader(payload))
+	return info, err
+}
+
+// list returns the snapshot file names, newest first.
+func (m *SnapshotManager) list() ([]string, error) {
+	entries, err := os.ReadDir(m.opts.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), "snapshot-") && strings.HasSuffix(e.Name(), snapshotExt) {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+	slices.Reverse(names)
+	return names, nil
+}
+
+func (m *SnapshotManager) observe(operation string, start time.Time, info SnapshotInfo, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.latencyHistogram.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+	if err == nil {
+		m.sizeHistogram.WithLabelValues(operation).Observe(float64(info.Bytes))
+	}
+}
@@ -0,0 +1,170 @@
+package repository_test
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSnapshotManager(t *testing.T, repo repository.Repository, dir string, retain int) *repository.SnapshotManager {
+	t.Helper()
+	snapshotter, ok := repo.(repository.Snapshotter)
+	require.True(t, ok)
+	latencyHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"operation", "result"})
+	sizeHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "size"}, []string{"operation"})
+	return repository.NewSnapshotManager(snapshotter, repository.SnapshotOptions{Dir: dir, Retain: retain}, latencyHistogram, sizeHistogram)
+}
+
+func snapshotFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*.snap"))
+	require.NoError(t, err)
+	return names
+}
+
+func TestSnapshotManager(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		ctx := context.Background()
+		dir := t.TempDir()
+
+		repo := repository.NewInMemoryRepository(repository.ExpiryOptions{})
+		defer repo.Close()
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		repo.UpdateData(ctx, "1", model.StringValue("newValue1"), nil, nil, repository.AnyVersion)
+		repo.CreateData(ctx, "2", model.StringValue("value2"), nil, nil)
+		repo.DeleteData(ctx, "2", repository.AnyVersion, "tester")
+
+		info, err := newSnapshotManager(t, repo, dir, 3).Save(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, info.Items)
+		assert.Positive(t, info.Bytes)
+
+		restored := repository.NewInMemoryRepository(repository.ExpiryOptions{})
+		defer restored.Close()
+		restored.CreateData(ctx, "3", model.StringValue("value3"), nil, nil)
+
+		info, err = newSnapshotManager(t, restored, dir, 3).RestoreLatest(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, info.Items)
+
+		item, err := restored.GetData(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, "newValue1", item.Value.Text())
+		assert.Equal(t, int64(2), item.Version)
+
+		history, err := restored.GetHistory(ctx, "1")
+		assert.NoError(t, err)
+		assert.Len(t, history, 2)
+
+		page, err := restored.ListTrash(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Items, 1) {
+			assert.Equal(t, "tester", page.Items[0].DeletedBy)
+		}
+
+		_, err = restored.GetData(ctx, "3")
+		assert.Error(t, err)
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		ctx := context.Background()
+		dir := t.TempDir()
+
+		repo := repository.NewInMemoryRepository(repository.ExpiryOptions{})
+		defer repo.Close()
+		manager := newSnapshotManager(t, repo, dir, 2)
+
+		var last repository.SnapshotInfo
+		for i := 0; i < 4; i++ {
+			info, err := manager.Save(ctx)
+			require.NoError(t, err)
+			last = info
+		}
+
+		files := snapshotFiles(t, dir)
+		assert.Len(t, files, 2)
+		assert.Contains(t, files, filepath.Join(dir, last.Name))
+	})
+
+	t.Run("CorruptLatest", func(t *testing.T) {
+		ctx := context.Background()
+		dir := t.TempDir()
+
+		repo := repository.NewInMemoryRepository(repository.ExpiryOptions{})
+		defer repo.Close()
+		manager := newSnapshotManager(t, repo, dir, 3)
+
+		repo.CreateData(ctx, "1", model.StringValue("value1"), nil, nil)
+		older, err := manager.Save(ctx)
+		require.NoError(t, err)
+		repo.CreateData(ctx, "2", model.StringValue("value2"), nil, nil)
+		latest, err := manager.Save(ctx)
+		require.NoError(t, err)
+
+		path := filepath.Join(dir, latest.Name)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		data[len(data)/2] ^= 0xff
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+
+		_, err = manager.Restore(ctx, latest.Name)
+		assert.Error(t, err)
+
+		info, err := manager.RestoreLatest(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, older.Name, info.Name)
+
+		page, err := repo.ListAllData(ctx, repository.ListOptions{})
+		assert.NoError(t, err)
+		assert.Len(t, page.Items, 1)
+	})
+
+	t.Run("UnsupportedVersion", func(t *testing.T) {
+		ctx := context.Background()
+		dir := t.TempDir()
+
+		repo := repository.NewInMemoryRepository(repository.ExpiryOptions{})
+		defer repo.Close()
+		manager := newSnapshotManager(t, repo, dir, 3)
+
+		info, err := manager.Save(ctx)
+		require.NoError(t, err)
+
+		path := filepath.Join(dir, info.Name)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		binary.LittleEndian.PutUint32(data[4:], 99)
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+
+		_, err = manager.Restore(ctx, info.Name)
+		assert.ErrorContains(t, err, "unsupported snapshot format version 99")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		ctx := context.Background()
+
+		repo := repository.NewInMemoryRepository(repository.ExpiryOptions{})
+		defer repo.Close()
+		manager := newSnapshotManager(t, repo, filepath.Join(t.TempDir(), "missing"), 3)
+
+		_, err := manager.RestoreLatest(ctx)
+		assert.True(t, errs.IsNotFound(err))
+
+		_, err = manager.Restore(ctx, "snapshot-1.snap")
+		assert.True(t, errs.IsNotFound(err))
+
+		_, err = manager.Restore(ctx, "../wal.log")
+		assert.Error(t, err)
+		assert.False(t, errs.IsNotFound(err))
+	})
+}
@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	DialectSQLite   = "sqlite"
+	DialectPostgres = "postgres"
+)
+
+//go:embed migrations
+var migrationsFS embed.FS
+
+// OpenDB opens a connection pool for the given dialect. For SQLite the
+// directory of a file database is created if needed.
+func OpenDB(dialect string, dsn string) (*sql.DB, error) {
+	switch dialect {
+	case DialectSQLite:
+		if file := sqliteFile(dsn); file != "" {
+			if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+				return nil, fmt.Errorf("failed to create database directory: %w", err)
+			}
+		}
+		return sql.Open("sqlite", dsn)
+	case DialectPostgres:
+		return sql.Open("pgx", dsn)
+	default:
+		return nil, fmt.Errorf("unknown sql dialect %q", dialect)
+	}
+}
+
+func sqliteFile(dsn string) string {
+	file, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	if file == "" || file == ":memory:" {
+		return ""
+	}
+	return file
+}
+
+// sqlDB runs statements against a connection pool and records a client span
+// with the db.* semantic attributes for every one of them.
+type sqlDB struct {
+	db      *sql.DB
+	dialect string
+}
+
+// sqlQuerier is implemented by both *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (d *sqlDB) system() attribute.KeyValue {
+	if d.dialect == DialectPostgres {
+		return semconv.DBSystemPostgreSQL
+	}
+	return semconv.DBSystemSqlite
+}
+
+// rebind rewrites the ? placeholders used throughout this package into the
+// numbered placeholders Postgres expects.
+func (d *sqlDB) rebind(query string) string {
+	if d.dialect != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (d *sqlDB) startSpan(ctx context.Context, operation string, table string, query string) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, operation+" "+table, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		d.system(),
+		semconv.DBOperation(operation),
+		semconv.DBSQLTable(table),
+		semconv.DBStatement(query),
+	)
+	slog.DebugContext(ctx, "executing sql query", slog.String("statement", query))
+	return ctx, span
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "query failed")
+	} else {
+		span.SetStatus(codes.Ok, "success")
+	}
+	span.End()
+}
+
+func (d *sqlDB) exec(ctx context.Context, q sqlQuerier, operation string, table string, query string, args ...any) (sql.Result, error) {
+	query = d.rebind(query)
+	ctx, span := d.startSpan(ctx, operation, table, query)
+	result, err := q.ExecContext(ctx, query, args...)
+	if err == nil {
+		if n, rowsErr := result.RowsAffected(); rowsErr == nil {
+			span.SetAttributes(attribute.Int64("db.rows_affected", n))
+		}
+	}
+	endSpan(span, err)
+	return result, err
+}
+
+// inTx runs fn in a transaction that is committed if fn succeeds.
+func (d *sqlDB) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// errStopScan can be returned by the scan function of query to stop reading
+// rows without failing the query.
+var errStopScan = errors.New("stop scan")
+
+// query runs a statement and calls scan for every row before the span ends.
+func (d *sqlDB) query(ctx context.Context, q sqlQuerier, operation string, table string, query string, args []any, scan func(*sql.Rows) error) error {
+	query = d.rebind(query)
+	ctx, span := d.startSpan(ctx, operation, table, query)
+	err := func() error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		n := 0
+		for rows.Next() {
+			n++
+			if err := scan(rows); errors.Is(err, errStopScan) {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		span.SetAttributes(attribute.Int("db.rows_returned", n))
+		return rows.Err()
+	}()
+	endSpan(span, err)
+	return err
+}
+
+// queryRow is like query but expects a single row and returns sql.ErrNoRows
+// when there is none.
+func (d *sqlDB) queryRow(ctx context.Context, q sqlQuerier, operation string, table string, query string, args []any, dest ...any) error {
+	found := false
+	err := d.query(ctx, q, operation, table, query, args, func(rows *sql.Rows) error {
+		if found {
+			return nil
+		}
+		found = true
+		return rows.Scan(dest...)
+	})
+	if err == nil && !found {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// migrate applies the embedded migrations for the dialect that have not been
+// recorded in schema_migrations yet, each in its own transaction.
+func (d *sqlDB) migrate(ctx context.Context) error {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "MigrateSQLSchema")
+	defer span.End()
+
+	_, err := d.exec(ctx, d.db, "CREATE", "schema_migrations", `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    BIGINT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at BIGINT NOT NULL
+)`)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to create schema_migrations")
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied := make(map[int64]bool)
+	err = d.query(ctx, d.db, "SELECT", "schema_migrations", "SELECT version FROM schema_migrations", nil, func(rows *sql.Rows) error {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return err
+		}
+		applied[version] = true
+		return nil
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to read schema_migrations")
+		return fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	dir := path.Join("migrations", d.dialect)
+	entries, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to read migrations")
+		return fmt.Errorf("failed to read migrations: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	count := 0
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			span.SetStatus(codes.Error, "invalid migration name")
+			return fmt.Errorf("invalid migration name %q: %w", entry.Name(), err)
+		}
+		if applied[version] {
+			continue
+		}
+
+		stmt, err := fs.ReadFile(migrationsFS, path.Join(dir, entry.Name()))
+		if err != nil {
+			span.SetStatus(codes.Error, "failed to read migration")
+			return fmt.Errorf("failed to read migration %s: %w", name, err)
+		}
+		if err := d.applyMigration(ctx, version, name, string(stmt)); err != nil {
+			span.SetStatus(codes.Error, "failed to apply migration")
+			return fmt.Errorf("failed to apply migration %s: %w", name, err)
+		}
+		slog.InfoContext(ctx, "applied sql migration", slog.String("migration", name))
+		count++
+	}
+
+	span.SetAttributes(attribute.Int("db.migrations.applied", count))
+	span.SetStatus(codes.Ok, "success")
+	return nil
+}
+
+func (d *sqlDB) applyMigration(ctx context.Context, version int64, name string, stmt string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := d.exec(ctx, tx, "MIGRATE", name, stmt); err != nil {
+		return err
+	}
+	_, err = d.exec(ctx, tx, "INSERT", "schema_migrations",
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		version, name, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"simple_lgtm/internal/filter"
+)
+
+// sqlWhere is a WHERE condition translated from a filter expression.
+type sqlWhere struct {
+	clause string
+	args   []any
+	// exact is false when parts of the expression could not be translated
+	// and clause only narrows down the rows, which then still have to be
+	// matched against the whole expression.
+	exact bool
+}
+
+// pushdownFilter translates as much of expr as the dialect supports into SQL.
+// An empty clause matches every row.
+func (d *sqlDB) pushdownFilter(expr filter.Expr) sqlWhere {
+	switch e := expr.(type) {
+	case *filter.And:
+		left, right := d.pushdownFilter(e.Left), d.pushdownFilter(e.Right)
+		exact := left.exact && right.exact
+		switch {
+		case left.clause == "":
+			return sqlWhere{clause: right.clause, args: right.args, exact: exact}
+		case right.clause == "":
+			return sqlWhere{clause: left.clause, args: left.args, exact: exact}
+		}
+		return sqlWhere{
+			clause: "(" + left.clause + " AND " + right.clause + ")",
+			args:   append(left.args, right.args...),
+			exact:  exact,
+		}
+	case *filter.Or:
+		left, right := d.pushdownFilter(e.Left), d.pushdownFilter(e.Right)
+		if !left.exact || !right.exact {
+			return sqlWhere{}
+		}
+		return sqlWhere{
+			clause: "(" + left.clause + " OR " + right.clause + ")",
+			args:   append(left.args, right.args...),
+			exact:  true,
+		}
+	case *filter.Not:
+		inner := d.pushdownFilter(e.Expr)
+		if !inner.exact {
+			return sqlWhere{}
+		}
+		return sqlWhere{clause: "NOT " + inner.clause, args: inner.args, exact: true}
+	case *filter.Condition:
+		return d.pushdownCondition(e)
+	default:
+		return sqlWhere{}
+	}
+}
+
+func (d *sqlDB) pushdownCondition(c *filter.Condition) sqlWhere {
+	column := string(c.Field)
+	if c.Field == filter.FieldValue {
+		column = d.valueText()
+	}
+	switch c.Op {
+	case filter.OpEqual:
+		return sqlWhere{clause: column + " = ?", args: []any{c.Value}, exact: true}
+	case filter.OpNotEqual:
+		return sqlWhere{clause: column + " <> ?", args: []any{c.Value}, exact: true}
+	case filter.OpPrefix:
+		// LIKE is case-insensitive in SQLite and needs escaping, so compare
+		// the leading characters instead.
+		if d.dialect == DialectPostgres {
+			return sqlWhere{clause: "starts_with(" + column + ", ?)", args: []any{c.Value}, exact: true}
+		}
+		return sqlWhere{clause: "substr(" + column + ", 1, length(?)) = ?", args: []any{c.Value, c.Value}, exact: true}
+	case filter.OpContains:
+		if d.dialect == DialectPostgres {
+			return sqlWhere{clause: "strpos(" + column + ", ?) > 0", args: []any{c.Value}, exact: true}
+		}
+		return sqlWhere{clause: "instr(" + column + ", ?) > 0", args: []any{c.Value}, exact: true}
+	default:
+		// Regular expressions are matched in Go: SQLite has no built-in
+		// REGEXP and Postgres regular expressions are not RE2.
+		return sqlWhere{}
+	}
+}
+
+// valueText is the SQL expression of the text of the value column, which
+// conditions compare like model.Value.Text: the decoded string of a JSON
+// string, and the encoding of any other value.
+func (d *sqlDB) valueText() string {
+	if d.dialect == DialectPostgres {
+		return "(CASE WHEN left(value, 1) = '\"' THEN value::jsonb #>> '{}' ELSE value END)"
+	}
+	return "(CASE WHEN substr(value, 1, 1) = '\"' THEN json_extract(value, '$') ELSE value END)"
+}
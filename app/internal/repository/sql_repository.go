@@ -0,0 +1,463 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type sqlRepository struct {
+	sqlDB
+	sweeper *sweeper
+}
+
+const dataItemColumns = "tenant, id, value, labels, version, created_at, updated_at, deleted_at, deleted_by, expires_at"
+
+// liveCondition matches the rows that have not expired at the time passed as
+// its argument.
+const liveCondition = "(expires_at IS NULL OR expires_at > ?)"
+
+// sqlDataItem is a data_items row, with timestamps stored as Unix nanoseconds.
+// Value holds JSON and Labels a JSON object, or NULL without labels.
+// DeletedAt is NULL unless the item is in the trash, ExpiresAt unless it
+// expires.
+type sqlDataItem struct {
+	Tenant    string
+	ID        string
+	Value     string
+	Labels    sql.NullString
+	Version   int64
+	CreatedAt int64
+	UpdatedAt int64
+	DeletedAt sql.NullInt64
+	DeletedBy string
+	ExpiresAt sql.NullInt64
+}
+
+func (i *sqlDataItem) dest() []any {
+	return []any{&i.Tenant, &i.ID, &i.Value, &i.Labels, &i.Version, &i.CreatedAt, &i.UpdatedAt, &i.DeletedAt, &i.DeletedBy, &i.ExpiresAt}
+}
+
+func (i *sqlDataItem) item() model.DataItem {
+	item := model.DataItem{
+		Tenant:    i.Tenant,
+		ID:        i.ID,
+		Value:     model.Value(i.Value),
+		Labels:    decodeLabels(i.Labels),
+		Version:   i.Version,
+		CreatedAt: time.Unix(0, i.CreatedAt).UTC(),
+		UpdatedAt: time.Unix(0, i.UpdatedAt).UTC(),
+	}
+	if i.DeletedAt.Valid {
+		deletedAt := time.Unix(0, i.DeletedAt.Int64).UTC()
+		item.DeletedAt = &deletedAt
+		item.DeletedBy = i.DeletedBy
+	}
+	if i.ExpiresAt.Valid {
+		expiresAt := time.Unix(0, i.ExpiresAt.Int64).UTC()
+		item.ExpiresAt = &expiresAt
+	}
+	return item
+}
+
+// encodeLabels converts labels into a nullable column value.
+func encodeLabels(labels map[string]string) sql.NullString {
+	if len(labels) == 0 {
+		return sql.NullString{}
+	}
+	b, _ := json.Marshal(labels)
+	return sql.NullString{String: string(b), Valid: true}
+}
+
+func decodeLabels(labels sql.NullString) map[string]string {
+	if !labels.Valid {
+		return nil
+	}
+	var decoded map[string]string
+	json.Unmarshal([]byte(labels.String), &decoded)
+	return decoded
+}
+
+// nullUnixNano converts an optional time into a nullable column value.
+func nullUnixNano(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: true}
+}
+
+var orderColumns = map[Order]string{
+	OrderByID:        "id",
+	OrderByCreatedAt: "created_at",
+	OrderByUpdatedAt: "updated_at",
+}
+
+// NewSQLRepository migrates the schema of db to the latest version and
+// returns a Repository backed by it. The repository owns db and closes it.
+func NewSQLRepository(ctx context.Context, db *sql.DB, dialect string, expiry ExpiryOptions) (Repository, error) {
+	r := &sqlRepository{sqlDB: sqlDB{db: db, dialect: dialect}}
+	if err := r.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	r.sweeper = startSweeper(expiry, r.sweepExpired)
+	return r, nil
+}
+
+func (r *sqlRepository) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	var item model.DataItem
+	err := r.runTx(ctx, func(tx *sqlTx) (err error) {
+		item, err = tx.CreateData(ctx, id, value, labels, expiresAt)
+		return err
+	})
+	return item, err
+}
+
+func (r *sqlRepository) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	return r.getData(ctx, r.db, id)
+}
+
+func (r *sqlRepository) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	var item model.DataItem
+	err := r.runTx(ctx, func(tx *sqlTx) (err error) {
+		item, err = tx.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
+		return err
+	})
+	return item, err
+}
+
+func (r *sqlRepository) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	var item model.DataItem
+	err := r.runTx(ctx, func(tx *sqlTx) (err error) {
+		item, err = tx.DeleteData(ctx, id, expectedVersion, actor)
+		return err
+	})
+	return item, err
+}
+
+func (r *sqlRepository) ListAllData(ctx context.Context, opts ListOptions) (Page, error) {
+	return r.list(ctx, opts, false)
+}
+
+func (r *sqlRepository) ListTrash(ctx context.Context, opts ListOptions) (Page, error) {
+	return r.list(ctx, opts, true)
+}
+
+// list returns a page of the rows that are in the trash or not.
+func (r *sqlRepository) list(ctx context.Context, opts ListOptions, deleted bool) (Page, error) {
+	c, err := opts.normalize()
+	if err != nil {
+		return Page{}, err
+	}
+
+	column := orderColumns[opts.OrderBy]
+	direction, comparison := "ASC", ">"
+	if opts.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	conditions := []string{"tenant = ?", "deleted_at IS NULL", liveCondition}
+	if deleted {
+		conditions[1] = "deleted_at IS NOT NULL"
+	}
+	args := []any{tenant.FromContext(ctx), time.Now().UnixNano()}
+	if c != nil {
+		if opts.OrderBy == OrderByID {
+			conditions = append(conditions, "id "+comparison+" ?")
+			args = append(args, c.ID)
+		} else {
+			conditions = append(conditions, "("+column+", id) "+comparison+" (?, ?)")
+			args = append(args, c.Key, c.ID)
+		}
+	}
+	where := sqlWhere{exact: true}
+	if opts.Filter != nil {
+		where = r.pushdownFilter(opts.Filter)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("list.filter.exact", where.exact))
+		if where.clause != "" {
+			conditions = append(conditions, where.clause)
+			args = append(args, where.args...)
+		}
+	}
+
+	query := "SELECT " + dataItemColumns + " FROM data_items WHERE " + strings.Join(conditions, " AND ")
+	if opts.OrderBy == OrderByID {
+		query += " ORDER BY id " + direction
+	} else {
+		query += " ORDER BY " + column + " " + direction + ", id " + direction
+	}
+	// Without an exact translation of the filter the remaining rows are
+	// matched here, and rows are read until the page is full instead.
+	if where.exact {
+		query += " LIMIT ?"
+		args = append(args, opts.Limit+1)
+	}
+
+	dataList := make([]model.DataItem, 0)
+	err = r.query(ctx, r.db, "SELECT", "data_items", query, args, func(rows *sql.Rows) error {
+		var row sqlDataItem
+		if err := rows.Scan(row.dest()...); err != nil {
+			return err
+		}
+		item := row.item()
+		if !where.exact && !opts.Filter.Match(item) {
+			return nil
+		}
+		dataList = append(dataList, item)
+		if len(dataList) > opts.Limit {
+			return errStopScan
+		}
+		return nil
+	})
+	if err != nil {
+		return Page{}, errs.NewInternal(fmt.Errorf("failed to list data: %w", err))
+	}
+	return newPage(dataList, opts), nil
+}
+
+func (r *sqlRepository) RestoreData(ctx context.Context, id string) (model.DataItem, error) {
+	now := time.Now().UTC().UnixNano()
+	var row sqlDataItem
+	err := r.inTx(ctx, func(tx *sql.Tx) (err error) {
+		row, err = r.mutateRow(ctx, tx, "UPDATE", model.OperationRestore,
+			"UPDATE data_items SET version = version + 1, updated_at = ?, deleted_at = NULL, deleted_by = '' WHERE tenant = ? AND id = ? AND deleted_at IS NOT NULL AND "+liveCondition+" RETURNING "+dataItemColumns,
+			[]any{now, tenant.FromContext(ctx), id, now})
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.DataItem{}, errs.NewNotFound(fmt.Errorf("data with ID %s not found in trash", id), errs.D("id", id))
+	}
+	if err != nil {
+		return model.DataItem{}, errs.NewInternal(fmt.Errorf("failed to restore data with ID %s: %w", id, err))
+	}
+	return row.item(), nil
+}
+
+func (r *sqlRepository) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	n, err := r.removeWhere(ctx, "deleted_at IS NOT NULL AND deleted_at < ?", before.UnixNano())
+	if err != nil {
+		return 0, errs.NewInternal(fmt.Errorf("failed to purge deleted data: %w", err))
+	}
+	return n, nil
+}
+
+func (r *sqlRepository) sweepExpired(ctx context.Context, now time.Time) (int, error) {
+	n, err := r.removeWhere(ctx, "expires_at <= ?", now.UnixNano())
+	if err != nil {
+		return 0, errs.NewInternal(fmt.Errorf("failed to delete expired data: %w", err))
+	}
+	return n, nil
+}
+
+// revisionsDeleteBatch bounds the number of placeholders of one statement.
+const revisionsDeleteBatch = 500
+
+// removeWhere deletes the rows that match condition together with their
+// history and returns how many there were.
+func (r *sqlRepository) removeWhere(ctx context.Context, condition string, args ...any) (int, error) {
+	var keys []itemKey
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		err := r.query(ctx, tx, "DELETE", "data_items", "DELETE FROM data_items WHERE "+condition+" RETURNING tenant, id", args, func(rows *sql.Rows) error {
+			var key itemKey
+			if err := rows.Scan(&key.tenant, &key.id); err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for batch := range slices.Chunk(keys, revisionsDeleteBatch) {
+			placeholders := strings.TrimSuffix(strings.Repeat("(?, ?), ", len(batch)), ", ")
+			batchArgs := make([]any, 0, 2*len(batch))
+			for _, key := range batch {
+				batchArgs = append(batchArgs, key.tenant, key.id)
+			}
+			_, err := r.exec(ctx, tx, "DELETE", "data_item_revisions",
+				"DELETE FROM data_item_revisions WHERE (tenant, id) IN ("+placeholders+")", batchArgs...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// mutateRow runs a statement that returns the changed row and records the
+// revision it made in the same transaction. It returns sql.ErrNoRows when
+// the statement matched no row.
+func (r *sqlRepository) mutateRow(ctx context.Context, tx *sql.Tx, operation string, op model.Operation, query string, args []any) (sqlDataItem, error) {
+	var row sqlDataItem
+	if err := r.queryRow(ctx, tx, operation, "data_items", query, args, row.dest()...); err != nil {
+		return row, err
+	}
+	rev := model.NewRevision(row.item(), op, traceID(ctx))
+	_, err := r.exec(ctx, tx, "INSERT", "data_item_revisions",
+		"INSERT INTO data_item_revisions (tenant, id, version, operation, value, labels, expires_at, actor, recorded_at, trace_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		row.Tenant, rev.ID, rev.Version, string(rev.Operation), string(rev.Value), encodeLabels(rev.Labels), nullUnixNano(rev.ExpiresAt), rev.Actor, rev.Timestamp.UnixNano(), rev.TraceID)
+	return row, err
+}
+
+func (r *sqlRepository) GetHistory(ctx context.Context, id string) ([]model.Revision, error) {
+	revisions := make([]model.Revision, 0)
+	err := r.query(ctx, r.db, "SELECT", "data_item_revisions",
+		"SELECT id, version, operation, value, labels, expires_at, actor, recorded_at, trace_id FROM data_item_revisions WHERE tenant = ? AND id = ? ORDER BY version",
+		[]any{tenant.FromContext(ctx), id}, func(rows *sql.Rows) error {
+			var (
+				rev        model.Revision
+				value      string
+				labels     sql.NullString
+				expiresAt  sql.NullInt64
+				recordedAt int64
+			)
+			if err := rows.Scan(&rev.ID, &rev.Version, &rev.Operation, &value, &labels, &expiresAt, &rev.Actor, &recordedAt, &rev.TraceID); err != nil {
+				return err
+			}
+			rev.Value, rev.Labels = model.Value(value), decodeLabels(labels)
+			if expiresAt.Valid {
+				t := time.Unix(0, expiresAt.Int64).UTC()
+				rev.ExpiresAt = &t
+			}
+			rev.Timestamp = time.Unix(0, recordedAt).UTC()
+			revisions = append(revisions, rev)
+			return nil
+		})
+	if err != nil {
+		return nil, errs.NewInternal(fmt.Errorf("failed to select history of data with ID %s: %w", id, err))
+	}
+	if len(revisions) == 0 {
+		return nil, errs.NewNotFound(fmt.Errorf("data with ID %s not found", id), errs.D("id", id))
+	}
+	return revisions, nil
+}
+
+// CreateTenant inserts the tenant and counts the tenants in a transaction,
+// which is rolled back if there are too many. The insert keeps other writers
+// out of SQLite until the transaction ends, and on PostgreSQL the table is
+// locked against other creates and deletes, so that concurrent creates
+// cannot all pass the limit.
+func (r *sqlRepository) CreateTenant(ctx context.Context, name string, limit int) (model.Tenant, error) {
+	t := model.Tenant{Name: name, CreatedAt: time.Now().UTC()}
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		if r.dialect == DialectPostgres {
+			if _, err := r.exec(ctx, tx, "LOCK", "tenants", "LOCK TABLE tenants IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+				return err
+			}
+		}
+		result, err := r.exec(ctx, tx, "INSERT", "tenants",
+			"INSERT INTO tenants (name, created_at) VALUES (?, ?) ON CONFLICT (name) DO NOTHING",
+			t.Name, t.CreatedAt.UnixNano())
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return errs.NewConflict(fmt.Errorf("tenant %s already exists", name), errs.D("tenant", name))
+		}
+		// The registered tenants including the new one are as many as there
+		// were before it counting the default one.
+		var count int
+		if err := r.queryRow(ctx, tx, "SELECT", "tenants", "SELECT COUNT(*) FROM tenants", nil, &count); err != nil {
+			return err
+		}
+		return tenantLimitError(count, limit)
+	})
+	if errs.IsConflict(err) || errs.IsForbidden(err) {
+		return model.Tenant{}, err
+	}
+	if err != nil {
+		return model.Tenant{}, errs.NewInternal(fmt.Errorf("failed to insert tenant %s: %w", name, err))
+	}
+	return t, nil
+}
+
+func (r *sqlRepository) GetTenant(ctx context.Context, name string) (model.Tenant, error) {
+	var createdAt int64
+	err := r.queryRow(ctx, r.db, "SELECT", "tenants", "SELECT created_at FROM tenants WHERE name = ?", []any{name}, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Tenant{}, errs.NewNotFound(fmt.Errorf("tenant %s not found", name), errs.D("tenant", name))
+	}
+	if err != nil {
+		return model.Tenant{}, errs.NewInternal(fmt.Errorf("failed to select tenant %s: %w", name, err))
+	}
+	return model.Tenant{Name: name, CreatedAt: time.Unix(0, createdAt).UTC()}, nil
+}
+
+func (r *sqlRepository) ListTenants(ctx context.Context) ([]model.Tenant, error) {
+	tenants := make([]model.Tenant, 0)
+	err := r.query(ctx, r.db, "SELECT", "tenants", "SELECT name, created_at FROM tenants ORDER BY name", nil, func(rows *sql.Rows) error {
+		var (
+			t         model.Tenant
+			createdAt int64
+		)
+		if err := rows.Scan(&t.Name, &createdAt); err != nil {
+			return err
+		}
+		t.CreatedAt = time.Unix(0, createdAt).UTC()
+		tenants = append(tenants, t)
+		return nil
+	})
+	if err != nil {
+		return nil, errs.NewInternal(fmt.Errorf("failed to list tenants: %w", err))
+	}
+	return tenants, nil
+}
+
+func (r *sqlRepository) DeleteTenant(ctx context.Context, name string) (int, error) {
+	var removed int64
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		result, err := r.exec(ctx, tx, "DELETE", "tenants", "DELETE FROM tenants WHERE name = ?", name)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return sql.ErrNoRows
+		}
+		if result, err = r.exec(ctx, tx, "DELETE", "data_items", "DELETE FROM data_items WHERE tenant = ?", name); err != nil {
+			return err
+		}
+		if removed, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		_, err = r.exec(ctx, tx, "DELETE", "data_item_revisions", "DELETE FROM data_item_revisions WHERE tenant = ?", name)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errs.NewNotFound(fmt.Errorf("tenant %s not found", name), errs.D("tenant", name))
+	}
+	if err != nil {
+		return 0, errs.NewInternal(fmt.Errorf("failed to delete tenant %s: %w", name, err))
+	}
+	return int(removed), nil
+}
+
+func (r *sqlRepository) Ping(ctx context.Context) error {
+	if err := r.db.PingContext(ctx); err != nil {
+		return errs.NewUnavailable(fmt.Errorf("failed to reach database: %w", err))
+	}
+	return nil
+}
+
+func (r *sqlRepository) Close() error {
+	r.sweeper.close()
+	return r.db.Close()
+}
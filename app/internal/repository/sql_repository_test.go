@@ -0,0 +1,69 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"simple_lgtm/internal/repository"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newSQLRepository returns a repository on a fresh SQLite database, or on
+// the Postgres database in TEST_POSTGRES_DSN when that is set.
+func newSQLRepository(t *testing.T, expiry repository.ExpiryOptions) repository.Repository {
+	t.Helper()
+	return newSQLRepositoryWithConns(t, expiry, 0)
+}
+
+// newSQLRepositoryWithConns is newSQLRepository with a pool of at most
+// maxOpenConns connections, or an unlimited one for 0.
+func newSQLRepositoryWithConns(t *testing.T, expiry repository.ExpiryOptions, maxOpenConns int) repository.Repository {
+	t.Helper()
+	ctx := context.Background()
+
+	dialect, dsn := repository.DialectSQLite, "file:"+filepath.Join(t.TempDir(), "app.db")+"?_pragma=busy_timeout(5000)"
+	if pg := os.Getenv("TEST_POSTGRES_DSN"); pg != "" {
+		dialect, dsn = repository.DialectPostgres, pg
+	}
+
+	db, err := repository.OpenDB(dialect, dsn)
+	require.NoError(t, err)
+	db.SetMaxOpenConns(maxOpenConns)
+	if dialect == repository.DialectPostgres {
+		_, err = db.ExecContext(ctx, "DROP TABLE IF EXISTS data_items, data_item_revisions, tenants, schema_migrations")
+		require.NoError(t, err)
+	}
+
+	repo, err := repository.NewSQLRepository(ctx, db, dialect, expiry)
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestSQLRepository(t *testing.T) {
+	testRepository(t, newSQLRepository)
+
+	// SQLite is configured with a single connection by default, which must
+	// not deadlock operations that run inside a transaction.
+	t.Run("SingleConnection", func(t *testing.T) {
+		testRepository(t, func(t *testing.T, expiry repository.ExpiryOptions) repository.Repository {
+			return newSQLRepositoryWithConns(t, expiry, 1)
+		})
+	})
+
+	t.Run("MigrationsAreIdempotent", func(t *testing.T) {
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "app.db")
+
+		for i := 0; i < 2; i++ {
+			db, err := repository.OpenDB(repository.DialectSQLite, "file:"+path)
+			require.NoError(t, err)
+			repo, err := repository.NewSQLRepository(ctx, db, repository.DialectSQLite, repository.ExpiryOptions{})
+			require.NoError(t, err)
+			repo.Close()
+		}
+	})
+}
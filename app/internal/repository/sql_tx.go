@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+)
+
+// sqlTx runs the operations of a Tx in one database transaction. Every write
+// of sqlRepository goes through it as a transaction of its own.
+type sqlTx struct {
+	r  *sqlRepository
+	tx *sql.Tx
+}
+
+func (r *sqlRepository) InTx(ctx context.Context, fn func(tx Tx) error) error {
+	return r.runTx(ctx, func(tx *sqlTx) error { return fn(tx) })
+}
+
+// runTx is like inTx but reports failures to begin or commit the transaction
+// as internal errors, while the errors of fn are returned as they are.
+func (r *sqlRepository) runTx(ctx context.Context, fn func(tx *sqlTx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errs.NewInternal(fmt.Errorf("failed to begin transaction: %w", err))
+	}
+	defer tx.Rollback()
+
+	if err := fn(&sqlTx{r: r, tx: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errs.NewInternal(fmt.Errorf("failed to commit transaction: %w", err))
+	}
+	return nil
+}
+
+func (tx *sqlTx) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	if err := tx.checkTenant(ctx); err != nil {
+		return model.DataItem{}, err
+	}
+
+	// A trashed or expired row is replaced but keeps counting versions, so
+	// that ETags of the old item never match the new one.
+	now := time.Now().UTC()
+	row, err := tx.r.mutateRow(ctx, tx.tx, "INSERT", model.OperationCreate,
+		`INSERT INTO data_items (tenant, id, value, labels, version, created_at, updated_at, expires_at) VALUES (?, ?, ?, ?, 1, ?, ?, ?)
+ON CONFLICT (tenant, id) DO UPDATE SET value = excluded.value, labels = excluded.labels, version = data_items.version + 1,
+    created_at = excluded.created_at, updated_at = excluded.updated_at, deleted_at = NULL, deleted_by = '',
+    expires_at = excluded.expires_at
+WHERE data_items.deleted_at IS NOT NULL OR data_items.expires_at <= ?
+RETURNING `+dataItemColumns,
+		[]any{tenant.FromContext(ctx), id, string(value), encodeLabels(labels), now.UnixNano(), now.UnixNano(), nullUnixNano(expiresAt), now.UnixNano()})
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.DataItem{}, errs.NewConflict(fmt.Errorf("data with ID %s already exists", id), errs.D("id", id))
+	}
+	if err != nil {
+		return model.DataItem{}, errs.NewInternal(fmt.Errorf("failed to insert data with ID %s: %w", id, err))
+	}
+	return row.item(), nil
+}
+
+// checkTenant reports the tenant of ctx as not found unless it exists. On
+// PostgreSQL the row of the tenant stays locked until the transaction ends,
+// so that DeleteTenant waits for the write and removes its item as well.
+func (tx *sqlTx) checkTenant(ctx context.Context) error {
+	name := tenant.FromContext(ctx)
+	if name == tenant.Default {
+		return nil
+	}
+	query := "SELECT 1 FROM tenants WHERE name = ?"
+	if tx.r.dialect == DialectPostgres {
+		query += " FOR SHARE"
+	}
+	var exists int
+	err := tx.r.queryRow(ctx, tx.tx, "SELECT", "tenants", query, []any{name}, &exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errs.NewNotFound(fmt.Errorf("tenant %s not found", name), errs.D("tenant", name))
+	}
+	if err != nil {
+		return errs.NewInternal(fmt.Errorf("failed to select tenant %s: %w", name, err))
+	}
+	return nil
+}
+
+func (tx *sqlTx) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	return tx.r.getData(ctx, tx.tx, id)
+}
+
+func (r *sqlRepository) getData(ctx context.Context, q sqlQuerier, id string) (model.DataItem, error) {
+	var row sqlDataItem
+	err := r.queryRow(ctx, q, "SELECT", "data_items",
+		"SELECT "+dataItemColumns+" FROM data_items WHERE tenant = ? AND id = ? AND deleted_at IS NULL AND "+liveCondition,
+		[]any{tenant.FromContext(ctx), id, time.Now().UnixNano()}, row.dest()...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.DataItem{}, errs.NewNotFound(fmt.Errorf("data with ID %s not found", id), errs.D("id", id))
+	}
+	if err != nil {
+		return model.DataItem{}, errs.NewInternal(fmt.Errorf("failed to select data with ID %s: %w", id, err))
+	}
+	return row.item(), nil
+}
+
+func (tx *sqlTx) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	now := time.Now().UTC()
+	query := "UPDATE data_items SET value = ?, labels = ?, version = version + 1, updated_at = ?, expires_at = ? WHERE tenant = ? AND id = ? AND deleted_at IS NULL AND " + liveCondition
+	args := []any{string(newValue), encodeLabels(labels), now.UnixNano(), nullUnixNano(expiresAt), tenant.FromContext(ctx), id, now.UnixNano()}
+	if expectedVersion != AnyVersion {
+		query += " AND version = ?"
+		args = append(args, expectedVersion)
+	}
+
+	row, err := tx.r.mutateRow(ctx, tx.tx, "UPDATE", model.OperationUpdate, query+" RETURNING "+dataItemColumns, args)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.versionMismatch(ctx, id, expectedVersion)
+		if errs.IsConflict(err) {
+			return model.DataItem{}, err
+		}
+		return model.DataItem{}, errs.NewNotFound(fmt.Errorf("data with ID %s not found", id), errs.D("id", id))
+	}
+	if err != nil {
+		return model.DataItem{}, errs.NewInternal(fmt.Errorf("failed to update data with ID %s: %w", id, err))
+	}
+	return row.item(), nil
+}
+
+func (tx *sqlTx) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	now := time.Now().UTC().UnixNano()
+	query := "UPDATE data_items SET version = version + 1, updated_at = ?, deleted_at = ?, deleted_by = ? WHERE tenant = ? AND id = ? AND deleted_at IS NULL AND " + liveCondition
+	args := []any{now, now, actor, tenant.FromContext(ctx), id, now}
+	if expectedVersion != AnyVersion {
+		query += " AND version = ?"
+		args = append(args, expectedVersion)
+	}
+
+	row, err := tx.r.mutateRow(ctx, tx.tx, "UPDATE", model.OperationDelete, query+" RETURNING "+dataItemColumns, args)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.versionMismatch(ctx, id, expectedVersion)
+		if errs.IsConflict(err) {
+			return model.DataItem{}, err
+		}
+		return model.DataItem{}, errs.NewNotFound(fmt.Errorf("data with ID %s not found", id), errs.D("id", id))
+	}
+	if err != nil {
+		return model.DataItem{}, errs.NewInternal(fmt.Errorf("failed to delete data with ID %s: %w", id, err))
+	}
+	return row.item(), nil
+}
+
+// versionMismatch tells apart why a conditional write matched no row: it
+// returns a conflict error if id exists at another version than expected.
+func (tx *sqlTx) versionMismatch(ctx context.Context, id string, expectedVersion int64) error {
+	if expectedVersion == AnyVersion {
+		return nil
+	}
+	var version int64
+	err := tx.r.queryRow(ctx, tx.tx, "SELECT", "data_items",
+		"SELECT version FROM data_items WHERE tenant = ? AND id = ? AND deleted_at IS NULL AND "+liveCondition,
+		[]any{tenant.FromContext(ctx), id, time.Now().UnixNano()}, &version)
+	if err != nil {
+		return nil
+	}
+	return errs.NewConflict(fmt.Errorf("data with ID %s is at version %d, expected %d", id, version, expectedVersion), errs.D("id", id), errs.D("version", version), errs.D("expected_version", expectedVersion))
+}
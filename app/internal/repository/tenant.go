@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+)
+
+// itemKey identifies an item across tenants.
+type itemKey struct {
+	tenant string
+	id     string
+}
+
+// scopedKey returns the key of id in the tenant of ctx.
+func scopedKey(ctx context.Context, id string) itemKey {
+	return itemKey{tenant: tenant.FromContext(ctx), id: id}
+}
+
+// key returns the key the item of e is stored under.
+func (e *entry) key() itemKey {
+	return itemKey{tenant: e.item.Tenant, id: e.item.ID}
+}
+
+// withTenant assigns an item read from a log or snapshot that was written
+// before there were tenants to the default tenant.
+func withTenant(item model.DataItem) model.DataItem {
+	if item.Tenant == "" {
+		item.Tenant = tenant.Default
+	}
+	return item
+}
+
+// CreateTenant holds txMu exclusively, like DeleteTenant, so that concurrent
+// creates cannot all pass the limit.
+func (r *inMemoryRepository) CreateTenant(ctx context.Context, name string, limit int) (model.Tenant, error) {
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	if _, ok := r.tenants.Load(name); ok {
+		return model.Tenant{}, errs.NewConflict(fmt.Errorf("tenant %s already exists", name), errs.D("tenant", name))
+	}
+	if err := r.checkTenantLimit(limit); err != nil {
+		return model.Tenant{}, err
+	}
+	t := model.Tenant{Name: name, CreatedAt: time.Now().UTC()}
+	r.tenants.Store(name, t)
+	return t, nil
+}
+
+// checkTenantLimit reports whether limit tenants, counting the default one,
+// are registered. Callers keep other tenant writes out meanwhile.
+func (r *inMemoryRepository) checkTenantLimit(limit int) error {
+	count := 1
+	r.tenants.Range(func(key, value any) bool {
+		count++
+		return true
+	})
+	return tenantLimitError(count, limit)
+}
+
+// tenantLimitError reports count tenants reaching limit.
+func tenantLimitError(count int, limit int) error {
+	if count >= limit {
+		return errs.NewForbidden(fmt.Errorf("the limit of %d tenants is reached", limit), errs.D("max_tenants", limit))
+	}
+	return nil
+}
+
+func (r *inMemoryRepository) GetTenant(ctx context.Context, name string) (model.Tenant, error) {
+	value, ok := r.tenants.Load(name)
+	if !ok {
+		return model.Tenant{}, errs.NewNotFound(fmt.Errorf("tenant %s not found", name), errs.D("tenant", name))
+	}
+	return value.(model.Tenant), nil
+}
+
+func (r *inMemoryRepository) ListTenants(ctx context.Context) ([]model.Tenant, error) {
+	tenants := make([]model.Tenant, 0)
+	r.tenants.Range(func(key, value any) bool {
+		tenants = append(tenants, value.(model.Tenant))
+		return true
+	})
+	slices.SortFunc(tenants, func(a, b model.Tenant) int { return strings.Compare(a.Name, b.Name) })
+	return tenants, nil
+}
+
+// checkTenant reports the tenant of ctx as not found unless it exists. Writes
+// that create items call it while they hold txMu, which DeleteTenant holds
+// exclusively, so that no item lands in a tenant once it is deleted.
+func (r *inMemoryRepository) checkTenant(ctx context.Context) error {
+	name := tenant.FromContext(ctx)
+	if name == tenant.Default {
+		return nil
+	}
+	if _, ok := r.tenants.Load(name); !ok {
+		return errs.NewNotFound(fmt.Errorf("tenant %s not found", name), errs.D("tenant", name))
+	}
+	return nil
+}
+
+// DeleteTenant waits for running writes and keeps new ones out until the
+// tenant is unregistered and its items are gone.
+func (r *inMemoryRepository) DeleteTenant(ctx context.Context, name string) (int, error) {
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	if _, ok := r.tenants.LoadAndDelete(name); !ok {
+		return 0, errs.NewNotFound(fmt.Errorf("tenant %s not found", name), errs.D("tenant", name))
+	}
+	return r.removeTenantItems(name), nil
+}
+
+// removeTenantItems removes every item of a tenant and returns how many
+// there were.
+func (r *inMemoryRepository) removeTenantItems(name string) int {
+	removed := 0
+	r.data.Range(func(key, value any) bool {
+		if key.(itemKey).tenant == name {
+			r.data.Delete(key)
+			removed++
+		}
+		return true
+	})
+	return removed
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/pkg/errs"
+)
+
+// memTx buffers the entries written by a transaction until it commits.
+type memTx struct {
+	r      *inMemoryRepository
+	writes map[itemKey]*entry
+	// log holds every entry written, in order, including those that were
+	// replaced later in the transaction.
+	log []*entry
+}
+
+func (r *inMemoryRepository) InTx(ctx context.Context, fn func(tx Tx) error) error {
+	return r.inTx(func(tx *memTx) error { return fn(tx) })
+}
+
+// inTx runs fn in a transaction that is committed if fn succeeds. Writes of
+// other goroutines wait until it is done. Reads are not blocked, so they may
+// see some items of a committing transaction before others.
+func (r *inMemoryRepository) inTx(fn func(tx *memTx) error) error {
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	tx := &memTx{r: r, writes: make(map[itemKey]*entry)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for _, e := range tx.log {
+		r.data.Store(e.key(), e)
+	}
+	return nil
+}
+
+// load returns the entry of key as the transaction sees it.
+func (tx *memTx) load(key itemKey) *entry {
+	if e, ok := tx.writes[key]; ok {
+		return e
+	}
+	e, _ := tx.r.load(key)
+	return e
+}
+
+func (tx *memTx) store(e *entry) {
+	tx.writes[e.key()] = e
+	tx.log = append(tx.log, e)
+}
+
+func (tx *memTx) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	if err := tx.r.checkTenant(ctx); err != nil {
+		return model.DataItem{}, err
+	}
+	next, err := tx.load(scopedKey(ctx, id)).create(ctx, id, value, labels, expiresAt)
+	if err != nil {
+		return model.DataItem{}, err
+	}
+	tx.store(next)
+	return next.item, nil
+}
+
+func (tx *memTx) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	current := tx.load(scopedKey(ctx, id))
+	if !current.live(time.Now()) {
+		return model.DataItem{}, errs.NewNotFound(fmt.Errorf("data with ID %s not found", id), errs.D("id", id))
+	}
+	return current.item, nil
+}
+
+func (tx *memTx) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	next, err := tx.load(scopedKey(ctx, id)).update(ctx, id, newValue, labels, expiresAt, expectedVersion)
+	if err != nil {
+		return model.DataItem{}, err
+	}
+	tx.store(next)
+	return next.item, nil
+}
+
+func (tx *memTx) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	next, err := tx.load(scopedKey(ctx, id)).delete(ctx, id, expectedVersion, actor)
+	if err != nil {
+		return model.DataItem{}, err
+	}
+	tx.store(next)
+	return next.item, nil
+}
@@ -0,0 +1,432 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"simple_lgtm/internal/model"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// walHeaderSize is the length prefix plus the CRC-32C of every record.
+const walHeaderSize = 8
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+type walOp string
+
+const (
+	walOpPut    walOp = "put"
+	walOpDelete walOp = "delete"
+	// walOpRevision adds Revision to the history of an item that has already
+	// been put. Compaction writes the history this way.
+	walOpRevision walOp = "revision"
+	// walOpBatch applies Records atomically, as the whole record is either
+	// replayed or truncated.
+	walOpBatch walOp = "batch"
+	// walOpCreateTenant registers Tenant, walOpDeleteTenant removes it
+	// together with its items.
+	walOpCreateTenant walOp = "create_tenant"
+	walOpDeleteTenant walOp = "delete_tenant"
+)
+
+// walRecord is one mutation. A put record carries the revision it made, if
+// any.
+type walRecord struct {
+	Op       walOp           `json:"op"`
+	Item     model.DataItem  `json:"item"`
+	Revision *model.Revision `json:"revision,omitempty"`
+	Records  []walRecord     `json:"records,omitempty"`
+	Tenant   *model.Tenant   `json:"tenant,omitempty"`
+}
+
+// walBatch is a group of records that become durable with a single fsync.
+type walBatch struct {
+	done    chan struct{}
+	err     error
+	records int
+	bytes   int
+	links   []trace.Link
+}
+
+func newWALBatch() *walBatch {
+	return &walBatch{done: make(chan struct{})}
+}
+
+// wait blocks until the batch has been fsynced and returns the sync error, if
+// any. It does not return early once ctx is done, as the records are applied
+// whether or not the caller still waits for them.
+func (b *walBatch) wait(ctx context.Context) error {
+	_, span := otel.Tracer("app-tracer").Start(ctx, "WALAwaitSync")
+	defer span.End()
+
+	<-b.done
+	span.SetAttributes(attribute.Int("wal.batch.records", b.records))
+	if b.err != nil {
+		span.RecordError(b.err)
+		span.SetStatus(codes.Error, "wal sync failed")
+		return b.err
+	}
+	span.SetStatus(codes.Ok, "success")
+	return nil
+}
+
+// wal is an append-only log of repository mutations. Each record is framed as
+// a 4-byte little-endian payload length, a 4-byte CRC-32C of the payload and
+// the JSON encoded walRecord. Records are buffered and fsynced in groups,
+// either when batchSize records are pending or every syncInterval.
+type wal struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	buf       *bufio.Writer
+	batch     *walBatch
+	batchSize int
+	records   int
+	err       error
+	// failed holds err once it is set, so that it is read without waiting
+	// for a running fsync.
+	failed atomic.Pointer[error]
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openWAL replays the log at path through apply, truncates a torn or corrupt
+// tail left by a crash and opens the log for appending.
+func openWAL(ctx context.Context, path string, batchSize int, syncInterval time.Duration, apply func(walRecord)) (*wal, error) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if syncInterval <= 0 {
+		syncInterval = 10 * time.Millisecond
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create wal directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal: %w", err)
+	}
+
+	records, err := replayWAL(ctx, file, apply)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &wal{
+		path:      path,
+		file:      file,
+		buf:       bufio.NewWriter(file),
+		batch:     newWALBatch(),
+		batchSize: batchSize,
+		records:   records,
+		kick:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go w.run(syncInterval)
+	return w, nil
+}
+
+func replayWAL(ctx context.Context, file *os.File, apply func(walRecord)) (int, error) {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "WALReplay")
+	defer span.End()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, walHeaderSize)
+	var offset int64
+	records := 0
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if !errors.Is(err, io.EOF) {
+				slog.WarnContext(ctx, "truncating torn wal header", slog.Int64("offset", offset))
+			}
+			break
+		}
+		size := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			slog.WarnContext(ctx, "truncating torn wal record", slog.Int64("offset", offset))
+			break
+		}
+		if crc32.Checksum(payload, walCRCTable) != sum {
+			slog.WarnContext(ctx, "truncating corrupt wal record", slog.Int64("offset", offset))
+			break
+		}
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			slog.WarnContext(ctx, "truncating undecodable wal record", slog.Int64("offset", offset), slog.Any("error", err))
+			break
+		}
+		apply(rec)
+		offset += walHeaderSize + int64(size)
+		records++
+	}
+
+	if err := file.Truncate(offset); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to truncate wal")
+		return 0, fmt.Errorf("failed to truncate wal: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to seek wal")
+		return 0, fmt.Errorf("failed to seek wal: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("wal.records", records), attribute.Int64("wal.bytes", offset))
+	span.SetStatus(codes.Ok, "success")
+	slog.InfoContext(ctx, "wal replayed", slog.Int("records", records), slog.Int64("bytes", offset))
+	return records, nil
+}
+
+func encodeWALRecord(rec walRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(payload, walCRCTable))
+	copy(frame[walHeaderSize:], payload)
+	return frame, nil
+}
+
+// append buffers rec and returns the batch it belongs to. The record is only
+// durable once the batch has been waited on successfully.
+func (w *wal) append(ctx context.Context, rec walRecord) (*walBatch, error) {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "WALAppend")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("wal.op", string(rec.Op)), attribute.String("data.id", rec.Item.ID))
+
+	frame, err := encodeWALRecord(rec)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to encode wal record")
+		return nil, fmt.Errorf("failed to encode wal record: %w", err)
+	}
+
+	w.mu.Lock()
+	if w.err != nil {
+		err := w.err
+		w.mu.Unlock()
+		span.SetStatus(codes.Error, "wal is failed")
+		return nil, fmt.Errorf("wal is failed: %w", err)
+	}
+	if _, err := w.buf.Write(frame); err != nil {
+		w.fail(err)
+		w.mu.Unlock()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to write wal record")
+		return nil, fmt.Errorf("failed to write wal record: %w", err)
+	}
+	batch := w.batch
+	batch.records++
+	batch.bytes += len(frame)
+	batch.links = append(batch.links, trace.LinkFromContext(ctx))
+	w.records++
+	full := batch.records >= w.batchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+
+	span.SetAttributes(attribute.Int("wal.record.bytes", len(frame)))
+	span.SetStatus(codes.Ok, "success")
+	return batch, nil
+}
+
+func (w *wal) run(syncInterval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			w.sync()
+			return
+		case <-ticker.C:
+		case <-w.kick:
+		}
+		w.sync()
+	}
+}
+
+func (w *wal) sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.syncLocked()
+}
+
+// syncLocked flushes and fsyncs the pending batch. w.mu must be held.
+func (w *wal) syncLocked() {
+	batch := w.batch
+	if batch.records == 0 {
+		return
+	}
+	w.batch = newWALBatch()
+
+	_, span := otel.Tracer("app-tracer").Start(context.Background(), "WALFsync", trace.WithLinks(batch.links...))
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("wal.batch.records", batch.records), attribute.Int("wal.batch.bytes", batch.bytes))
+
+	err := w.err
+	if err == nil {
+		err = w.buf.Flush()
+	}
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		// A failed fsync leaves the file in an unknown state, so every later
+		// append fails too instead of pretending to be durable.
+		w.fail(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to sync wal")
+	} else {
+		span.SetStatus(codes.Ok, "success")
+	}
+
+	batch.err = err
+	close(batch.done)
+}
+
+// compact atomically replaces the log with the given records, which must
+// describe the complete current state. Callers must make sure no mutation
+// happens concurrently.
+func (w *wal) compact(ctx context.Context, records []walRecord) error {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "WALCompact")
+	defer span.End()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	before := w.records
+	w.syncLocked()
+	if w.err != nil {
+		span.SetStatus(codes.Error, "wal is failed")
+		return fmt.Errorf("wal is failed: %w", w.err)
+	}
+
+	tmpPath := w.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create compacted wal")
+		return fmt.Errorf("failed to create compacted wal: %w", err)
+	}
+	buf := bufio.NewWriter(tmp)
+	for _, rec := range records {
+		frame, err := encodeWALRecord(rec)
+		if err == nil {
+			_, err = buf.Write(frame)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to write compacted wal")
+			return fmt.Errorf("failed to write compacted wal: %w", err)
+		}
+	}
+	if err := buf.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to sync compacted wal")
+		return fmt.Errorf("failed to sync compacted wal: %w", err)
+	}
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to replace wal")
+		return fmt.Errorf("failed to replace wal: %w", err)
+	}
+	if dir, err := os.Open(filepath.Dir(w.path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	if _, err := tmp.Seek(0, io.SeekEnd); err != nil {
+		tmp.Close()
+		w.fail(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to seek compacted wal")
+		return fmt.Errorf("failed to seek compacted wal: %w", err)
+	}
+
+	w.file.Close()
+	w.file = tmp
+	w.buf = bufio.NewWriter(tmp)
+	w.records = len(records)
+
+	span.SetAttributes(attribute.Int("wal.records.before", before), attribute.Int("wal.records.after", len(records)))
+	span.SetStatus(codes.Ok, "success")
+	slog.InfoContext(ctx, "wal compacted", slog.Int("records_before", before), slog.Int("records_after", len(records)))
+	return nil
+}
+
+// fail records err as the failure of w. w.mu must be held.
+func (w *wal) fail(err error) {
+	w.err = err
+	w.failed.Store(&err)
+}
+
+// failure returns the error that failed w, if any.
+func (w *wal) failure() error {
+	if err := w.failed.Load(); err != nil {
+		return fmt.Errorf("wal is failed: %w", *err)
+	}
+	return nil
+}
+
+// size returns the number of records in the log since it was last compacted.
+func (w *wal) size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.records
+}
+
+func (w *wal) close() error {
+	close(w.stop)
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return w.err
+}
@@ -0,0 +1,105 @@
+package schema
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// schemaURL is the location every schema is compiled at. References are
+// resolved against it, so "#/$defs/..." points into the schema itself.
+const schemaURL = "urn:simple-lgtm:schema"
+
+// printer formats the messages of violations.
+var printer = message.NewPrinter(language.English)
+
+// Violation is a part of a value that does not conform to a schema. Path is
+// the JSON pointer of the part, which is empty for the whole value.
+type Violation struct {
+	Schema  string `json:"schema"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// noLoader refuses to load schemas referenced by URL, so that a schema can
+// neither read local files nor make requests.
+type noLoader struct{}
+
+func (noLoader) Load(url string) (any, error) {
+	return nil, fmt.Errorf("referenced schema %s cannot be loaded, only references within the schema are supported", url)
+}
+
+// compile checks raw, a schema in JSON, against the metaschema of its draft,
+// JSON Schema 2020-12 unless $schema names another, and compiles it. Formats
+// are asserted rather than only annotated.
+func compile(raw []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	c := jsonschema.NewCompiler()
+	c.UseLoader(noLoader{})
+	c.AssertFormat()
+	if err := c.AddResource(schemaURL, doc); err != nil {
+		return nil, err
+	}
+	return c.Compile(schemaURL)
+}
+
+// violations returns the violations that err, an error of validating against
+// the schema id, consists of, ordered by path.
+func violations(id string, err error) []Violation {
+	var invalid *jsonschema.ValidationError
+	if !errors.As(err, &invalid) {
+		return []Violation{{Schema: id, Message: err.Error()}}
+	}
+	var out []Violation
+	collect(invalid, id, &out)
+	slices.SortStableFunc(out, func(a, b Violation) int { return cmp.Compare(a.Path, b.Path) })
+	return out
+}
+
+// collect appends the violations of the leaves of e to out. Inner errors,
+// such as of allOf or $ref, only group their causes.
+func collect(e *jsonschema.ValidationError, id string, out *[]Violation) {
+	if additional, ok := e.ErrorKind.(*kind.AdditionalProperties); ok {
+		// Report every property that is not allowed as a field of its own.
+		for _, name := range additional.Properties {
+			path := append(slices.Clone(e.InstanceLocation), name)
+			*out = append(*out, Violation{Schema: id, Path: pointer(path), Message: "additional property not allowed"})
+		}
+		return
+	}
+	if len(e.Causes) == 0 {
+		*out = append(*out, Violation{Schema: id, Path: pointer(e.InstanceLocation), Message: e.ErrorKind.LocalizedString(printer)})
+		return
+	}
+	switch e.ErrorKind.(type) {
+	case *kind.AnyOf, *kind.OneOf:
+		// Each cause is the failure of an alternative, which on its own says
+		// little about what the value should be.
+		*out = append(*out, Violation{Schema: id, Path: pointer(e.InstanceLocation), Message: e.ErrorKind.LocalizedString(printer)})
+		return
+	}
+	for _, cause := range e.Causes {
+		collect(cause, id, out)
+	}
+}
+
+// pointer returns the JSON pointer of the tokens of a location.
+func pointer(tokens []string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return b.String()
+}
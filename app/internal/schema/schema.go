@@ -0,0 +1,236 @@
+// Package schema validates the values of items against the JSON Schemas
+// registered for their tenant and id prefix.
+//
+// Schemas are JSON Schema 2020-12, or an earlier draft named by $schema, and
+// support every keyword of it, formats included. References ($ref) resolve
+// within the schema, such as to its $defs, but never to other documents.
+// Schemas are kept in memory and lost on restart unless they are loaded from
+// a file.
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// ValidationError lists every violation of a value. It is wrapped in an
+// invalid input error.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		path := v.Path
+		if path == "" {
+			path = "/"
+		}
+		messages[i] = path + ": " + v.Message
+	}
+	return "value does not conform to its schema: " + strings.Join(messages, "; ")
+}
+
+// FieldErrors returns the violations as errors of the fields of the value.
+func (e *ValidationError) FieldErrors() []errs.FieldError {
+	fields := make([]errs.FieldError, len(e.Violations))
+	for i, v := range e.Violations {
+		fields[i] = errs.FieldError{Field: v.Path, Message: v.Message, Source: v.Schema}
+	}
+	return fields
+}
+
+// Registry stores the schemas and validates values against them.
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string]compiled
+}
+
+type compiled struct {
+	schema model.Schema
+	root   *jsonschema.Schema
+}
+
+func NewRegistry() *Registry {
+	return &Registry{schemas: make(map[string]compiled)}
+}
+
+func compileSchema(s model.Schema) (*jsonschema.Schema, error) {
+	if s.ID == "" {
+		return nil, errs.NewInvalidInput(fmt.Errorf("ID is required"))
+	}
+	if s.Tenant != "" {
+		if err := tenant.Validate(s.Tenant); err != nil {
+			return nil, errs.NewInvalidInput(err)
+		}
+	}
+	if len(s.Schema) == 0 {
+		return nil, errs.NewInvalidInput(fmt.Errorf("schema is required"))
+	}
+	root, err := compile(s.Schema)
+	if err != nil {
+		return nil, errs.NewInvalidInput(fmt.Errorf("invalid schema: %w", err))
+	}
+	return root, nil
+}
+
+// CreateSchema registers s under its ID.
+func (r *Registry) CreateSchema(ctx context.Context, s model.Schema) (model.Schema, error) {
+	root, err := compileSchema(s)
+	if err != nil {
+		return model.Schema{}, err
+	}
+	s.CreatedAt = time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.schemas[s.ID]; ok {
+		return model.Schema{}, errs.NewConflict(fmt.Errorf("schema %s already exists", s.ID))
+	}
+	r.schemas[s.ID] = compiled{schema: s, root: root}
+	return s, nil
+}
+
+func (r *Registry) GetSchema(ctx context.Context, id string) (model.Schema, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.schemas[id]
+	if !ok {
+		return model.Schema{}, errs.NewNotFound(fmt.Errorf("schema %s not found", id))
+	}
+	return c.schema, nil
+}
+
+// ListSchemas returns the schemas ordered by ID.
+func (r *Registry) ListSchemas(ctx context.Context) ([]model.Schema, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	schemas := make([]model.Schema, 0, len(r.schemas))
+	for _, c := range r.schemas {
+		schemas = append(schemas, c.schema)
+	}
+	slices.SortFunc(schemas, func(a, b model.Schema) int { return strings.Compare(a.ID, b.ID) })
+	return schemas, nil
+}
+
+// UpdateSchema replaces the tenant, prefix and schema of the schema id. Items
+// that are already stored are not validated again.
+func (r *Registry) UpdateSchema(ctx context.Context, id string, s model.Schema) (model.Schema, error) {
+	s.ID = id
+	root, err := compileSchema(s)
+	if err != nil {
+		return model.Schema{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.schemas[id]
+	if !ok {
+		return model.Schema{}, errs.NewNotFound(fmt.Errorf("schema %s not found", id))
+	}
+	s.CreatedAt = current.schema.CreatedAt
+	r.schemas[id] = compiled{schema: s, root: root}
+	return s, nil
+}
+
+func (r *Registry) DeleteSchema(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.schemas[id]; !ok {
+		return errs.NewNotFound(fmt.Errorf("schema %s not found", id))
+	}
+	delete(r.schemas, id)
+	return nil
+}
+
+// LoadFile registers the schemas of a file holding a JSON array of them.
+func (r *Registry) LoadFile(ctx context.Context, path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema file: %w", err)
+	}
+	var schemas []model.Schema
+	if err := json.Unmarshal(b, &schemas); err != nil {
+		return 0, fmt.Errorf("failed to decode schema file %s: %w", path, err)
+	}
+	for _, s := range schemas {
+		if _, err := r.CreateSchema(ctx, s); err != nil {
+			return 0, fmt.Errorf("failed to register schema %s from %s: %w", s.ID, path, err)
+		}
+	}
+	return len(schemas), nil
+}
+
+// matching returns the schemas of the item id in the tenant of ctx, ordered
+// by ID.
+func (r *Registry) matching(ctx context.Context, id string) []compiled {
+	name := tenant.FromContext(ctx)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []compiled
+	for _, c := range r.schemas {
+		if (c.schema.Tenant == "" || c.schema.Tenant == name) && strings.HasPrefix(id, c.schema.Prefix) {
+			matches = append(matches, c)
+		}
+	}
+	slices.SortFunc(matches, func(a, b compiled) int { return strings.Compare(a.schema.ID, b.schema.ID) })
+	return matches
+}
+
+// Validate checks value, the new value of the item id in the tenant of ctx,
+// against every schema that applies to it. It returns an invalid input error
+// wrapping a *ValidationError if value does not conform.
+func (r *Registry) Validate(ctx context.Context, id string, value model.Value) error {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "ValidateSchema")
+	defer span.End()
+
+	matches := r.matching(ctx, id)
+	span.SetAttributes(attribute.String("data.id", id), attribute.Int("schema.count", len(matches)))
+	if len(matches) == 0 {
+		span.SetStatus(codes.Ok, "no schema")
+		return nil
+	}
+
+	instance, err := jsonschema.UnmarshalJSON(strings.NewReader(string(value)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid value")
+		return errs.NewInvalidInput(fmt.Errorf("value is not valid JSON: %w", err))
+	}
+
+	var found []Violation
+	for _, c := range matches {
+		if err := c.root.Validate(instance); err != nil {
+			found = append(found, violations(c.schema.ID, err)...)
+		}
+	}
+	span.SetAttributes(attribute.Int("schema.violations", len(found)))
+	if len(found) > 0 {
+		span.SetStatus(codes.Error, "value does not conform to its schema")
+		return errs.NewInvalidInput(&ValidationError{Violations: found})
+	}
+	span.SetStatus(codes.Ok, "success")
+	return nil
+}
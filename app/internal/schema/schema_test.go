@@ -0,0 +1,212 @@
+package schema_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/schema"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["total", "items"],
+	"additionalProperties": false,
+	"properties": {
+		"total": {"type": "number", "minimum": 0},
+		"status": {"enum": ["open", "paid"]},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"items": {
+				"type": "object",
+				"properties": {
+					"sku": {"type": "string", "pattern": "^[A-Z]{3}-[0-9]+$"},
+					"qty": {"type": "integer", "exclusiveMinimum": 0}
+				}
+			}
+		}
+	}
+}`
+
+// violations returns the violations of err, which must be a validation error.
+func violations(t *testing.T, err error) []schema.Violation {
+	t.Helper()
+	var invalid *schema.ValidationError
+	require.True(t, errors.As(err, &invalid), "%v", err)
+	assert.True(t, errs.IsInvalidInput(err))
+	return invalid.Violations
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	registry := schema.NewRegistry()
+	_, err := registry.CreateSchema(ctx, model.Schema{ID: "orders", Prefix: "order-", Schema: json.RawMessage(orderSchema)})
+	require.NoError(t, err)
+
+	t.Run("Conforming", func(t *testing.T) {
+		assert.NoError(t, registry.Validate(ctx, "order-1", model.Value(`{"total":3.5,"status":"paid","items":[{"sku":"ABC-1","qty":2}]}`)))
+	})
+
+	t.Run("EveryViolation", func(t *testing.T) {
+		err := registry.Validate(ctx, "order-1", model.Value(`{"total":-1,"status":"lost","items":[{"sku":"abc","qty":1.5}],"a/b":1}`))
+		assert.Equal(t, []schema.Violation{
+			{Schema: "orders", Path: "/a~1b", Message: "additional property not allowed"},
+			{Schema: "orders", Path: "/items/0/qty", Message: "got number, want integer"},
+			{Schema: "orders", Path: "/items/0/sku", Message: "'abc' does not match pattern '^[A-Z]{3}-[0-9]+$'"},
+			{Schema: "orders", Path: "/status", Message: "value must be one of 'open', 'paid'"},
+			{Schema: "orders", Path: "/total", Message: "minimum: got -1, want 0"},
+		}, violations(t, err))
+	})
+
+	t.Run("Root", func(t *testing.T) {
+		err := registry.Validate(ctx, "order-1", model.StringValue("an order"))
+		assert.Equal(t, []schema.Violation{
+			{Schema: "orders", Path: "", Message: "got string, want object"},
+		}, violations(t, err))
+
+		err = registry.Validate(ctx, "order-1", model.Value(`{"total":1}`))
+		assert.Equal(t, []schema.Violation{
+			{Schema: "orders", Path: "", Message: "missing property 'items'"},
+		}, violations(t, err))
+		assert.Equal(t, []errs.FieldError{
+			{Field: "", Message: "missing property 'items'", Source: "orders"},
+		}, errs.NewProblem(err).Errors)
+	})
+
+	t.Run("OtherPrefix", func(t *testing.T) {
+		assert.NoError(t, registry.Validate(ctx, "invoice-1", model.StringValue("anything")))
+	})
+}
+
+func TestKeywords(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		schema  string
+		valid   []string
+		invalid []string
+	}{
+		{`{"type":["string","null"],"minLength":2,"maxLength":3}`, []string{`"ab"`, `"äöü"`, `null`}, []string{`"a"`, `"abcd"`, `1`}},
+		{`{"const":{"a":[1,2]}}`, []string{`{"a":[1,2.0]}`}, []string{`{"a":[2,1]}`}},
+		{`{"type":"number","maximum":10,"exclusiveMaximum":10,"multipleOf":0.5}`, []string{`9.5`, `0`}, []string{`10`, `9.2`}},
+		{`{"type":"array","maxItems":2,"uniqueItems":true}`, []string{`[]`, `[1,"1"]`}, []string{`[1,1]`, `[1,2,3]`}},
+		{`{"type":"object","minProperties":1,"maxProperties":1,"additionalProperties":{"type":"boolean"}}`, []string{`{"a":true}`}, []string{`{}`, `{"a":1}`}},
+		{`{"anyOf":[{"type":"string"},{"type":"integer"}]}`, []string{`"a"`, `1`}, []string{`1.5`}},
+		{`{"oneOf":[{"type":"number"},{"type":"integer"}]}`, []string{`1.5`}, []string{`1`}},
+		{`{"allOf":[{"minimum":1},{"maximum":2}],"not":{"const":2}}`, []string{`1`}, []string{`0`, `2`, `3`}},
+		{`false`, nil, []string{`null`}},
+		{`{"$defs":{"pos":{"type":"integer","minimum":1}},"type":"array","items":{"$ref":"#/$defs/pos"}}`, []string{`[1,2]`}, []string{`[0]`}},
+		{`{"type":"object","patternProperties":{"^x-":{"type":"string"}},"additionalProperties":false}`, []string{`{"x-a":"b"}`}, []string{`{"x-a":1}`, `{"y":"b"}`}},
+		{`{"if":{"required":["kind"],"properties":{"kind":{"const":"card"}}},"then":{"required":["last4"]},"else":{"required":["iban"]}}`, []string{`{"kind":"card","last4":"1234"}`, `{"iban":"DE00"}`}, []string{`{"kind":"card"}`, `{}`}},
+		{`{"dependentRequired":{"street":["city"]}}`, []string{`{"city":"Oslo"}`, `{"street":"a","city":"b"}`}, []string{`{"street":"a"}`}},
+		{`{"type":"string","format":"email"}`, []string{`"a@example.com"`}, []string{`"not an email"`}},
+		{`{"type":"string","format":"date-time"}`, []string{`"2026-10-17T09:00:00Z"`}, []string{`"yesterday"`}},
+	} {
+		registry := schema.NewRegistry()
+		_, err := registry.CreateSchema(ctx, model.Schema{ID: "test", Schema: json.RawMessage(tc.schema)})
+		require.NoError(t, err, tc.schema)
+
+		for _, v := range tc.valid {
+			assert.NoError(t, registry.Validate(ctx, "1", model.Value(v)), "%s against %s", v, tc.schema)
+		}
+		for _, v := range tc.invalid {
+			assert.Error(t, registry.Validate(ctx, "1", model.Value(v)), "%s against %s", v, tc.schema)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	registry := schema.NewRegistry()
+
+	for _, s := range []string{
+		`{"type":"text"}`,
+		`{"minLength":-1}`,
+		`{"pattern":"("}`,
+		`{"$ref":"#/definitions/a"}`,
+		`{"$ref":"https://example.com/schema.json"}`,
+		`{"$ref":"file:///etc/passwd"}`,
+		`{"properties":{"a":1}}`,
+		`[]`,
+	} {
+		_, err := registry.CreateSchema(ctx, model.Schema{ID: "bad", Schema: json.RawMessage(s)})
+		assert.Error(t, err, s)
+	}
+	_, err := registry.CreateSchema(ctx, model.Schema{Schema: json.RawMessage(`true`)})
+	assert.Error(t, err)
+
+	_, err = registry.CreateSchema(ctx, model.Schema{ID: "acme", Tenant: "acme", Schema: json.RawMessage(`{"type":"integer"}`)})
+	require.NoError(t, err)
+	_, err = registry.CreateSchema(ctx, model.Schema{ID: "acme", Schema: json.RawMessage(`true`)})
+	assert.True(t, errs.IsConflict(err))
+
+	// Schemas of a tenant only apply in it.
+	acme := tenant.WithTenant(ctx, "acme")
+	assert.Error(t, registry.Validate(acme, "1", model.StringValue("a")))
+	assert.NoError(t, registry.Validate(ctx, "1", model.StringValue("a")))
+
+	updated, err := registry.UpdateSchema(ctx, "acme", model.Schema{Tenant: "acme", Schema: json.RawMessage(`{"type":"string"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", updated.ID)
+	assert.NoError(t, registry.Validate(acme, "1", model.StringValue("a")))
+
+	_, err = registry.UpdateSchema(ctx, "missing", model.Schema{Schema: json.RawMessage(`true`)})
+	assert.True(t, errs.IsNotFound(err))
+
+	schemas, _ := registry.ListSchemas(ctx)
+	assert.Len(t, schemas, 1)
+	assert.NoError(t, registry.DeleteSchema(ctx, "acme"))
+	assert.True(t, errs.IsNotFound(registry.DeleteSchema(ctx, "acme")))
+	assert.NoError(t, registry.Validate(acme, "1", model.Value(`1`)))
+}
+
+func TestLoadFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"id": "a", "prefix": "a-", "schema": {"type": "string"}},
+		{"id": "b", "prefix": "b-", "schema": {"type": "number"}}
+	]`), 0o644))
+
+	registry := schema.NewRegistry()
+	n, err := registry.LoadFile(ctx, path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Error(t, registry.Validate(ctx, "b-1", model.StringValue("x")))
+
+	_, err = schema.NewRegistry().LoadFile(ctx, filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestValidateSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ctx := context.Background()
+	registry := schema.NewRegistry()
+	registry.CreateSchema(ctx, model.Schema{ID: "a", Schema: json.RawMessage(`{"type":"string"}`)})
+	registry.Validate(ctx, "1", model.Value(`1`))
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "ValidateSchema", spans[0].Name())
+		assert.Contains(t, spans[0].Attributes(), attribute.Int("schema.violations", 1))
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/pkg/errs"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type BatchOp string
+
+const (
+	BatchOpCreate BatchOp = "create"
+	BatchOpGet    BatchOp = "get"
+	BatchOpUpdate BatchOp = "update"
+	BatchOpDelete BatchOp = "delete"
+)
+
+// BatchOperation is one operation of a batch. ExpectedVersion applies to
+// updates and deletes, Actor to deletes.
+type BatchOperation struct {
+	Op              BatchOp
+	ID              string
+	Value           model.Value
+	Labels          map[string]string
+	ExpiresAt       *time.Time
+	ExpectedVersion int64
+	Actor           string
+}
+
+// BatchResult is the outcome of one operation. Item is set when an operation
+// other than a delete succeeds.
+type BatchResult struct {
+	Item *model.DataItem
+	Err  error
+	// notification tells about a write once it is committed.
+	notification *model.Notification
+}
+
+// ErrBatchAborted is the error of the operations of an atomic batch that were
+// rolled back or never run because another operation failed.
+var ErrBatchAborted = errors.New("batch aborted")
+
+// Batcher runs several operations in one request.
+type Batcher struct {
+	service       *Service
+	notifier      Notifier
+	maxSize       int
+	sizeHistogram *prometheus.HistogramVec
+}
+
+// NewBatcher returns a Batcher of the items of svc. notifier is told about
+// the creates, updates and deletes of a batch once they are committed, like
+// through NewNotifyingService.
+func NewBatcher(svc *Service, notifier Notifier, maxSize int, sizeHistogram *prometheus.HistogramVec) *Batcher {
+	return &Batcher{
+		service:       svc,
+		notifier:      notifier,
+		maxSize:       maxSize,
+		sizeHistogram: sizeHistogram,
+	}
+}
+
+// Execute runs ops in order and returns a result for each of them. An atomic
+// batch is applied all or nothing and stops at the first failing operation,
+// whose error is returned. Otherwise every operation is applied on its own
+// and the error is only set if the batch could not be run at all.
+func (b *Batcher) Execute(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "BatchService")
+	defer span.End()
+
+	mode := "best_effort"
+	if atomic {
+		mode = "atomic"
+	}
+	span.SetAttributes(attribute.Int("batch.size", len(ops)), attribute.String("batch.mode", mode))
+
+	if len(ops) == 0 || len(ops) > b.maxSize {
+		span.SetStatus(codes.Error, "invalid batch size")
+		return nil, errs.NewInvalidInput(fmt.Errorf("a batch must have between 1 and %d operations", b.maxSize))
+	}
+
+	results, err := b.execute(ctx, ops, atomic)
+	for _, r := range results {
+		if r.Err == nil && r.notification != nil {
+			b.notifier.Notify(ctx, *r.notification)
+		}
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	b.sizeHistogram.WithLabelValues(mode, result).Observe(float64(len(ops)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "batch failed")
+		return results, err
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	return results, nil
+}
+
+func (b *Batcher) execute(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ops))
+	if !atomic {
+		for i, op := range ops {
+			results[i] = b.apply(ctx, b.service.repo, i, op)
+		}
+		return results, nil
+	}
+
+	failed := -1
+	err := b.service.repo.InTx(ctx, func(tx repository.Tx) error {
+		for i, op := range ops {
+			results[i] = b.apply(ctx, tx, i, op)
+			if results[i].Err != nil {
+				failed = i
+				return results[i].Err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return results, nil
+	}
+
+	aborted := fmt.Errorf("%w: the transaction failed", ErrBatchAborted)
+	if failed >= 0 {
+		aborted = fmt.Errorf("%w: operation %d failed", ErrBatchAborted, failed)
+		err = fmt.Errorf("operation %d failed: %w", failed, err)
+	}
+	for i := range results {
+		if i != failed {
+			results[i] = BatchResult{Err: aborted}
+		}
+	}
+	return results, err
+}
+
+// apply runs one operation against repo in a span of its own.
+func (b *Batcher) apply(ctx context.Context, repo repository.Tx, index int, op BatchOperation) BatchResult {
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "BatchOperation")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.Int("batch.index", index),
+		attribute.String("batch.op", string(op.Op)),
+		attribute.String("service.id", op.ID),
+	)
+
+	var (
+		item model.DataItem
+		err  error
+	)
+	switch op.Op {
+	case BatchOpCreate:
+		item, err = repo.CreateData(ctx, op.ID, op.Value, op.Labels, op.ExpiresAt)
+	case BatchOpGet:
+		item, err = repo.GetData(ctx, op.ID)
+	case BatchOpUpdate:
+		item, err = repo.UpdateData(ctx, op.ID, op.Value, op.Labels, op.ExpiresAt, op.ExpectedVersion)
+	case BatchOpDelete:
+		item, err = repo.DeleteData(ctx, op.ID, op.ExpectedVersion, op.Actor)
+	default:
+		err = errs.NewInvalidInput(fmt.Errorf("unknown batch operation %q", op.Op))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "batch operation failed")
+		return BatchResult{Err: err}
+	}
+
+	span.SetStatus(codes.Ok, "success")
+	switch op.Op {
+	case BatchOpGet:
+		return BatchResult{Item: &item}
+	case BatchOpDelete:
+		n := newNotification(ctx, model.OperationDelete, item, op.Actor)
+		return BatchResult{notification: &n}
+	default:
+		n := newNotification(ctx, model.Operation(op.Op), item, "")
+		return BatchResult{Item: &item, notification: &n}
+	}
+}
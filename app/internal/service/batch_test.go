@@ -0,0 +1,132 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"simple_lgtm/internal/blob"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/internal/service"
+	"simple_lgtm/internal/webhook"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBatcher returns a Batcher over an in-memory repository whose writes are
+// delivered to a webhook, and the notifications the webhook receives.
+func newBatcher(t *testing.T) (*service.Batcher, <-chan model.Notification) {
+	t.Helper()
+	received := make(chan model.Notification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n model.Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err == nil {
+			received <- n
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	d := webhook.NewDispatcher(webhook.Options{
+		Workers:          1,
+		QueueSize:        10,
+		MaxAttempts:      1,
+		Timeout:          time.Second,
+		LatencyHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"result"}),
+		AttemptCounter:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "attempts"}, []string{"result"}),
+		FailureCounter:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "failures"}, []string{"reason"}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	_, err := d.CreateWebhook(ctx, model.Webhook{URL: server.URL})
+	require.NoError(t, err)
+
+	feed := repository.NewFeed(10, prometheus.NewGauge(prometheus.GaugeOpts{Name: "subscribers"}), prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"}))
+	repo := repository.NewPublishingRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), feed)
+	blobs, err := blob.NewStore(t.TempDir())
+	require.NoError(t, err)
+	svc := service.NewService(repo, feed, blobs, 0)
+	sizeHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "size"}, []string{"mode", "result"})
+	return service.NewBatcher(svc, d, 10, sizeHistogram), received
+}
+
+func TestBatcherNotifies(t *testing.T) {
+	t.Run("Atomic", func(t *testing.T) {
+		b, received := newBatcher(t)
+		ops := []service.BatchOperation{
+			{Op: service.BatchOpCreate, ID: "1", Value: model.Value(`1`)},
+			{Op: service.BatchOpGet, ID: "1"},
+			{Op: service.BatchOpDelete, ID: "1", Actor: "alice"},
+		}
+		_, err := b.Execute(context.Background(), ops, true)
+		require.NoError(t, err)
+
+		var n model.Notification
+		select {
+		case n = <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no delivery of the create")
+		}
+		assert.Equal(t, model.OperationCreate, n.Operation)
+		assert.Equal(t, "1", n.DataID)
+		require.NotNil(t, n.Data)
+		assert.Equal(t, model.Value(`1`), n.Data.Value)
+
+		select {
+		case n = <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no delivery of the delete")
+		}
+		assert.Equal(t, model.OperationDelete, n.Operation)
+		assert.Equal(t, "alice", n.Actor)
+		assert.Nil(t, n.Data)
+	})
+
+	t.Run("AbortedBatchDoesNotNotify", func(t *testing.T) {
+		b, received := newBatcher(t)
+		ops := []service.BatchOperation{
+			{Op: service.BatchOpCreate, ID: "1", Value: model.Value(`1`)},
+			{Op: service.BatchOpUpdate, ID: "missing", Value: model.Value(`2`)},
+		}
+		_, err := b.Execute(context.Background(), ops, true)
+		require.Error(t, err)
+
+		select {
+		case n := <-received:
+			t.Fatalf("unexpected delivery of %s %s", n.Operation, n.DataID)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("BestEffortNotifiesSuccesses", func(t *testing.T) {
+		b, received := newBatcher(t)
+		ops := []service.BatchOperation{
+			{Op: service.BatchOpUpdate, ID: "missing", Value: model.Value(`2`)},
+			{Op: service.BatchOpCreate, ID: "2", Value: model.Value(`2`)},
+		}
+		results, err := b.Execute(context.Background(), ops, false)
+		require.NoError(t, err)
+		assert.Error(t, results[0].Err)
+
+		select {
+		case n := <-received:
+			assert.Equal(t, model.OperationCreate, n.Operation)
+			assert.Equal(t, "2", n.DataID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no delivery of the create")
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/instrument"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentedService traces every operation of another DataService as
+// "<operation>Service" and records its latency and errors.
+type instrumentedService struct {
+	svc DataService
+	in  *instrument.Instrumentation
+}
+
+// NewInstrumentedService wraps svc. latencyHistogram is labeled by operation
+// and result, errorCounter by operation and error code.
+func NewInstrumentedService(svc DataService, latencyHistogram *prometheus.HistogramVec, errorCounter *prometheus.CounterVec) DataService {
+	return &instrumentedService{
+		svc: svc,
+		in:  instrument.New("Service", latencyHistogram, errorCounter).WithContext(tenantAttributes),
+	}
+}
+
+func (s *instrumentedService) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	attrs := append([]attribute.KeyValue{
+		attribute.String("service.id", id),
+		attribute.String("service.value", string(value)),
+	}, expiryAttributes(expiresAt)...)
+	return instrument.Call(ctx, s.in, "CreateData", attrs, func(ctx context.Context) (model.DataItem, error) {
+		return s.svc.CreateData(ctx, id, value, labels, expiresAt)
+	})
+}
+
+func (s *instrumentedService) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	return instrument.Call(ctx, s.in, "GetData", []attribute.KeyValue{attribute.String("service.id", id)}, func(ctx context.Context) (model.DataItem, error) {
+		return s.svc.GetData(ctx, id)
+	})
+}
+
+func (s *instrumentedService) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	attrs := append([]attribute.KeyValue{
+		attribute.String("service.id", id),
+		attribute.String("service.newValue", string(newValue)),
+		attribute.Int64("service.expectedVersion", expectedVersion),
+	}, expiryAttributes(expiresAt)...)
+	return instrument.Call(ctx, s.in, "UpdateData", attrs, func(ctx context.Context) (model.DataItem, error) {
+		return s.svc.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
+	})
+}
+
+func (s *instrumentedService) PatchData(ctx context.Context, id string, patch repository.PatchFunc, expectedVersion int64) (model.DataItem, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("service.id", id),
+		attribute.Int64("service.expectedVersion", expectedVersion),
+	}
+	return instrument.Call(ctx, s.in, "PatchData", attrs, func(ctx context.Context) (model.DataItem, error) {
+		return s.svc.PatchData(ctx, id, patch, expectedVersion)
+	})
+}
+
+func (s *instrumentedService) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("service.id", id),
+		attribute.Int64("service.expectedVersion", expectedVersion),
+		attribute.String("service.actor", actor),
+	}
+	return instrument.Call(ctx, s.in, "DeleteData", attrs, func(ctx context.Context) (model.DataItem, error) {
+		return s.svc.DeleteData(ctx, id, expectedVersion, actor)
+	})
+}
+
+func (s *instrumentedService) ListAllData(ctx context.Context, opts repository.ListOptions) (repository.Page, error) {
+	return instrument.Call(ctx, s.in, "ListAllData", listAttributes(opts), func(ctx context.Context) (repository.Page, error) {
+		return s.svc.ListAllData(ctx, opts)
+	})
+}
+
+func (s *instrumentedService) ListTrash(ctx context.Context, opts repository.ListOptions) (repository.Page, error) {
+	return instrument.Call(ctx, s.in, "ListTrash", listAttributes(opts), func(ctx context.Context) (repository.Page, error) {
+		return s.svc.ListTrash(ctx, opts)
+	})
+}
+
+func (s *instrumentedService) RestoreData(ctx context.Context, id string) (model.DataItem, error) {
+	return instrument.Call(ctx, s.in, "RestoreData", []attribute.KeyValue{attribute.String("service.id", id)}, func(ctx context.Context) (model.DataItem, error) {
+		return s.svc.RestoreData(ctx, id)
+	})
+}
+
+func (s *instrumentedService) PurgeDeleted(ctx context.Context, retention time.Duration) (int, error) {
+	return instrument.Call(ctx, s.in, "PurgeDeleted", []attribute.KeyValue{attribute.String("service.retention", retention.String())}, func(ctx context.Context) (int, error) {
+		return s.svc.PurgeDeleted(ctx, retention)
+	})
+}
+
+func (s *instrumentedService) GetHistory(ctx context.Context, id string) ([]model.Revision, error) {
+	return instrument.Call(ctx, s.in, "GetHistory", []attribute.KeyValue{attribute.String("service.id", id)}, func(ctx context.Context) ([]model.Revision, error) {
+		return s.svc.GetHistory(ctx, id)
+	})
+}
+
+func (s *instrumentedService) GetRevision(ctx context.Context, id string, asOf AsOf) (model.Revision, error) {
+	attrs := []attribute.KeyValue{attribute.String("service.id", id)}
+	if asOf.Version != 0 {
+		attrs = append(attrs, attribute.Int64("service.asOf.version", asOf.Version))
+	} else {
+		attrs = append(attrs, attribute.String("service.asOf.time", asOf.Time.Format(time.RFC3339Nano)))
+	}
+	return instrument.Call(ctx, s.in, "GetRevision", attrs, func(ctx context.Context) (model.Revision, error) {
+		rev, err := s.svc.GetRevision(ctx, id, asOf)
+		if err == nil {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("service.version", rev.Version))
+		}
+		return rev, err
+	})
+}
+
+func (s *instrumentedService) Diff(ctx context.Context, id string, from int64, to int64) (model.Diff, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("service.id", id),
+		attribute.Int64("service.from", from),
+		attribute.Int64("service.to", to),
+	}
+	return instrument.Call(ctx, s.in, "Diff", attrs, func(ctx context.Context) (model.Diff, error) {
+		return s.svc.Diff(ctx, id, from, to)
+	})
+}
+
+func (s *instrumentedService) CreateTenant(ctx context.Context, name string) (model.Tenant, error) {
+	return instrument.Call(ctx, s.in, "CreateTenant", []attribute.KeyValue{attribute.String("service.tenant", name)}, func(ctx context.Context) (model.Tenant, error) {
+		return s.svc.CreateTenant(ctx, name)
+	})
+}
+
+func (s *instrumentedService) GetTenant(ctx context.Context, name string) (model.Tenant, error) {
+	return instrument.Call(ctx, s.in, "GetTenant", []attribute.KeyValue{attribute.String("service.tenant", name)}, func(ctx context.Context) (model.Tenant, error) {
+		return s.svc.GetTenant(ctx, name)
+	})
+}
+
+func (s *instrumentedService) ListTenants(ctx context.Context) ([]model.Tenant, error) {
+	return instrument.Call(ctx, s.in, "ListTenants", nil, func(ctx context.Context) ([]model.Tenant, error) {
+		return s.svc.ListTenants(ctx)
+	})
+}
+
+func (s *instrumentedService) DeleteTenant(ctx context.Context, name string) (int, error) {
+	return instrument.Call(ctx, s.in, "DeleteTenant", []attribute.KeyValue{attribute.String("service.tenant", name)}, func(ctx context.Context) (int, error) {
+		removed, err := s.svc.DeleteTenant(ctx, name)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("service.removed", removed))
+		return removed, err
+	})
+}
+
+func (s *instrumentedService) Watch(ctx context.Context, after int64, prefix string) (*repository.Subscription, bool) {
+	attrs := []attribute.KeyValue{
+		attribute.Int64("service.after", after),
+		attribute.String("service.prefix", prefix),
+	}
+	var complete bool
+	sub, _ := instrument.Call(ctx, s.in, "Watch", attrs, func(ctx context.Context) (*repository.Subscription, error) {
+		var sub *repository.Subscription
+		sub, complete = s.svc.Watch(ctx, after, prefix)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("service.complete", complete))
+		return sub, nil
+	})
+	return sub, complete
+}
+
+func tenantAttributes(ctx context.Context) []attribute.KeyValue {
+	return []attribute.KeyValue{attribute.String("tenant", tenant.FromContext(ctx))}
+}
+
+func expiryAttributes(expiresAt *time.Time) []attribute.KeyValue {
+	if expiresAt == nil {
+		return nil
+	}
+	return []attribute.KeyValue{attribute.String("service.expiresAt", expiresAt.Format(time.RFC3339))}
+}
+
+func listAttributes(opts repository.ListOptions) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.Int("service.limit", opts.Limit),
+		attribute.String("service.order", string(opts.OrderBy)),
+		attribute.Bool("service.descending", opts.Descending),
+	}
+	if opts.Filter != nil {
+		attrs = append(attrs, attribute.String("service.filter", opts.Filter.String()))
+	}
+	return attrs
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/internal/tenant"
+	"time"
+)
+
+// Notifier is told about the items that are created, updated and deleted
+// through NewNotifyingService. It must not block.
+type Notifier interface {
+	Notify(ctx context.Context, n model.Notification)
+}
+
+// notifyingService tells a Notifier about the successful writes of another
+// DataService. Restores do not notify. Batches bypass the DataService, so the
+// Batcher notifies on its own, see NewBatcher.
+type notifyingService struct {
+	DataService
+	notifier Notifier
+}
+
+func NewNotifyingService(svc DataService, notifier Notifier) DataService {
+	return &notifyingService{DataService: svc, notifier: notifier}
+}
+
+// newNotification returns the notification of item after op in the tenant
+// of ctx. Deletes carry the actor instead of the item.
+func newNotification(ctx context.Context, op model.Operation, item model.DataItem, actor string) model.Notification {
+	n := model.Notification{Operation: op, Tenant: tenant.FromContext(ctx), DataID: item.ID, Timestamp: item.UpdatedAt}
+	if op == model.OperationDelete {
+		n.Actor = actor
+	} else {
+		n.Data = &item
+	}
+	return n
+}
+
+func (s *notifyingService) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	item, err := s.DataService.CreateData(ctx, id, value, labels, expiresAt)
+	if err == nil {
+		s.notifier.Notify(ctx, newNotification(ctx, model.OperationCreate, item, ""))
+	}
+	return item, err
+}
+
+func (s *notifyingService) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	item, err := s.DataService.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
+	if err == nil {
+		s.notifier.Notify(ctx, newNotification(ctx, model.OperationUpdate, item, ""))
+	}
+	return item, err
+}
+
+func (s *notifyingService) PatchData(ctx context.Context, id string, patch repository.PatchFunc, expectedVersion int64) (model.DataItem, error) {
+	item, err := s.DataService.PatchData(ctx, id, patch, expectedVersion)
+	if err == nil {
+		s.notifier.Notify(ctx, newNotification(ctx, model.OperationUpdate, item, ""))
+	}
+	return item, err
+}
+
+func (s *notifyingService) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	item, err := s.DataService.DeleteData(ctx, id, expectedVersion, actor)
+	if err == nil {
+		s.notifier.Notify(ctx, newNotification(ctx, model.OperationDelete, item, actor))
+	}
+	return item, err
+}
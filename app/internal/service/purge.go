@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Purger periodically removes the items that have been in the trash for
+// longer than the retention.
+type Purger struct {
+	service          DataService
+	interval         time.Duration
+	retention        time.Duration
+	purgedCounter    prometheus.Counter
+	runCounter       *prometheus.CounterVec
+	latencyHistogram prometheus.Histogram
+}
+
+func NewPurger(svc DataService, interval time.Duration, retention time.Duration, purgedCounter prometheus.Counter, runCounter *prometheus.CounterVec, latencyHistogram prometheus.Histogram) *Purger {
+	return &Purger{
+		service:          svc,
+		interval:         interval,
+		retention:        retention,
+		purgedCounter:    purgedCounter,
+		runCounter:       runCounter,
+		latencyHistogram: latencyHistogram,
+	}
+}
+
+// Run purges once per interval until ctx is canceled. A zero interval
+// disables purging.
+func (p *Purger) Run(ctx context.Context) {
+	if p.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.purge(ctx)
+		}
+	}
+}
+
+// purge starts a new trace for every run, as it is not caused by a request.
+func (p *Purger) purge(ctx context.Context) {
+	start := time.Now()
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "PurgeDeletedJob", trace.WithNewRoot())
+	defer span.End()
+
+	defer func() {
+		p.latencyHistogram.Observe(time.Since(start).Seconds())
+	}()
+
+	span.SetAttributes(attribute.String("purge.retention", p.retention.String()))
+
+	purged, err := p.service.PurgeDeleted(ctx, p.retention)
+	p.purgedCounter.Add(float64(purged))
+	span.SetAttributes(attribute.Int("purge.count", purged))
+	if err != nil {
+		p.runCounter.WithLabelValues("error").Inc()
+		slog.ErrorContext(ctx, "failed to purge deleted data", slog.Any("error", err))
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+		span.SetStatus(codes.Error, "failed to purge deleted data")
+		return
+	}
+
+	p.runCounter.WithLabelValues("success").Inc()
+	if purged > 0 {
+		slog.InfoContext(ctx, "purged deleted data", slog.Int("count", purged))
+	}
+	span.SetStatus(codes.Ok, "success")
+}
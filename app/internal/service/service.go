@@ -5,91 +5,264 @@ import (
 	"fmt"
 	"simple_lgtm/internal/model"
 	"simple_lgtm/internal/repository"
-
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+	"slices"
+	"strings"
+	"time"
 )
 
+// DataService is the part of Service that handlers use, so that it can be
+// wrapped by NewInstrumentedService.
+type DataService interface {
+	CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error)
+	GetData(ctx context.Context, id string) (model.DataItem, error)
+	UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error)
+	PatchData(ctx context.Context, id string, patch repository.PatchFunc, expectedVersion int64) (model.DataItem, error)
+	DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error)
+	ListAllData(ctx context.Context, opts repository.ListOptions) (repository.Page, error)
+	ListTrash(ctx context.Context, opts repository.ListOptions) (repository.Page, error)
+	RestoreData(ctx context.Context, id string) (model.DataItem, error)
+	PurgeDeleted(ctx context.Context, retention time.Duration) (int, error)
+	GetHistory(ctx context.Context, id string) ([]model.Revision, error)
+	GetRevision(ctx context.Context, id string, asOf AsOf) (model.Revision, error)
+	Diff(ctx context.Context, id string, from int64, to int64) (model.Diff, error)
+	CreateTenant(ctx context.Context, name string) (model.Tenant, error)
+	GetTenant(ctx context.Context, name string) (model.Tenant, error)
+	ListTenants(ctx context.Context) ([]model.Tenant, error)
+	DeleteTenant(ctx context.Context, name string) (int, error)
+	Watch(ctx context.Context, after int64, prefix string) (*repository.Subscription, bool)
+}
+
 type Service struct {
 	repo repository.Repository
+	// feed receives the writes of repo, which must publish to it.
+	feed *repository.Feed
+	// blobs are removed with their tenant.
+	blobs TenantStore
+	// maxTenants bounds the number of tenants, including the default one.
+	maxTenants int
+}
+
+// TenantStore keeps data of tenants outside of the repository, such as
+// blobs.
+type TenantStore interface {
+	DeleteTenant(ctx context.Context, name string) error
 }
 
-func NewService(repo repository.Repository) *Service {
+func NewService(repo repository.Repository, feed *repository.Feed, blobs TenantStore, maxTenants int) *Service {
 	return &Service{
-		repo: repo,
+		repo:       repo,
+		feed:       feed,
+		blobs:      blobs,
+		maxTenants: maxTenants,
 	}
 }
 
-func (s *Service) CreateData(ctx context.Context, id string, value string) error {
-	ctx, span := otel.Tracer("app-tracer").Start(ctx, "CreateDataService")
-	defer span.End()
+func (s *Service) CreateData(ctx context.Context, id string, value model.Value, labels map[string]string, expiresAt *time.Time) (model.DataItem, error) {
+	item, err := s.repo.CreateData(ctx, id, value, labels, expiresAt)
+	if err != nil {
+		return model.DataItem{}, fmt.Errorf("failed to create data in repository: %w", err)
+	}
+	return item, nil
+}
 
-	span.SetAttributes(attribute.String("service.id", id), attribute.String("service.value", value))
+func (s *Service) GetData(ctx context.Context, id string) (model.DataItem, error) {
+	data, err := s.repo.GetData(ctx, id)
+	if err != nil {
+		return model.DataItem{}, fmt.Errorf("failed to get data from repository: %w", err)
+	}
+	return data, nil
+}
 
-	err := s.repo.CreateData(ctx, id, value)
+func (s *Service) UpdateData(ctx context.Context, id string, newValue model.Value, labels map[string]string, expiresAt *time.Time, expectedVersion int64) (model.DataItem, error) {
+	item, err := s.repo.UpdateData(ctx, id, newValue, labels, expiresAt, expectedVersion)
 	if err != nil {
-		span.SetStatus(codes.Error, "failed to create data in repository")
-		return fmt.Errorf("failed to create data in repository: %w", err)
+		return model.DataItem{}, fmt.Errorf("failed to update data in repository: %w", err)
 	}
-	span.SetStatus(codes.Ok, "success")
-	return nil
+	return item, nil
 }
 
-func (s *Service) GetData(ctx context.Context, id string) (string, error) {
-	ctx, span := otel.Tracer("app-tracer").Start(ctx, "GetDataService")
-	defer span.End()
+// PatchData replaces the value of id with the result of patch, atomically
+// with reading the current value.
+func (s *Service) PatchData(ctx context.Context, id string, patch repository.PatchFunc, expectedVersion int64) (model.DataItem, error) {
+	item, err := repository.PatchData(ctx, s.repo, id, patch, expectedVersion)
+	if err != nil {
+		return model.DataItem{}, fmt.Errorf("failed to patch data in repository: %w", err)
+	}
+	return item, nil
+}
 
-	span.SetAttributes(attribute.String("service.id", id))
+// DeleteData moves id to the trash, from which it can be restored until it is
+// purged. It returns the item as it is in the trash.
+func (s *Service) DeleteData(ctx context.Context, id string, expectedVersion int64, actor string) (model.DataItem, error) {
+	item, err := s.repo.DeleteData(ctx, id, expectedVersion, actor)
+	if err != nil {
+		return model.DataItem{}, fmt.Errorf("failed to delete data from repository: %w", err)
+	}
+	return item, nil
+}
 
-	data, err := s.repo.GetData(ctx, id)
+func (s *Service) ListAllData(ctx context.Context, opts repository.ListOptions) (repository.Page, error) {
+	page, err := s.repo.ListAllData(ctx, opts)
 	if err != nil {
-		span.SetStatus(codes.Error, "failed to get data from repository")
-		return "", fmt.Errorf("failed to get data from repository: %w", err)
+		return repository.Page{}, fmt.Errorf("failed to list all data from repository: %w", err)
 	}
-	span.SetStatus(codes.Ok, "success")
-	return data, nil
+	return page, nil
 }
 
-func (s *Service) UpdateData(ctx context.Context, id string, newValue string) error {
-	ctx, span := otel.Tracer("app-tracer").Start(ctx, "UpdateDataService")
-	defer span.End()
+func (s *Service) ListTrash(ctx context.Context, opts repository.ListOptions) (repository.Page, error) {
+	page, err := s.repo.ListTrash(ctx, opts)
+	if err != nil {
+		return repository.Page{}, fmt.Errorf("failed to list trash from repository: %w", err)
+	}
+	return page, nil
+}
 
-	span.SetAttributes(attribute.String("service.id", id), attribute.String("service.newValue", newValue))
+func (s *Service) RestoreData(ctx context.Context, id string) (model.DataItem, error) {
+	item, err := s.repo.RestoreData(ctx, id)
+	if err != nil {
+		return model.DataItem{}, fmt.Errorf("failed to restore data in repository: %w", err)
+	}
+	return item, nil
+}
 
-	err := s.repo.UpdateData(ctx, id, newValue)
+// PurgeDeleted permanently removes the items that have been in the trash for
+// longer than retention.
+func (s *Service) PurgeDeleted(ctx context.Context, retention time.Duration) (int, error) {
+	before := time.Now().Add(-retention)
+	purged, err := s.repo.PurgeDeleted(ctx, before)
 	if err != nil {
-		span.SetStatus(codes.Error, "failed to update data in repository")
-		return fmt.Errorf("failed to update data in repository: %w", err)
+		return purged, fmt.Errorf("failed to purge data in repository: %w", err)
 	}
-	span.SetStatus(codes.Ok, "success")
-	return nil
+	return purged, nil
 }
 
-func (s *Service) DeleteData(ctx context.Context, id string) error {
-	ctx, span := otel.Tracer("app-tracer").Start(ctx, "DeleteDataService")
-	defer span.End()
+func (s *Service) GetHistory(ctx context.Context, id string) ([]model.Revision, error) {
+	revisions, err := s.repo.GetHistory(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history from repository: %w", err)
+	}
+	return revisions, nil
+}
 
-	span.SetAttributes(attribute.String("service.id", id))
+// AsOf selects a past revision by Version or, if that is zero, as the latest
+// revision at Time.
+type AsOf struct {
+	Version int64
+	Time    time.Time
+}
 
-	err := s.repo.DeleteData(ctx, id)
+// GetRevision returns the revision of id selected by asOf. It fails with
+// NOT_FOUND if the item did not exist or was in the trash at that point.
+func (s *Service) GetRevision(ctx context.Context, id string, asOf AsOf) (model.Revision, error) {
+	revisions, err := s.repo.GetHistory(ctx, id)
 	if err != nil {
-		span.SetStatus(codes.Error, "failed to delete data from repository")
-		return fmt.Errorf("failed to delete data from repository: %w", err)
+		return model.Revision{}, fmt.Errorf("failed to get history from repository: %w", err)
+	}
+
+	var found *model.Revision
+	for i := range revisions {
+		if asOf.Version != 0 && revisions[i].Version == asOf.Version {
+			found = &revisions[i]
+			break
+		}
+		if asOf.Version == 0 && !revisions[i].Timestamp.After(asOf.Time) {
+			found = &revisions[i]
+		}
 	}
-	span.SetStatus(codes.Ok, "success")
-	return nil
+	if found == nil || found.Operation == model.OperationDelete {
+		return model.Revision{}, errs.NewNotFound(fmt.Errorf("data with ID %s has no revision at the requested point", id))
+	}
+
+	return *found, nil
+}
+
+// Diff compares two revisions of id. A zero to compares with the latest one.
+func (s *Service) Diff(ctx context.Context, id string, from int64, to int64) (model.Diff, error) {
+	revisions, err := s.repo.GetHistory(ctx, id)
+	if err != nil {
+		return model.Diff{}, fmt.Errorf("failed to get history from repository: %w", err)
+	}
+	if len(revisions) == 0 {
+		return model.Diff{}, errs.NewNotFound(fmt.Errorf("data with ID %s has no history", id), errs.D("id", id))
+	}
+	if to == 0 {
+		to = revisions[len(revisions)-1].Version
+	}
+
+	fromIndex := slices.IndexFunc(revisions, func(rev model.Revision) bool { return rev.Version == from })
+	toIndex := slices.IndexFunc(revisions, func(rev model.Revision) bool { return rev.Version == to })
+	if fromIndex < 0 {
+		return model.Diff{}, errs.NewNotFound(fmt.Errorf("data with ID %s has no revision %d", id, from))
+	}
+	if toIndex < 0 {
+		return model.Diff{}, errs.NewNotFound(fmt.Errorf("data with ID %s has no revision %d", id, to))
+	}
+
+	return model.NewDiff(revisions[fromIndex], revisions[toIndex]), nil
 }
 
-func (s *Service) ListAllData(ctx context.Context) ([]model.DataItem, error) {
-	ctx, span := otel.Tracer("app-tracer").Start(ctx, "ListAllDataService")
-	defer span.End()
+// CreateTenant registers a tenant unless the limit of tenants is reached,
+// which keeps the tenant a bounded metrics label.
+func (s *Service) CreateTenant(ctx context.Context, name string) (model.Tenant, error) {
+	if err := tenant.Validate(name); err != nil {
+		return model.Tenant{}, errs.NewInvalidInput(err)
+	}
+	if name == tenant.Default {
+		return model.Tenant{}, errs.NewConflict(fmt.Errorf("tenant %s already exists", name), errs.D("tenant", name))
+	}
 
-	data, err := s.repo.ListAllData(ctx)
+	t, err := s.repo.CreateTenant(ctx, name, s.maxTenants)
 	if err != nil {
-		span.SetStatus(codes.Error, "failed to list all data from repository")
-		return nil, fmt.Errorf("failed to list all data from repository: %w", err)
+		return model.Tenant{}, fmt.Errorf("failed to create tenant in repository: %w", err)
 	}
-	span.SetStatus(codes.Ok, "success")
-	return data, nil
+	return t, nil
+}
+
+// GetTenant returns a registered tenant or the default one.
+func (s *Service) GetTenant(ctx context.Context, name string) (model.Tenant, error) {
+	if name == tenant.Default {
+		return model.Tenant{Name: tenant.Default}, nil
+	}
+	t, err := s.repo.GetTenant(ctx, name)
+	if err != nil {
+		return model.Tenant{}, fmt.Errorf("failed to get tenant from repository: %w", err)
+	}
+	return t, nil
+}
+
+// ListTenants returns the default tenant followed by the registered ones.
+func (s *Service) ListTenants(ctx context.Context) ([]model.Tenant, error) {
+	tenants, err := s.repo.ListTenants(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants from repository: %w", err)
+	}
+	return append([]model.Tenant{{Name: tenant.Default}}, tenants...), nil
+}
+
+// DeleteTenant removes a tenant with all of its items and blobs and returns
+// how many items there were. The default tenant cannot be deleted.
+func (s *Service) DeleteTenant(ctx context.Context, name string) (int, error) {
+	if name == tenant.Default {
+		return 0, errs.NewInvalidInput(fmt.Errorf("the %s tenant cannot be deleted", tenant.Default))
+	}
+	removed, err := s.repo.DeleteTenant(ctx, name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete tenant in repository: %w", err)
+	}
+	if err := s.blobs.DeleteTenant(ctx, name); err != nil {
+		return 0, fmt.Errorf("failed to delete blobs of tenant: %w", err)
+	}
+	return removed, nil
+}
+
+// Watch subscribes to the changes of the items of the tenant of ctx whose id
+// starts with prefix, resuming after the event numbered after unless it is 0.
+// It reports false if changes since then were missed.
+func (s *Service) Watch(ctx context.Context, after int64, prefix string) (*repository.Subscription, bool) {
+	name := tenant.FromContext(ctx)
+	return s.feed.Subscribe(after, func(event model.Event) bool {
+		return event.Tenant == name && strings.HasPrefix(event.ID, prefix)
+	})
 }
@@ -0,0 +1,97 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"simple_lgtm/internal/blob"
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/repository"
+	"simple_lgtm/internal/service"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteTenant(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	blobs, err := blob.NewStore(dir)
+	require.NoError(t, err)
+	feed := repository.NewFeed(10, prometheus.NewGauge(prometheus.GaugeOpts{Name: "subscribers"}), prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"}))
+	repo := repository.NewPublishingRepository(repository.NewInMemoryRepository(repository.ExpiryOptions{}), feed)
+	svc := service.NewService(repo, feed, blobs, 10)
+
+	_, err = svc.CreateTenant(ctx, "acme")
+	require.NoError(t, err)
+	acme := tenant.WithTenant(ctx, "acme")
+	_, err = blobs.Put(acme, "report", "text/plain", strings.NewReader("report"), nil)
+	require.NoError(t, err)
+	require.DirExists(t, filepath.Join(dir, "acme"))
+
+	_, err = svc.DeleteTenant(ctx, "acme")
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "acme"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// A recreated tenant does not inherit the blobs.
+	_, err = svc.CreateTenant(ctx, "acme")
+	require.NoError(t, err)
+	_, _, err = blobs.Open(acme, "report")
+	assert.True(t, errs.IsNotFound(err))
+
+	_, err = svc.DeleteTenant(ctx, tenant.Default)
+	assert.True(t, errs.IsInvalidInput(err))
+}
+
+// emptyHistoryRepository returns no revisions and no error from GetHistory.
+type emptyHistoryRepository struct {
+	repository.Repository
+}
+
+func (emptyHistoryRepository) GetHistory(ctx context.Context, id string) ([]model.Revision, error) {
+	return nil, nil
+}
+
+func TestDiffEmptyHistory(t *testing.T) {
+	repo := emptyHistoryRepository{repository.NewInMemoryRepository(repository.ExpiryOptions{})}
+	svc := service.NewService(repo, nil, nil, 10)
+
+	_, err := svc.Diff(context.Background(), "1", 1, 0)
+	assert.True(t, errs.IsNotFound(err))
+}
+
+// recordingNotifier keeps the notifications it is told about.
+type recordingNotifier struct {
+	notifications []model.Notification
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification model.Notification) {
+	n.notifications = append(n.notifications, notification)
+}
+
+func TestNotifyingServiceDelete(t *testing.T) {
+	ctx := context.Background()
+	notifier := &recordingNotifier{}
+	svc := service.NewNotifyingService(service.NewService(repository.NewInMemoryRepository(repository.ExpiryOptions{}), nil, nil, 10), notifier)
+
+	_, err := svc.CreateData(ctx, "1", model.StringValue("value"), nil, nil)
+	require.NoError(t, err)
+	deleted, err := svc.DeleteData(ctx, "1", repository.AnyVersion, "alice")
+	require.NoError(t, err)
+
+	if assert.Len(t, notifier.notifications, 2) {
+		n := notifier.notifications[1]
+		assert.Equal(t, model.OperationDelete, n.Operation)
+		assert.Equal(t, "1", n.DataID)
+		assert.Equal(t, "alice", n.Actor)
+		assert.True(t, deleted.UpdatedAt.Equal(n.Timestamp))
+		assert.Nil(t, n.Data)
+	}
+}
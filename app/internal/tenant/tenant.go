@@ -0,0 +1,68 @@
+// Package tenant carries the tenant of a request through its context. Every
+// item belongs to one tenant, and ids only need to be unique within it.
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+)
+
+// Default is the tenant of requests that do not name one. It always exists.
+const Default = "default"
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type contextKey struct{}
+
+// WithTenant returns ctx carrying name.
+func WithTenant(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, contextKey{}, name)
+}
+
+// FromContext returns the tenant carried by ctx, or Default.
+func FromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(contextKey{}).(string); ok {
+		return name
+	}
+	return Default
+}
+
+// Validate checks that name is a lowercase slug of at most 63 characters, so
+// that it is safe to use in paths, log lines and metric labels.
+func Validate(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("tenant %q must be 1 to 63 lowercase letters, digits, '-' or '_', starting with a letter or digit", name)
+	}
+	return nil
+}
+
+type slogHandler struct {
+	baseHandler slog.Handler
+}
+
+// NewSlogHandler stamps the tenant of the context on every record that is
+// logged with one.
+func NewSlogHandler(baseHandler slog.Handler) slog.Handler {
+	return &slogHandler{baseHandler: baseHandler}
+}
+
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if name, ok := ctx.Value(contextKey{}).(string); ok {
+		r.AddAttrs(slog.String("tenant", name))
+	}
+	return h.baseHandler.Handle(ctx, r)
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &slogHandler{baseHandler: h.baseHandler.WithAttrs(attrs)}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	return &slogHandler{baseHandler: h.baseHandler.WithGroup(name)}
+}
+
+func (h *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.baseHandler.Enabled(ctx, level)
+}
@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxBackoff caps the wait between retries.
+const maxBackoff = time.Minute
+
+const (
+	// SignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of
+	// the timestamp, a dot and the body, keyed with the secret of the
+	// webhook.
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader carries the Unix time of the attempt, so that
+	// receivers can reject replays.
+	TimestampHeader = "X-Webhook-Timestamp"
+	// DeliveryHeader carries the ID of the notification.
+	DeliveryHeader = "X-Webhook-Delivery"
+)
+
+// Sign returns the value of SignatureHeader for body sent at timestamp.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver tries del until it succeeds, is rejected or runs out of attempts.
+// Its span is a child of the span of the write that caused it.
+func (d *Dispatcher) deliver(ctx context.Context, del delivery) {
+	ctx = trace.ContextWithRemoteSpanContext(ctx, del.origin)
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, "DeliverWebhook")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("webhook.id", del.hook.ID),
+		attribute.String("webhook.url", del.hook.URL),
+		attribute.String("notification.id", del.notification.ID),
+		attribute.String("notification.operation", string(del.notification.Operation)),
+	)
+
+	body, err := json.Marshal(del.notification)
+	if err != nil {
+		d.fail(ctx, span, del, 0, "rejected", err)
+		return
+	}
+
+	backoff := d.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := d.attempt(ctx, del, body)
+		if err == nil {
+			span.SetAttributes(attribute.Int("webhook.attempts", attempt))
+			span.SetStatus(codes.Ok, "success")
+			return
+		}
+		span.AddEvent("attempt failed", trace.WithAttributes(attribute.Int("webhook.attempt", attempt), attribute.String("error.message", err.Error())))
+
+		if !retry {
+			d.fail(ctx, span, del, attempt, "rejected", err)
+			return
+		}
+		if attempt >= d.opts.MaxAttempts {
+			d.fail(ctx, span, del, attempt, "exhausted", err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			d.fail(ctx, span, del, attempt, "canceled", ctx.Err())
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// fail dead-letters del.
+func (d *Dispatcher) fail(ctx context.Context, span trace.Span, del delivery, attempts int, reason string, err error) {
+	d.deadLetter(del, attempts, reason, err)
+	slog.WarnContext(ctx, "failed to deliver webhook", slog.String("webhook", del.hook.ID), slog.String("reason", reason), slog.Any("error", err))
+	span.SetAttributes(attribute.Int("webhook.attempts", attempts), attribute.String("webhook.failure", reason))
+	span.RecordError(err, trace.WithAttributes(attribute.String("error.message", err.Error())))
+	span.SetStatus(codes.Error, "failed to deliver webhook")
+}
+
+// attempt posts body once and reports whether a failure is worth retrying.
+// Timeouts, throttling and server errors are, other client errors are not.
+func (d *Dispatcher) attempt(ctx context.Context, del delivery, body []byte) (retry bool, err error) {
+	start := time.Now()
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		d.opts.AttemptCounter.WithLabelValues(result).Inc()
+		d.opts.LatencyHistogram.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	}()
+
+	if d.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.opts.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, del.notification.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(del.hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+}
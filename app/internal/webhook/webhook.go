@@ -0,0 +1,278 @@
+// Package webhook notifies the URLs subscribed as webhooks of the changes of
+// items. Deliveries are signed, retried with exponential backoff and, once
+// they fail for good, kept in a bounded dead-letter list.
+//
+// Webhooks and dead letters are kept in memory and lost on restart.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// deadLetterLimit is how many dead letters are kept. Older ones are dropped.
+const deadLetterLimit = 1000
+
+type Options struct {
+	// Workers is how many deliveries are made concurrently.
+	Workers int
+	// QueueSize is how many deliveries wait for a worker. Notifications that
+	// do not fit are dead-lettered right away.
+	QueueSize int
+	// MaxAttempts is how often a delivery is tried before it is
+	// dead-lettered.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, which doubles for every
+	// further retry up to maxBackoff.
+	Backoff time.Duration
+	// Timeout bounds every attempt.
+	Timeout time.Duration
+	// LatencyHistogram observes attempts by result, "success" or "error".
+	LatencyHistogram *prometheus.HistogramVec
+	// AttemptCounter counts attempts by result.
+	AttemptCounter *prometheus.CounterVec
+	// FailureCounter counts dead-lettered deliveries by reason, "rejected",
+	// "exhausted", "queue_full" or "canceled".
+	FailureCounter *prometheus.CounterVec
+}
+
+// Dispatcher stores the webhooks and delivers notifications to them.
+type Dispatcher struct {
+	opts   Options
+	client *http.Client
+	queue  chan delivery
+
+	mu    sync.RWMutex
+	hooks map[string]model.Webhook
+	dead  []model.DeadLetter
+}
+
+// delivery is a notification for one webhook. origin is the span of the
+// write that caused it, which the delivery continues.
+type delivery struct {
+	hook         model.Webhook
+	notification model.Notification
+	origin       trace.SpanContext
+}
+
+func NewDispatcher(opts Options) *Dispatcher {
+	return &Dispatcher{
+		opts: opts,
+		client: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport, otelhttp.WithPropagators(propagation.TraceContext{})),
+		},
+		queue: make(chan delivery, opts.QueueSize),
+		hooks: make(map[string]model.Webhook),
+	}
+}
+
+// newID returns a random identifier of webhooks and notifications.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validate(hook model.Webhook) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errs.NewInvalidInput(fmt.Errorf("URL must be an absolute http or https URL"))
+	}
+	if hook.Tenant != "" {
+		if err := tenant.Validate(hook.Tenant); err != nil {
+			return errs.NewInvalidInput(err)
+		}
+	}
+	for _, op := range hook.Operations {
+		if op != model.OperationCreate && op != model.OperationUpdate && op != model.OperationDelete {
+			return errs.NewInvalidInput(fmt.Errorf("operation %q must be one of create, update and delete", op))
+		}
+	}
+	return nil
+}
+
+// redact returns hook without its secret.
+func redact(hook model.Webhook) model.Webhook {
+	hook.Secret = ""
+	return hook
+}
+
+// CreateWebhook registers hook under a new ID. A secret is generated unless
+// hook has one, and returned only here.
+func (d *Dispatcher) CreateWebhook(ctx context.Context, hook model.Webhook) (model.Webhook, error) {
+	if err := validate(hook); err != nil {
+		return model.Webhook{}, err
+	}
+	hook.ID = newID()
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		hook.Secret = hex.EncodeToString(secret)
+	}
+	hook.CreatedAt = time.Now().UTC()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks[hook.ID] = hook
+	return hook, nil
+}
+
+func (d *Dispatcher) GetWebhook(ctx context.Context, id string) (model.Webhook, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	hook, ok := d.hooks[id]
+	if !ok {
+		return model.Webhook{}, errs.NewNotFound(fmt.Errorf("webhook %s not found", id))
+	}
+	return redact(hook), nil
+}
+
+// ListWebhooks returns the webhooks ordered by creation.
+func (d *Dispatcher) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	hooks := make([]model.Webhook, 0, len(d.hooks))
+	for _, hook := range d.hooks {
+		hooks = append(hooks, redact(hook))
+	}
+	slices.SortFunc(hooks, func(a, b model.Webhook) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return hooks, nil
+}
+
+// UpdateWebhook replaces the URL, tenant and operations of the webhook id,
+// and its secret unless hook has none. Deliveries that are already queued
+// are made as before.
+func (d *Dispatcher) UpdateWebhook(ctx context.Context, id string, hook model.Webhook) (model.Webhook, error) {
+	if err := validate(hook); err != nil {
+		return model.Webhook{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, ok := d.hooks[id]
+	if !ok {
+		return model.Webhook{}, errs.NewNotFound(fmt.Errorf("webhook %s not found", id))
+	}
+	current.URL = hook.URL
+	current.Tenant = hook.Tenant
+	current.Operations = hook.Operations
+	if hook.Secret != "" {
+		current.Secret = hook.Secret
+	}
+	d.hooks[id] = current
+	return redact(current), nil
+}
+
+func (d *Dispatcher) DeleteWebhook(ctx context.Context, id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.hooks[id]; !ok {
+		return errs.NewNotFound(fmt.Errorf("webhook %s not found", id))
+	}
+	delete(d.hooks, id)
+	return nil
+}
+
+// DeadLetters returns the deliveries that failed for good, oldest first.
+func (d *Dispatcher) DeadLetters(ctx context.Context) ([]model.DeadLetter, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append(make([]model.DeadLetter, 0, len(d.dead)), d.dead...), nil
+}
+
+// matches reports whether hook subscribes to n.
+func matches(hook model.Webhook, n model.Notification) bool {
+	if hook.Tenant != "" && hook.Tenant != n.Tenant {
+		return false
+	}
+	return len(hook.Operations) == 0 || slices.Contains(hook.Operations, n.Operation)
+}
+
+// Notify queues n for every webhook that subscribes to it. It does not wait
+// for the deliveries, which continue the trace of ctx.
+func (d *Dispatcher) Notify(ctx context.Context, n model.Notification) {
+	origin := trace.SpanContextFromContext(ctx)
+
+	d.mu.RLock()
+	var deliveries []delivery
+	for _, hook := range d.hooks {
+		if matches(hook, n) {
+			n.ID = newID()
+			deliveries = append(deliveries, delivery{hook: hook, notification: n, origin: origin})
+		}
+	}
+	d.mu.RUnlock()
+
+	for _, del := range deliveries {
+		select {
+		case d.queue <- del:
+		default:
+			d.deadLetter(del, 0, "queue_full", fmt.Errorf("the delivery queue is full"))
+		}
+	}
+}
+
+// deadLetter records that del failed for good after attempts.
+func (d *Dispatcher) deadLetter(del delivery, attempts int, reason string, err error) {
+	d.opts.FailureCounter.WithLabelValues(reason).Inc()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dead = append(d.dead, model.DeadLetter{
+		WebhookID:    del.hook.ID,
+		Notification: del.notification,
+		Attempts:     attempts,
+		Error:        err.Error(),
+		FailedAt:     time.Now().UTC(),
+	})
+	if len(d.dead) > deadLetterLimit {
+		d.dead = slices.Delete(d.dead, 0, len(d.dead)-deadLetterLimit)
+	}
+}
+
+// Run delivers the queued notifications until ctx is canceled. Deliveries
+// that are interrupted are dead-lettered.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range max(d.opts.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case del := <-d.queue:
+					d.deliver(ctx, del)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
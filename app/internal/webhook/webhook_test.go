@@ -0,0 +1,192 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simple_lgtm/internal/model"
+	"simple_lgtm/internal/webhook"
+	"simple_lgtm/pkg/errs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newDispatcher(t *testing.T, opts webhook.Options) *webhook.Dispatcher {
+	t.Helper()
+	opts.LatencyHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"result"})
+	opts.AttemptCounter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "attempts"}, []string{"result"})
+	opts.FailureCounter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "failures"}, []string{"reason"})
+	d := webhook.NewDispatcher(opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return d
+}
+
+// deadLetters waits until there are n dead letters.
+func deadLetters(t *testing.T, d *webhook.Dispatcher, n int) []model.DeadLetter {
+	t.Helper()
+	var letters []model.DeadLetter
+	assert.Eventually(t, func() bool {
+		letters, _ = d.DeadLetters(context.Background())
+		return len(letters) >= n
+	}, 5*time.Second, 5*time.Millisecond)
+	return letters
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	d := newDispatcher(t, webhook.Options{Workers: 1, QueueSize: 10, MaxAttempts: 1})
+
+	_, err := d.CreateWebhook(ctx, model.Webhook{URL: "ftp://example.com"})
+	assert.Error(t, err)
+	_, err = d.CreateWebhook(ctx, model.Webhook{URL: "http://example.com", Operations: []model.Operation{model.OperationRestore}})
+	assert.Error(t, err)
+
+	hook, err := d.CreateWebhook(ctx, model.Webhook{URL: "http://example.com", Tenant: "acme"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, hook.ID)
+	assert.NotEmpty(t, hook.Secret)
+
+	got, err := d.GetWebhook(ctx, hook.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, got.Secret)
+	assert.Equal(t, "acme", got.Tenant)
+
+	got, err = d.UpdateWebhook(ctx, hook.ID, model.Webhook{URL: "https://example.com/hook"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/hook", got.URL)
+	assert.Empty(t, got.Tenant)
+
+	hooks, _ := d.ListWebhooks(ctx)
+	assert.Len(t, hooks, 1)
+
+	assert.NoError(t, d.DeleteWebhook(ctx, hook.ID))
+	_, err = d.GetWebhook(ctx, hook.ID)
+	assert.True(t, errs.IsNotFound(err))
+	assert.True(t, errs.IsNotFound(d.DeleteWebhook(ctx, hook.ID)))
+}
+
+func TestDelivery(t *testing.T) {
+	t.Run("SignedWithTraceContext", func(t *testing.T) {
+		received := make(chan *http.Request, 1)
+		bodies := make(chan []byte, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received <- r
+			bodies <- body
+		}))
+		defer server.Close()
+
+		d := newDispatcher(t, webhook.Options{Workers: 1, QueueSize: 10, MaxAttempts: 1})
+		hook, err := d.CreateWebhook(context.Background(), model.Webhook{URL: server.URL, Operations: []model.Operation{model.OperationCreate}})
+		require.NoError(t, err)
+
+		traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+		spanID, _ := trace.SpanIDFromHex("0102030405060708")
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled}))
+
+		d.Notify(ctx, model.Notification{Operation: model.OperationUpdate, Tenant: "default", DataID: "1"})
+		d.Notify(ctx, model.Notification{Operation: model.OperationCreate, Tenant: "default", DataID: "1", Data: &model.DataItem{ID: "1", Value: model.StringValue("value")}})
+
+		var r *http.Request
+		select {
+		case r = <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no delivery")
+		}
+		body := <-bodies
+
+		assert.Equal(t, webhook.Sign(hook.Secret, r.Header.Get(webhook.TimestampHeader), body), r.Header.Get(webhook.SignatureHeader))
+		assert.Contains(t, r.Header.Get("traceparent"), traceID.String())
+
+		var n model.Notification
+		require.NoError(t, json.Unmarshal(body, &n))
+		assert.Equal(t, model.OperationCreate, n.Operation)
+		assert.Equal(t, "value", n.Data.Value.Text())
+		assert.Equal(t, r.Header.Get(webhook.DeliveryHeader), n.ID)
+	})
+
+	t.Run("RetriesThenSucceeds", func(t *testing.T) {
+		var attempts atomic.Int32
+		var ids []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ids = append(ids, r.Header.Get(webhook.DeliveryHeader))
+			if attempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+
+		d := newDispatcher(t, webhook.Options{Workers: 1, QueueSize: 10, MaxAttempts: 3, Backoff: time.Millisecond})
+		d.CreateWebhook(context.Background(), model.Webhook{URL: server.URL})
+		d.Notify(context.Background(), model.Notification{Operation: model.OperationDelete, DataID: "1"})
+
+		assert.Eventually(t, func() bool { return attempts.Load() == 3 }, 5*time.Second, 5*time.Millisecond)
+		letters, _ := d.DeadLetters(context.Background())
+		assert.Empty(t, letters)
+		assert.Equal(t, ids[0], ids[2])
+	})
+
+	t.Run("DeadLetters", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			if r.URL.Path == "/gone" {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		d := newDispatcher(t, webhook.Options{Workers: 1, QueueSize: 10, MaxAttempts: 3, Backoff: time.Millisecond})
+		failing, _ := d.CreateWebhook(context.Background(), model.Webhook{URL: server.URL})
+		d.Notify(context.Background(), model.Notification{Operation: model.OperationCreate, DataID: "1"})
+		letters := deadLetters(t, d, 1)
+		assert.Equal(t, failing.ID, letters[0].WebhookID)
+		assert.Equal(t, 3, letters[0].Attempts)
+		assert.Equal(t, int32(3), attempts.Load())
+		d.DeleteWebhook(context.Background(), failing.ID)
+
+		// A client error other than a timeout or throttling is not retried.
+		d.CreateWebhook(context.Background(), model.Webhook{URL: server.URL + "/gone"})
+		d.Notify(context.Background(), model.Notification{Operation: model.OperationCreate, DataID: "2"})
+		letters = deadLetters(t, d, 2)
+		assert.Equal(t, 1, letters[1].Attempts)
+		assert.Equal(t, "2", letters[1].Notification.DataID)
+	})
+
+	t.Run("QueueFull", func(t *testing.T) {
+		opts := webhook.Options{QueueSize: 0, MaxAttempts: 1}
+		opts.LatencyHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"result"})
+		opts.AttemptCounter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "attempts"}, []string{"result"})
+		opts.FailureCounter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "failures"}, []string{"reason"})
+		// Without Run nothing takes deliveries off the queue.
+		d := webhook.NewDispatcher(opts)
+		d.CreateWebhook(context.Background(), model.Webhook{URL: "http://example.com"})
+		d.Notify(context.Background(), model.Notification{Operation: model.OperationCreate, DataID: "1"})
+
+		letters, _ := d.DeadLetters(context.Background())
+		assert.Len(t, letters, 1)
+		assert.Equal(t, 1.0, testutil.ToFloat64(opts.FailureCounter.WithLabelValues("queue_full")))
+	})
+}
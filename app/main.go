@@ -2,20 +2,37 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"simple_lgtm/internal/blob"
 	"simple_lgtm/internal/config"
 	"simple_lgtm/internal/handler"
 	"simple_lgtm/internal/repository"
+	"simple_lgtm/internal/schema"
 	"simple_lgtm/internal/service"
+	"simple_lgtm/internal/tenant"
+	"simple_lgtm/internal/webhook"
+	"simple_lgtm/pkg/errs"
+	"simple_lgtm/pkg/health"
 	"simple_lgtm/pkg/metrics"
+	"simple_lgtm/pkg/shutdown"
 	"simple_lgtm/pkg/tracer"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
+	// The image has no shell or HTTP client, so the container health check
+	// runs the binary itself.
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(healthcheck(config.Load()))
+	}
 
 	var loggerHandler slog.Handler = slog.NewJSONHandler(
 		os.Stdout,
@@ -24,33 +41,285 @@ func main() {
 			Level:     slog.LevelDebug,
 		},
 	)
+	loggerHandler = tenant.NewSlogHandler(loggerHandler)
 	loggerHandler = tracer.NewSlogHandler(loggerHandler)
 	logger := slog.New(loggerHandler)
 	slog.SetDefault(logger)
 
 	cfg := config.Load()
 
-	requestCounter, latencyHistogram := metrics.Init()
 	ctx := context.Background()
 	shutdownTracer := tracer.Init(ctx, cfg.AppName)
-	defer func() {
-		if err := shutdownTracer(ctx); err != nil {
-			log.Fatalf("failed to shutdown tracer: %v", err)
-		}
+	shutdownMeter, err := metrics.InitProvider(ctx, cfg.AppName, metrics.ProviderOptions{
+		Exporter:    cfg.MetricsExporter,
+		Protocol:    cfg.MetricsOTLPProtocol,
+		Interval:    cfg.MetricsExportInterval,
+		Temporality: cfg.MetricsTemporality,
+	})
+	if err != nil {
+		log.Fatalf("failed to create meter provider: %v", err)
+	}
+
+	expiry := repository.ExpiryOptions{
+		SweepInterval:  cfg.ExpirySweepInterval,
+		ExpiredCounter: metrics.InitExpiry(),
+	}
+	repo, err := newRepository(ctx, cfg, expiry)
+	if err != nil {
+		log.Fatalf("failed to create repository: %v", err)
+	}
+	watchSubscriberGauge, watchDroppedCounter := metrics.InitWatch()
+	feed := repository.NewFeed(cfg.WatchBufferSize, watchSubscriberGauge, watchDroppedCounter)
+	repo = repository.NewPublishingRepository(repo, feed)
+	repoLatencyHistogram, repoErrorCounter := metrics.InitRepository()
+	repo = repository.NewInstrumentedRepository(repo, cfg.StorageBackend, repoLatencyHistogram, repoErrorCounter)
+	if cfg.CacheSize > 0 {
+		cacheRequestCounter, cacheEvictionCounter := metrics.InitCache()
+		repo = repository.NewCachingRepository(repo, repository.CacheOptions{
+			Size:            cfg.CacheSize,
+			TTL:             cfg.CacheTTL,
+			RequestCounter:  cacheRequestCounter,
+			EvictionCounter: cacheEvictionCounter,
+		})
+	}
+
+	// The background workers share a context that is cancelled when the
+	// server shuts down.
+	workerCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+	var workers sync.WaitGroup
+
+	webhookLatencyHistogram, webhookAttemptCounter, webhookFailureCounter := metrics.InitWebhook()
+	webhooks := webhook.NewDispatcher(webhook.Options{
+		Workers:          cfg.WebhookWorkers,
+		QueueSize:        cfg.WebhookQueueSize,
+		MaxAttempts:      cfg.WebhookMaxAttempts,
+		Backoff:          cfg.WebhookBackoff,
+		Timeout:          cfg.WebhookTimeout,
+		LatencyHistogram: webhookLatencyHistogram,
+		AttemptCounter:   webhookAttemptCounter,
+		FailureCounter:   webhookFailureCounter,
+	})
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		webhooks.Run(workerCtx)
 	}()
 
-	repo := repository.NewInMemoryRepository()
-	svc := service.NewService(repo)
-	hldr := handler.NewHandler(svc, requestCounter, latencyHistogram)
+	blobs, err := blob.NewStore(cfg.BlobDir)
+	if err != nil {
+		log.Fatalf("failed to create blob store: %v", err)
+	}
+
+	svc := service.NewService(repo, feed, blobs, cfg.MaxTenants)
+	serviceLatencyHistogram, serviceErrorCounter := metrics.InitService()
+	instrumentedSvc := service.NewInstrumentedService(service.NewNotifyingService(svc, webhooks), serviceLatencyHistogram, serviceErrorCounter)
+
+	purgedCounter, purgeRunCounter, purgeLatencyHistogram := metrics.InitPurge()
+	purger := service.NewPurger(instrumentedSvc, cfg.PurgeInterval, cfg.TrashRetention, purgedCounter, purgeRunCounter, purgeLatencyHistogram)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		purger.Run(workerCtx)
+	}()
+
+	batcher := service.NewBatcher(svc, webhooks, cfg.BatchMaxSize, metrics.InitBatch())
+
+	schemas := schema.NewRegistry()
+	if cfg.SchemaFile != "" {
+		n, err := schemas.LoadFile(ctx, cfg.SchemaFile)
+		if err != nil {
+			log.Fatalf("failed to load schemas: %v", err)
+		}
+		slog.Info("loaded schemas", slog.String("file", cfg.SchemaFile), slog.Int("count", n))
+	}
+
+	hldr := handler.NewHandler(instrumentedSvc, batcher, schemas, cfg.PatchMaxSize, metrics.InitTenant())
 
 	mux := http.NewServeMux()
 	handler.Routes(mux, hldr)
+	if cfg.MetricsExporter == metrics.ExporterPrometheus {
+		handler.MetricsRoutes(mux)
+	}
+	handler.WebhookRoutes(mux, handler.NewWebhookHandler(webhooks))
+	handler.SchemaRoutes(mux, handler.NewSchemaHandler(schemas))
+
+	handler.BlobRoutes(mux, handler.NewBlobHandler(blobs, cfg.BlobMaxSize, metrics.InitBlob()), hldr)
+
+	checks := health.NewRegistry(metrics.InitHealth())
+	checks.Register(health.Check{Name: "repository", Timeout: cfg.HealthCheckTimeout, Fn: repo.Ping})
+	checks.Register(health.Check{Name: "blobs", Timeout: cfg.HealthCheckTimeout, Fn: blobs.Ping})
+	checks.Register(health.Check{Name: "otlp", Timeout: cfg.HealthCheckTimeout, Optional: true, Fn: tracer.Check})
+	handler.HealthRoutes(mux, handler.NewHealthHandler(checks))
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		checks.Run(workerCtx, cfg.HealthCheckInterval)
+	}()
+
+	// Only the in-memory store loses its state on restart, so it is the only
+	// one that is snapshotted.
+	var snapshots *repository.SnapshotManager
+	if snapshotter, ok := repo.(repository.Snapshotter); ok {
+		snapshotLatencyHistogram, snapshotSizeHistogram := metrics.InitSnapshot()
+		snapshots = repository.NewSnapshotManager(snapshotter, repository.SnapshotOptions{
+			Dir:      cfg.SnapshotDir,
+			Interval: cfg.SnapshotInterval,
+			Retain:   cfg.SnapshotRetain,
+		}, snapshotLatencyHistogram, snapshotSizeHistogram)
 
+		if _, err := snapshots.RestoreLatest(ctx); errs.IsNotFound(err) {
+			slog.Info("no snapshot to restore", slog.String("dir", cfg.SnapshotDir))
+		} else if err != nil {
+			log.Fatalf("failed to restore snapshot: %v", err)
+		}
+
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			snapshots.Run(workerCtx)
+		}()
+
+		handler.AdminRoutes(mux, handler.NewAdminHandler(snapshots))
+	}
+
+	requestCounter, latencyHistogram, inFlightGauge, sizeHistogram := metrics.Init()
+	httpMetrics := handler.NewMetrics(mux, requestCounter, latencyHistogram, inFlightGauge, sizeHistogram)
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           handler.TenantPrefix(httpMetrics),
+		ReadTimeout:       cfg.HTTPReadTimeout,
+		ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTPWriteTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+		MaxHeaderBytes:    cfg.HTTPMaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(loggerHandler, slog.LevelWarn),
+	}
+	// Watch streams only end when their subscription is closed.
+	srv.RegisterOnShutdown(feed.Close)
+
+	// Stopping the server first lets the requests in flight finish against
+	// the repository, which is closed only once nothing writes to it anymore.
+	// Metrics are pushed once more after that to include the durations of
+	// the steps, and the tracer is flushed last so that it exports the spans
+	// of the shutdown.
+	shutdowns := shutdown.NewSequence(metrics.InitShutdown())
+	shutdowns.Add("readiness", func(ctx context.Context) error {
+		checks.ShutDown()
+		// Gives load balancers time to see the failing readiness and stop
+		// sending requests before the server stops accepting them.
+		select {
+		case <-time.After(cfg.ShutdownDrainDelay):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	shutdowns.Add("http", func(ctx context.Context) error {
+		slog.InfoContext(ctx, "draining requests", slog.Int64("in_flight", httpMetrics.Count()))
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.WarnContext(ctx, "closing requests still in flight", slog.Int64("in_flight", httpMetrics.Count()))
+			return errors.Join(err, srv.Close())
+		}
+		return nil
+	})
+	shutdowns.Add("workers", func(ctx context.Context) error {
+		stopWorkers()
+		done := make(chan struct{})
+		go func() {
+			workers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if snapshots != nil {
+		shutdowns.Add("snapshot", func(ctx context.Context) error {
+			_, err := snapshots.Save(ctx)
+			return err
+		})
+	}
+	shutdowns.Add("repository", func(context.Context) error {
+		return repo.Close()
+	})
+	shutdowns.Add("metrics", shutdownMeter)
+	shutdowns.Add("tracer", shutdownTracer)
+
+	signalCtx, stopSignals := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
 	slog.Info("app started", slog.Any("port", cfg.Port))
 
-	err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), mux)
-	if err != nil {
+	exitCode := 0
+	select {
+	case <-signalCtx.Done():
+		slog.Info("received shutdown signal", slog.Any("signal", context.Cause(signalCtx)))
+	case err := <-serveErr:
 		slog.Error("failed to start server", slog.Any("error", err))
-		return
+		exitCode = 1
+	}
+	// A second signal terminates the process without waiting for the
+	// shutdown.
+	stopSignals()
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, cfg.ShutdownTimeout)
+	defer cancel()
+	if err := shutdowns.Run(shutdownCtx); err != nil {
+		exitCode = 1
+	}
+	if exitCode != 0 {
+		cancel()
+		os.Exit(exitCode)
+	}
+}
+
+func newRepository(ctx context.Context, cfg *config.Config, expiry repository.ExpiryOptions) (repository.Repository, error) {
+	switch cfg.StorageBackend {
+	case "memory":
+		return repository.NewInMemoryRepository(expiry), nil
+	case "file":
+		return repository.NewFileRepository(ctx, repository.FileOptions{
+			Path:            cfg.WALPath,
+			SyncBatchSize:   cfg.WALSyncBatchSize,
+			SyncInterval:    cfg.WALSyncInterval,
+			CompactInterval: cfg.WALCompactInterval,
+			Expiry:          expiry,
+		})
+	case "sql":
+		db, err := repository.OpenDB(cfg.DBDialect, cfg.DBDSN)
+		if err != nil {
+			return nil, err
+		}
+		db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+		db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+		metrics.InitDBStats(db, cfg.DBDialect)
+		return repository.NewSQLRepository(ctx, db, cfg.DBDialect, expiry)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
+
+// healthcheck requests the readiness of the server listening on the
+// configured port and returns the exit code of the check.
+func healthcheck(cfg *config.Config) int {
+	client := &http.Client{Timeout: cfg.HealthCheckTimeout + time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%d/readyz", cfg.Port))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "health check failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "health check failed: %s\n", resp.Status)
+		return 1
 	}
+	return 0
 }
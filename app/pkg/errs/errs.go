@@ -1,8 +1,10 @@
 package errs
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 )
 
@@ -12,36 +14,153 @@ const (
 	codeInternal     errCode = "INTERNAL"
 	codeNotFound     errCode = "NOT_FOUND"
 	codeInvalidInput errCode = "INVALID_INPUT"
+	codeConflict     errCode = "CONFLICT"
+	// codePreconditionFailed is a conflict with a version the client asserted
+	// through a conditional request.
+	codePreconditionFailed errCode = "PRECONDITION_FAILED"
+	codeUnauthorized       errCode = "UNAUTHORIZED"
+	codeForbidden          errCode = "FORBIDDEN"
+	codeRateLimited        errCode = "RATE_LIMITED"
+	codeTimeout            errCode = "TIMEOUT"
+	codeUnavailable        errCode = "UNAVAILABLE"
+	codePayloadTooLarge    errCode = "PAYLOAD_TOO_LARGE"
 )
 
 type appError struct {
 	Code errCode
 	Err  error
+	// Details are structured facts about the error for clients, such as the
+	// ID of the item that was not found.
+	Details map[string]any
 }
 
 func (a *appError) Error() string {
 	return fmt.Sprintf("code: %s, error: %s", a.Code, a.Err.Error())
 }
 
-func NewInternal(err error) error {
+func (a *appError) Unwrap() error {
+	return a.Err
+}
+
+// Detail is a structured fact about an error, see Details.
+type Detail struct {
+	Key   string
+	Value any
+}
+
+// D returns the detail key with value.
+func D(key string, value any) Detail {
+	return Detail{Key: key, Value: value}
+}
+
+func newError(code errCode, err error, details []Detail) error {
 	if err == nil {
 		return nil
 	}
-	return &appError{Code: codeInternal, Err: err}
+	appErr := &appError{Code: code, Err: err}
+	if len(details) > 0 {
+		appErr.Details = make(map[string]any, len(details))
+		for _, d := range details {
+			appErr.Details[d.Key] = d.Value
+		}
+	}
+	return appErr
 }
 
-func NewInvalidInput(err error) error {
-	if err == nil {
-		return nil
+// NewInternal reports an unexpected failure. Failures caused by an expired
+// context deadline are reported as timeouts instead.
+func NewInternal(err error, details ...Detail) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newError(codeTimeout, err, details)
 	}
-	return &appError{Code: codeInvalidInput, Err: err}
+	return newError(codeInternal, err, details)
 }
 
-func NewNotFound(err error) error {
-	if err == nil {
-		return nil
+func NewInvalidInput(err error, details ...Detail) error {
+	return newError(codeInvalidInput, err, details)
+}
+
+func NewNotFound(err error, details ...Detail) error {
+	return newError(codeNotFound, err, details)
+}
+
+// NewConflict reports a write that conflicts with the current state, such as
+// a create of an existing item or an update of a version that is outdated.
+func NewConflict(err error, details ...Detail) error {
+	return newError(codeConflict, err, details)
+}
+
+func NewPreconditionFailed(err error, details ...Detail) error {
+	return newError(codePreconditionFailed, err, details)
+}
+
+// NewUnauthorized reports a request without valid credentials.
+func NewUnauthorized(err error, details ...Detail) error {
+	return newError(codeUnauthorized, err, details)
+}
+
+// NewForbidden reports a request whose credentials do not allow it, or that
+// a quota refuses although it is valid.
+func NewForbidden(err error, details ...Detail) error {
+	return newError(codeForbidden, err, details)
+}
+
+// NewRateLimited reports a request over a rate limit. A "retry_after" detail
+// in seconds tells clients when to retry.
+func NewRateLimited(err error, details ...Detail) error {
+	return newError(codeRateLimited, err, details)
+}
+
+// NewTimeout reports an operation that did not finish in time.
+func NewTimeout(err error, details ...Detail) error {
+	return newError(codeTimeout, err, details)
+}
+
+// NewUnavailable reports a dependency or the server itself being temporarily
+// unable to serve, such as while it shuts down.
+func NewUnavailable(err error, details ...Detail) error {
+	return newError(codeUnavailable, err, details)
+}
+
+// NewPayloadTooLarge reports a request body over the size limit.
+func NewPayloadTooLarge(err error, details ...Detail) error {
+	return newError(codePayloadTooLarge, err, details)
+}
+
+func is(err error, code errCode) bool {
+	var appErr *appError
+	return errors.As(err, &appErr) && appErr.Code == code
+}
+
+func IsInternal(err error) bool           { return is(err, codeInternal) }
+func IsInvalidInput(err error) bool       { return is(err, codeInvalidInput) }
+func IsNotFound(err error) bool           { return is(err, codeNotFound) }
+func IsConflict(err error) bool           { return is(err, codeConflict) }
+func IsPreconditionFailed(err error) bool { return is(err, codePreconditionFailed) }
+func IsUnauthorized(err error) bool       { return is(err, codeUnauthorized) }
+func IsForbidden(err error) bool          { return is(err, codeForbidden) }
+func IsRateLimited(err error) bool        { return is(err, codeRateLimited) }
+func IsTimeout(err error) bool            { return is(err, codeTimeout) }
+func IsUnavailable(err error) bool        { return is(err, codeUnavailable) }
+func IsPayloadTooLarge(err error) bool    { return is(err, codePayloadTooLarge) }
+
+// Code returns the code of err, such as "NOT_FOUND", or "UNKNOWN" if it is
+// not an application error.
+func Code(err error) string {
+	var appErr *appError
+	if errors.As(err, &appErr) {
+		return string(appErr.Code)
+	}
+	return "UNKNOWN"
+}
+
+// Details returns a copy of the details of err, or nil if it has none.
+func Details(err error) map[string]any {
+	var appErr *appError
+	if errors.As(err, &appErr) {
+		return maps.Clone(appErr.Details)
 	}
-	return &appError{Code: codeNotFound, Err: err}
+	return nil
 }
 
 func MapHttp(err error) (statusCode int, message string) {
@@ -58,6 +177,22 @@ func MapHttp(err error) (statusCode int, message string) {
 			return http.StatusNotFound, err.Error()
 		case codeInvalidInput:
 			return http.StatusBadRequest, err.Error()
+		case codeConflict:
+			return http.StatusConflict, err.Error()
+		case codePreconditionFailed:
+			return http.StatusPreconditionFailed, err.Error()
+		case codeUnauthorized:
+			return http.StatusUnauthorized, err.Error()
+		case codeForbidden:
+			return http.StatusForbidden, err.Error()
+		case codeRateLimited:
+			return http.StatusTooManyRequests, err.Error()
+		case codeTimeout:
+			return http.StatusGatewayTimeout, err.Error()
+		case codeUnavailable:
+			return http.StatusServiceUnavailable, err.Error()
+		case codePayloadTooLarge:
+			return http.StatusRequestEntityTooLarge, err.Error()
 		default:
 			return http.StatusInternalServerError, fmt.Sprintf("Unknown app error: %s", err.Error())
 		}
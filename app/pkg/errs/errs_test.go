@@ -1,7 +1,9 @@
 package errs
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"testing"
 
@@ -36,6 +38,51 @@ func TestMapHttp(t *testing.T) {
 		assert.Contains(t, msg, "bad input")
 	})
 
+	t.Run("ConflictError", func(t *testing.T) {
+		err := NewConflict(errors.New("version mismatch"))
+		status, msg := MapHttp(err)
+		assert.Equal(t, http.StatusConflict, status)
+		assert.Contains(t, msg, "version mismatch")
+		assert.True(t, IsConflict(err))
+		assert.False(t, IsConflict(NewNotFound(errors.New("not found"))))
+	})
+
+	t.Run("PreconditionFailedError", func(t *testing.T) {
+		err := NewPreconditionFailed(errors.New("etag mismatch"))
+		status, msg := MapHttp(err)
+		assert.Equal(t, http.StatusPreconditionFailed, status)
+		assert.Contains(t, msg, "etag mismatch")
+	})
+
+	t.Run("NewCodes", func(t *testing.T) {
+		for _, tc := range []struct {
+			err    error
+			is     func(error) bool
+			status int
+		}{
+			{NewUnauthorized(errors.New("no credentials")), IsUnauthorized, http.StatusUnauthorized},
+			{NewForbidden(errors.New("not allowed")), IsForbidden, http.StatusForbidden},
+			{NewRateLimited(errors.New("slow down")), IsRateLimited, http.StatusTooManyRequests},
+			{NewTimeout(errors.New("too slow")), IsTimeout, http.StatusGatewayTimeout},
+			{NewUnavailable(errors.New("shutting down")), IsUnavailable, http.StatusServiceUnavailable},
+			{NewPayloadTooLarge(errors.New("too big")), IsPayloadTooLarge, http.StatusRequestEntityTooLarge},
+		} {
+			status, _ := MapHttp(tc.err)
+			assert.Equal(t, tc.status, status, tc.err.Error())
+			assert.True(t, tc.is(tc.err), tc.err.Error())
+			assert.True(t, tc.is(fmt.Errorf("wrapped: %w", tc.err)), tc.err.Error())
+			assert.False(t, IsInternal(tc.err), tc.err.Error())
+		}
+	})
+
+	t.Run("DeadlineExceeded", func(t *testing.T) {
+		err := NewInternal(fmt.Errorf("query failed: %w", context.DeadlineExceeded))
+		assert.True(t, IsTimeout(err))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		status, _ := MapHttp(err)
+		assert.Equal(t, http.StatusGatewayTimeout, status)
+	})
+
 	t.Run("UnknownAppErrorCode", func(t *testing.T) {
 		unknownErr := &appError{Code: "UNKNOWN_CODE", Err: errors.New("unknown")}
 		status, msg := MapHttp(unknownErr)
@@ -50,3 +97,16 @@ func TestMapHttp(t *testing.T) {
 		assert.Contains(t, msg, "Unexpected error")
 	})
 }
+
+func TestDetails(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", NewNotFound(errors.New("data with ID 1 not found"), D("id", "1"), D("version", int64(2))))
+	assert.Equal(t, map[string]any{"id": "1", "version": int64(2)}, Details(err))
+
+	details := Details(err)
+	details["id"] = "changed"
+	assert.Equal(t, "1", Details(err)["id"])
+
+	assert.Nil(t, Details(NewInternal(errors.New("no details"))))
+	assert.Nil(t, Details(errors.New("plain error")))
+	assert.Nil(t, NewNotFound(nil, D("id", "1")))
+}
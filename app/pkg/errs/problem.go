@@ -0,0 +1,120 @@
+package errs
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+)
+
+// Problem is the public view of an error as an RFC 7807 problem detail. It
+// keeps the code, details and field errors of the error but none of the
+// messages wrapped around it, and no message of server errors at all, so that
+// the internal chain of an error only ends up in logs and spans.
+type Problem struct {
+	Type   string
+	Title  string
+	Status int
+	// Detail is the message the error was created with.
+	Detail string
+	// Code is the stable, machine-readable code of the error, such as
+	// "NOT_FOUND".
+	Code string
+	// Errors lists the invalid fields of the input, if the error knows them.
+	Errors []FieldError
+	// Extensions are further members, such as the details of the error.
+	// Members of the same name as the above are ignored.
+	Extensions map[string]any
+}
+
+// FieldError is one invalid field of the input.
+type FieldError struct {
+	// Field is the JSON pointer (RFC 6901) of the field within the validated
+	// document, which is empty for the whole document.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// Source names the rule the field breaks, such as the ID of a schema.
+	Source string `json:"source,omitempty"`
+}
+
+// FieldErrorer is implemented by errors that know which fields of the input
+// are invalid. They are found anywhere in the chain of an error.
+type FieldErrorer interface {
+	FieldErrors() []FieldError
+}
+
+// serverDetails are the public messages of server errors, whose own messages
+// may reveal internals.
+var serverDetails = map[errCode]string{
+	codeInternal:    "The server failed to process the request.",
+	codeTimeout:     "The request did not complete in time.",
+	codeUnavailable: "The service is temporarily unavailable.",
+}
+
+// NewProblem returns the problem that describes err to clients. Its status
+// and code are those of the outermost application error, while its detail is
+// the message of the innermost one, which is where the error originated.
+func NewProblem(err error) Problem {
+	status, _ := MapHttp(err)
+	p := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   Code(err),
+	}
+
+	var chain []*appError
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if appErr, ok := e.(*appError); ok {
+			chain = append(chain, appErr)
+		}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if len(chain[i].Details) > 0 && p.Extensions == nil {
+			p.Extensions = make(map[string]any)
+		}
+		// Outer errors know more about the request, so they take precedence.
+		maps.Copy(p.Extensions, chain[i].Details)
+	}
+
+	if status >= http.StatusInternalServerError {
+		p.Detail = serverDetails[codeInternal]
+		if len(chain) > 0 && serverDetails[chain[0].Code] != "" {
+			p.Detail = serverDetails[chain[0].Code]
+		}
+		return p
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if s, _ := MapHttp(chain[i]); s < http.StatusInternalServerError {
+			p.Detail = chain[i].Err.Error()
+			break
+		}
+	}
+
+	var fields FieldErrorer
+	if errors.As(err, &fields) {
+		p.Errors = fields.FieldErrors()
+	}
+	return p
+}
+
+// MarshalJSON encodes p with its extensions as members of the same object.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+7)
+	maps.Copy(members, p.Extensions)
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	members["code"] = p.Code
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	} else {
+		delete(members, "detail")
+	}
+	if len(p.Errors) > 0 {
+		members["errors"] = p.Errors
+	} else {
+		delete(members, "errors")
+	}
+	return json.Marshal(members)
+}
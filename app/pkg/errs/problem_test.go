@@ -0,0 +1,79 @@
+package errs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fieldsError struct{}
+
+func (fieldsError) Error() string { return "value does not conform" }
+
+func (fieldsError) FieldErrors() []FieldError {
+	return []FieldError{{Field: "/total", Message: "must be a number", Source: "orders"}}
+}
+
+func TestNewProblem(t *testing.T) {
+	t.Run("ClientError", func(t *testing.T) {
+		err := fmt.Errorf("failed to get data from repository: %w", NewNotFound(errors.New("data with ID 1 not found"), D("id", "1")))
+		p := NewProblem(err)
+		assert.Equal(t, Problem{
+			Type:       "about:blank",
+			Title:      "Not Found",
+			Status:     http.StatusNotFound,
+			Detail:     "data with ID 1 not found",
+			Code:       "NOT_FOUND",
+			Extensions: map[string]any{"id": "1"},
+		}, p)
+	})
+
+	t.Run("NestedErrors", func(t *testing.T) {
+		conflict := NewConflict(errors.New("data with ID 1 is at version 3, expected 2"), D("version", 3), D("id", "1"))
+		err := NewPreconditionFailed(fmt.Errorf("failed to update data: %w", conflict), D("id", "outer"))
+		p := NewProblem(err)
+		assert.Equal(t, http.StatusPreconditionFailed, p.Status)
+		assert.Equal(t, "PRECONDITION_FAILED", p.Code)
+		assert.Equal(t, "data with ID 1 is at version 3, expected 2", p.Detail)
+		assert.Equal(t, map[string]any{"id": "outer", "version": 3}, p.Extensions)
+	})
+
+	t.Run("ServerError", func(t *testing.T) {
+		p := NewProblem(fmt.Errorf("failed: %w", NewInternal(errors.New("sql: connection refused"))))
+		assert.Equal(t, http.StatusInternalServerError, p.Status)
+		assert.Equal(t, "The server failed to process the request.", p.Detail)
+
+		p = NewProblem(errors.New("plain error"))
+		assert.Equal(t, "UNKNOWN", p.Code)
+		assert.Equal(t, "The server failed to process the request.", p.Detail)
+
+		p = NewProblem(NewUnavailable(errors.New("dial tcp: refused")))
+		assert.Equal(t, "The service is temporarily unavailable.", p.Detail)
+	})
+
+	t.Run("FieldErrors", func(t *testing.T) {
+		p := NewProblem(fmt.Errorf("wrapped: %w", NewInvalidInput(fieldsError{})))
+		assert.Equal(t, "value does not conform", p.Detail)
+		assert.Equal(t, []FieldError{{Field: "/total", Message: "must be a number", Source: "orders"}}, p.Errors)
+	})
+}
+
+func TestProblemMarshalJSON(t *testing.T) {
+	p := NewProblem(NewInvalidInput(fieldsError{}, D("operation", 2), D("status", "ignored")))
+	b, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Bad Request",
+		"status": 400,
+		"code": "INVALID_INPUT",
+		"detail": "value does not conform",
+		"errors": [{"field": "/total", "message": "must be a number", "source": "orders"}],
+		"operation": 2
+	}`, string(b))
+}
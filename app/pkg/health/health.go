@@ -0,0 +1,173 @@
+// Package health runs the checks of the dependencies of the process, so that
+// an orchestrator can tell whether it is ready to serve.
+package health
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	StatusPass = "pass"
+	StatusFail = "fail"
+	// StatusWarn is the status of a failed optional check.
+	StatusWarn = "warn"
+)
+
+// DefaultTimeout bounds checks registered without a timeout.
+const DefaultTimeout = 2 * time.Second
+
+// errShuttingDown fails readiness once the process starts shutting down.
+var errShuttingDown = errors.New("server is shutting down")
+
+// Check is a dependency of the process.
+type Check struct {
+	Name string
+	// Timeout bounds one run of Fn. Zero means DefaultTimeout.
+	Timeout time.Duration
+	// Optional checks are reported, but do not fail readiness, as for
+	// telemetry that the process can serve without.
+	Optional bool
+	// Fn returns an error if the dependency is not usable.
+	Fn func(ctx context.Context) error
+}
+
+// Result is the outcome of one run of a check.
+type Result struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Report is the outcome of all checks, keyed by name. Its status is
+// StatusFail if any required check failed, StatusWarn if only optional ones
+// did and StatusPass otherwise.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Registry holds the checks that components register.
+type Registry struct {
+	mu           sync.RWMutex
+	checks       []Check
+	shuttingDown atomic.Bool
+
+	// statusGauge is 1 while a check passes and 0 while it fails,
+	// durationGauge is how long its last run took. Both are labeled by check.
+	statusGauge   *prometheus.GaugeVec
+	durationGauge *prometheus.GaugeVec
+}
+
+func NewRegistry(statusGauge *prometheus.GaugeVec, durationGauge *prometheus.GaugeVec) *Registry {
+	return &Registry{statusGauge: statusGauge, durationGauge: durationGauge}
+}
+
+// Register adds c, replacing any check of the same name.
+func (r *Registry) Register(c Check) {
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = slices.DeleteFunc(r.checks, func(other Check) bool { return other.Name == c.Name })
+	r.checks = append(r.checks, c)
+}
+
+// ShutDown makes every later readiness check fail, so that traffic moves
+// away from the process before it stops serving.
+func (r *Registry) ShutDown() {
+	r.shuttingDown.Store(true)
+}
+
+// ShuttingDown reports whether ShutDown was called.
+func (r *Registry) ShuttingDown() bool {
+	return r.shuttingDown.Load()
+}
+
+// Check runs all checks concurrently, each within its timeout, and records
+// their results in the gauges. Once r is shutting down, the report fails
+// with a "shutdown" check of its own.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := slices.Clone(r.checks)
+	r.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = r.run(ctx, c)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusPass, Checks: make(map[string]Result, len(checks)+1)}
+	for i, c := range checks {
+		report.Checks[c.Name] = results[i]
+		switch {
+		case results[i].Status == StatusFail:
+			report.Status = StatusFail
+		case results[i].Status == StatusWarn && report.Status == StatusPass:
+			report.Status = StatusWarn
+		}
+	}
+	if r.ShuttingDown() {
+		report.Status = StatusFail
+		report.Checks["shutdown"] = Result{Status: StatusFail, Error: errShuttingDown.Error()}
+	}
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, c Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.Fn(ctx)
+	duration := time.Since(start)
+
+	r.durationGauge.WithLabelValues(c.Name).Set(duration.Seconds())
+	result := Result{Status: StatusPass, DurationMs: float64(duration.Microseconds()) / 1000}
+	if err == nil {
+		r.statusGauge.WithLabelValues(c.Name).Set(1)
+		return result
+	}
+
+	r.statusGauge.WithLabelValues(c.Name).Set(0)
+	result.Status, result.Error = StatusFail, err.Error()
+	if c.Optional {
+		result.Status = StatusWarn
+	}
+	slog.WarnContext(ctx, "health check failed", slog.String("check", c.Name), slog.Bool("optional", c.Optional), slog.Any("error", err))
+	return result
+}
+
+// Run checks every interval until ctx is done, so that the gauges stay
+// current without a client polling for readiness.
+func (r *Registry) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Check(ctx)
+		}
+	}
+}
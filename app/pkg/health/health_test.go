@@ -0,0 +1,86 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"simple_lgtm/pkg/health"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRegistry() (*health.Registry, *prometheus.GaugeVec) {
+	statusGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_health_check_status"}, []string{"check"})
+	durationGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_health_check_duration_seconds"}, []string{"check"})
+	return health.NewRegistry(statusGauge, durationGauge), statusGauge
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	pass := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("unreachable") }
+
+	t.Run("Pass", func(t *testing.T) {
+		registry, statusGauge := newRegistry()
+		registry.Register(health.Check{Name: "repository", Fn: pass})
+
+		report := registry.Check(ctx)
+		assert.Equal(t, health.StatusPass, report.Status)
+		assert.Equal(t, health.StatusPass, report.Checks["repository"].Status)
+		assert.Equal(t, 1.0, testutil.ToFloat64(statusGauge.WithLabelValues("repository")))
+	})
+
+	t.Run("Fail", func(t *testing.T) {
+		registry, statusGauge := newRegistry()
+		registry.Register(health.Check{Name: "repository", Fn: fail})
+		registry.Register(health.Check{Name: "otlp", Optional: true, Fn: pass})
+
+		report := registry.Check(ctx)
+		assert.Equal(t, health.StatusFail, report.Status)
+		assert.Equal(t, health.Result{Status: health.StatusFail, Error: "unreachable"}, withoutDuration(report.Checks["repository"]))
+		assert.Equal(t, 0.0, testutil.ToFloat64(statusGauge.WithLabelValues("repository")))
+	})
+
+	t.Run("OptionalFail", func(t *testing.T) {
+		registry, statusGauge := newRegistry()
+		registry.Register(health.Check{Name: "repository", Fn: pass})
+		registry.Register(health.Check{Name: "otlp", Optional: true, Fn: fail})
+
+		report := registry.Check(ctx)
+		assert.Equal(t, health.StatusWarn, report.Status)
+		assert.Equal(t, health.StatusWarn, report.Checks["otlp"].Status)
+		assert.Equal(t, 0.0, testutil.ToFloat64(statusGauge.WithLabelValues("otlp")))
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		registry, _ := newRegistry()
+		registry.Register(health.Check{Name: "slow", Timeout: 10 * time.Millisecond, Fn: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}})
+
+		report := registry.Check(ctx)
+		assert.Equal(t, health.StatusFail, report.Status)
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+	})
+
+	t.Run("ShutDown", func(t *testing.T) {
+		registry, _ := newRegistry()
+		registry.Register(health.Check{Name: "repository", Fn: pass})
+		registry.ShutDown()
+
+		report := registry.Check(ctx)
+		assert.Equal(t, health.StatusFail, report.Status)
+		assert.Equal(t, health.StatusPass, report.Checks["repository"].Status)
+		assert.Equal(t, health.StatusFail, report.Checks["shutdown"].Status)
+	})
+}
+
+func withoutDuration(r health.Result) health.Result {
+	r.DurationMs = 0
+	return r
+}
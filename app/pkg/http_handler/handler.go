@@ -3,39 +3,91 @@ package http_handler
 import (
 	"context"
 	"encoding/json"
+	"log/slog"
+	"maps"
 	"net/http"
 	"simple_lgtm/pkg/errs"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
 
 type response struct {
-	Message string `json:"message"`
-	Data    any    `json:"data,omitempty"`
+	Message    string `json:"message"`
+	Data       any    `json:"data,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
+// AbortJSON writes err as an application/problem+json body, see
+// errs.NewProblem, with the IDs of the trace and span of ctx. The error
+// itself is logged, at error level for server errors.
 func AbortJSON(ctx context.Context, w http.ResponseWriter, err error) {
+	AbortProblem(ctx, w, err, nil)
+}
+
+// AbortProblem is like AbortJSON with further members in the problem, such
+// as the results of a batch that failed.
+func AbortProblem(ctx context.Context, w http.ResponseWriter, err error, extensions map[string]any) {
 	if err == nil {
 		return
 	}
+	problem := errs.NewProblem(err)
+	if problem.Extensions == nil {
+		problem.Extensions = make(map[string]any, len(extensions)+2)
+	}
+	maps.Copy(problem.Extensions, extensions)
+	traceID, spanID := getTraceInfo(ctx)
+	if traceID != "" {
+		problem.Extensions["trace_id"] = traceID
+		problem.Extensions["span_id"] = spanID
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("error.code", problem.Code))
+
+	level := slog.LevelDebug
+	if problem.Status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	slog.Log(ctx, level, "request failed",
+		slog.String("code", problem.Code),
+		slog.Int("status", problem.Status),
+		slog.Any("error", err),
+	)
+
+	w.Header().Set("X-Trace-ID", traceID)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
+
+func JSON(ctx context.Context, w http.ResponseWriter, status int, message string, data any) {
 	traceID, _ := getTraceInfo(ctx)
 	w.Header().Set("X-Trace-ID", traceID)
 	w.Header().Set("Content-Type", "application/json")
-	status, message := errs.MapHttp(err)
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(response{
 		Message: message,
+		Data:    data,
 	})
 }
 
-func JSON(ctx context.Context, w http.ResponseWriter, status int, message string, data any) {
+// Empty writes a response without a body, such as 304 Not Modified.
+func Empty(ctx context.Context, w http.ResponseWriter, status int) {
+	traceID, _ := getTraceInfo(ctx)
+	w.Header().Set("X-Trace-ID", traceID)
+	w.WriteHeader(status)
+}
+
+// JSONPage is like JSON for one page of a list. nextCursor is omitted on the
+// last page.
+func JSONPage(ctx context.Context, w http.ResponseWriter, status int, message string, data any, nextCursor string) {
 	traceID, _ := getTraceInfo(ctx)
 	w.Header().Set("X-Trace-ID", traceID)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(response{
-		Message: message,
-		Data:    data,
+		Message:    message,
+		Data:       data,
+		NextCursor: nextCursor,
 	})
 }
 
@@ -0,0 +1,83 @@
+// Package instrument traces the operations of a component and records their
+// latency and errors, so that implementations of an interface can leave it to
+// a wrapper.
+package instrument
+
+import (
+	"context"
+	"time"
+
+	"simple_lgtm/pkg/errs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Instrumentation names the spans of one component "<operation><suffix>",
+// such as "GetDataInRepo", and sets attrs on every one of them.
+type Instrumentation struct {
+	suffix string
+	attrs  []attribute.KeyValue
+	// latencyHistogram is labeled by operation and result, "success" or
+	// "error", errorCounter by operation and the code of the error.
+	latencyHistogram prometheus.ObserverVec
+	errorCounter     *prometheus.CounterVec
+	// contextAttrs returns attributes of the request in ctx, if set.
+	contextAttrs func(ctx context.Context) []attribute.KeyValue
+}
+
+func New(suffix string, latencyHistogram prometheus.ObserverVec, errorCounter *prometheus.CounterVec, attrs ...attribute.KeyValue) *Instrumentation {
+	return &Instrumentation{
+		suffix:           suffix,
+		attrs:            attrs,
+		latencyHistogram: latencyHistogram,
+		errorCounter:     errorCounter,
+	}
+}
+
+// WithContext returns a copy of in that also sets the attributes returned by
+// fn for the context of every operation on its span.
+func (in *Instrumentation) WithContext(fn func(ctx context.Context) []attribute.KeyValue) *Instrumentation {
+	copied := *in
+	copied.contextAttrs = fn
+	return &copied
+}
+
+// Call runs fn in a span of operation with attrs. The error of fn is recorded
+// on the span and counted by its code.
+func Call[T any](ctx context.Context, in *Instrumentation, operation string, attrs []attribute.KeyValue, fn func(ctx context.Context) (T, error)) (T, error) {
+	start := time.Now()
+	ctx, span := otel.Tracer("app-tracer").Start(ctx, operation+in.suffix,
+		trace.WithAttributes(in.attrs...), trace.WithAttributes(attrs...))
+	defer span.End()
+	if in.contextAttrs != nil {
+		span.SetAttributes(in.contextAttrs(ctx)...)
+	}
+
+	result, err := fn(ctx)
+
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+		code := errs.Code(err)
+		in.errorCounter.WithLabelValues(operation, code).Inc()
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error.code", code))
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetStatus(codes.Ok, "success")
+	}
+	in.latencyHistogram.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+	return result, err
+}
+
+// Do is like Call for an operation without a result.
+func Do(ctx context.Context, in *Instrumentation, operation string, attrs []attribute.KeyValue, fn func(ctx context.Context) error) error {
+	_, err := Call(ctx, in, operation, attrs, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
@@ -0,0 +1,67 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"simple_lgtm/pkg/errs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCall(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	latencyHistogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"operation", "result"})
+	errorCounter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "errors"}, []string{"operation", "code"})
+	in := New("InTest", latencyHistogram, errorCounter, attribute.String("test.component", "test"))
+
+	t.Run("Success", func(t *testing.T) {
+		recorder.Reset()
+		result, err := Call(context.Background(), in, "Get", []attribute.KeyValue{attribute.String("test.id", "1")}, func(ctx context.Context) (string, error) {
+			return "value", nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "value", result)
+
+		spans := recorder.Ended()
+		if !assert.Len(t, spans, 1) {
+			return
+		}
+		assert.Equal(t, "GetInTest", spans[0].Name())
+		assert.Equal(t, codes.Ok, spans[0].Status().Code)
+		assert.Contains(t, spans[0].Attributes(), attribute.String("test.component", "test"))
+		assert.Contains(t, spans[0].Attributes(), attribute.String("test.id", "1"))
+		assert.Equal(t, 1, testutil.CollectAndCount(latencyHistogram))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		recorder.Reset()
+		err := Do(context.Background(), in, "Delete", nil, func(ctx context.Context) error {
+			return errs.NewNotFound(errors.New("not found"))
+		})
+		assert.True(t, errs.IsNotFound(err))
+
+		spans := recorder.Ended()
+		if !assert.Len(t, spans, 1) {
+			return
+		}
+		assert.Equal(t, "DeleteInTest", spans[0].Name())
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+		assert.Contains(t, spans[0].Attributes(), attribute.String("error.code", "NOT_FOUND"))
+		assert.Len(t, spans[0].Events(), 1)
+		assert.Equal(t, 1.0, testutil.ToFloat64(errorCounter.WithLabelValues("Delete", "NOT_FOUND")))
+	})
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// deltaTemporality is the delta temporality of the OpenTelemetry
+// specification, which keeps up-down counters cumulative.
+func deltaTemporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	switch kind {
+	case sdkmetric.InstrumentKindUpDownCounter, sdkmetric.InstrumentKindObservableUpDownCounter:
+		return metricdata.CumulativeTemporality
+	default:
+		return metricdata.DeltaTemporality
+	}
+}
+
+// deltaProducer turns the cumulative counters and histograms of a producer
+// into deltas since its previous call. Like the delta temporality of the SDK,
+// it keeps gauges and non-monotonic sums as they are. A series is forgotten
+// once a call no longer returns it, so that series of, for example, deleted
+// tenants do not pile up.
+type deltaProducer struct {
+	producer sdkmetric.Producer
+
+	mu   sync.Mutex
+	last map[seriesKey]any
+}
+
+// seriesKey identifies a series across calls to Produce.
+type seriesKey struct {
+	scope string
+	name  string
+	attrs attribute.Distinct
+}
+
+func newDeltaProducer(producer sdkmetric.Producer) *deltaProducer {
+	return &deltaProducer{producer: producer, last: make(map[seriesKey]any)}
+}
+
+func (p *deltaProducer) Produce(ctx context.Context) ([]metricdata.ScopeMetrics, error) {
+	scopes, err := p.producer.Produce(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen := make(map[seriesKey]struct{}, len(p.last))
+	for i := range scopes {
+		scope := scopes[i].Scope.Name
+		for j := range scopes[i].Metrics {
+			m := &scopes[i].Metrics[j]
+			switch data := m.Data.(type) {
+			case metricdata.Sum[float64]:
+				if data.IsMonotonic && data.Temporality == metricdata.CumulativeTemporality {
+					for k := range data.DataPoints {
+						key := seriesKey{scope, m.Name, data.DataPoints[k].Attributes.Equivalent()}
+						seen[key] = struct{}{}
+						p.sumDelta(key, &data.DataPoints[k])
+					}
+					data.Temporality = metricdata.DeltaTemporality
+					m.Data = data
+				}
+			case metricdata.Histogram[float64]:
+				if data.Temporality == metricdata.CumulativeTemporality {
+					for k := range data.DataPoints {
+						key := seriesKey{scope, m.Name, data.DataPoints[k].Attributes.Equivalent()}
+						seen[key] = struct{}{}
+						p.histogramDelta(key, &data.DataPoints[k])
+					}
+					data.Temporality = metricdata.DeltaTemporality
+					m.Data = data
+				}
+			}
+		}
+	}
+	// A failed call may have returned only some of the series, so the others
+	// are kept until a call succeeds.
+	if err == nil {
+		maps.DeleteFunc(p.last, func(key seriesKey, _ any) bool {
+			_, ok := seen[key]
+			return !ok
+		})
+	}
+	return scopes, err
+}
+
+// sumDelta replaces the value of dp with its increase since the last call.
+// After a reset, such as a restart of the process, the value is the increase
+// since the reset.
+func (p *deltaProducer) sumDelta(key seriesKey, dp *metricdata.DataPoint[float64]) {
+	current := *dp
+	if prev, ok := p.last[key].(metricdata.DataPoint[float64]); ok && current.Value >= prev.Value {
+		dp.Value -= prev.Value
+		dp.StartTime = prev.Time
+	}
+	p.last[key] = current
+}
+
+// histogramDelta is sumDelta for histograms. The minimum and maximum are
+// dropped, since those of the interval are unknown.
+func (p *deltaProducer) histogramDelta(key seriesKey, dp *metricdata.HistogramDataPoint[float64]) {
+	current := *dp
+	current.BucketCounts = slices.Clone(dp.BucketCounts)
+	if prev, ok := p.last[key].(metricdata.HistogramDataPoint[float64]); ok && current.Count >= prev.Count && slices.Equal(current.Bounds, prev.Bounds) {
+		dp.Count -= prev.Count
+		dp.Sum -= prev.Sum
+		for i := range dp.BucketCounts {
+			dp.BucketCounts[i] -= prev.BucketCounts[i]
+		}
+		dp.StartTime = prev.Time
+	}
+	dp.Min, dp.Max = metricdata.Extrema[float64]{}, metricdata.Extrema[float64]{}
+	p.last[key] = current
+}
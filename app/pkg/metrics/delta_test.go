@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// fakeProducer returns a counter, a histogram and a gauge of the given
+// cumulative values, all for route, and err.
+type fakeProducer struct {
+	route   string
+	counter float64
+	count   uint64
+	buckets []uint64
+	gauge   float64
+	now     time.Time
+	err     error
+}
+
+func (p *fakeProducer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	attrs := attribute.NewSet(attribute.String("route", p.route))
+	return []metricdata.ScopeMetrics{{
+		Scope: instrumentation.Scope{Name: "bridge"},
+		Metrics: []metricdata.Metrics{
+			{Name: "requests", Data: metricdata.Sum[float64]{
+				Temporality: metricdata.CumulativeTemporality,
+				IsMonotonic: true,
+				DataPoints:  []metricdata.DataPoint[float64]{{Attributes: attrs, Time: p.now, Value: p.counter}},
+			}},
+			{Name: "latency", Data: metricdata.Histogram[float64]{
+				Temporality: metricdata.CumulativeTemporality,
+				DataPoints: []metricdata.HistogramDataPoint[float64]{{
+					Attributes: attrs, Time: p.now, Count: p.count, Sum: float64(p.count),
+					Bounds: []float64{1}, BucketCounts: append([]uint64(nil), p.buckets...),
+				}},
+			}},
+			{Name: "in_flight", Data: metricdata.Gauge[float64]{
+				DataPoints: []metricdata.DataPoint[float64]{{Attributes: attrs, Time: p.now, Value: p.gauge}},
+			}},
+		},
+	}}, p.err
+}
+
+func TestDeltaProducer(t *testing.T) {
+	start := time.Now()
+	fake := &fakeProducer{route: "GET /data/{id}", counter: 5, count: 3, buckets: []uint64{2, 1}, gauge: 4, now: start}
+	producer := newDeltaProducer(fake)
+
+	produce := func() (metricdata.Sum[float64], metricdata.Histogram[float64], metricdata.Gauge[float64]) {
+		scopes, err := producer.Produce(context.Background())
+		require.NoError(t, err)
+		metrics := scopes[0].Metrics
+		return metrics[0].Data.(metricdata.Sum[float64]), metrics[1].Data.(metricdata.Histogram[float64]), metrics[2].Data.(metricdata.Gauge[float64])
+	}
+
+	// The first values are the increase since the series started.
+	sum, histogram, gauge := produce()
+	assert.Equal(t, metricdata.DeltaTemporality, sum.Temporality)
+	assert.Equal(t, 5.0, sum.DataPoints[0].Value)
+	assert.Equal(t, metricdata.DeltaTemporality, histogram.Temporality)
+	assert.Equal(t, uint64(3), histogram.DataPoints[0].Count)
+	assert.Equal(t, 4.0, gauge.DataPoints[0].Value)
+
+	fake.counter, fake.count, fake.buckets, fake.gauge, fake.now = 8, 7, []uint64{5, 2}, 1, start.Add(time.Minute)
+	sum, histogram, gauge = produce()
+	assert.Equal(t, 3.0, sum.DataPoints[0].Value)
+	assert.Equal(t, start, sum.DataPoints[0].StartTime)
+	assert.Equal(t, uint64(4), histogram.DataPoints[0].Count)
+	assert.Equal(t, 4.0, histogram.DataPoints[0].Sum)
+	assert.Equal(t, []uint64{3, 1}, histogram.DataPoints[0].BucketCounts)
+	assert.Equal(t, 1.0, gauge.DataPoints[0].Value)
+
+	// A counter that went down was reset, so all of its value is new.
+	fake.counter, fake.now = 2, start.Add(2*time.Minute)
+	sum, _, _ = produce()
+	assert.Equal(t, 2.0, sum.DataPoints[0].Value)
+
+	t.Run("ForgetsMissingSeries", func(t *testing.T) {
+		fake := &fakeProducer{route: "a", counter: 5, count: 3, buckets: []uint64{2, 1}, now: start}
+		producer := newDeltaProducer(fake)
+		_, err := producer.Produce(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, producer.last, 2)
+
+		// A failed call keeps the series it did not return.
+		fake.route, fake.err = "b", errors.New("partial")
+		_, err = producer.Produce(context.Background())
+		assert.Error(t, err)
+		assert.Len(t, producer.last, 4)
+
+		fake.err = nil
+		_, err = producer.Produce(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, producer.last, 2)
+		b := attribute.NewSet(attribute.String("route", "b"))
+		for key := range producer.last {
+			assert.Equal(t, b.Equivalent(), key.attrs)
+		}
+	})
+}
@@ -1,14 +1,24 @@
 package metrics
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"database/sql"
 
-func Init() (*prometheus.CounterVec, *prometheus.HistogramVec) {
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Init returns the HTTP request metrics, which are labeled by the pattern of
+// the route that served the request rather than by its path: the number of
+// requests by status code and class, such as "2xx", their latency by class,
+// the requests being served and the sizes of request and response bodies by
+// direction.
+func Init() (*prometheus.CounterVec, *prometheus.HistogramVec, *prometheus.GaugeVec, *prometheus.HistogramVec) {
 	requestCounter := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "app_http_requests_total",
 			Help: "Total HTTP requests",
 		},
-		[]string{"method", "path"},
+		[]string{"method", "route", "code", "class"},
 	)
 	latencyHistogram := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -16,8 +26,280 @@ func Init() (*prometheus.CounterVec, *prometheus.HistogramVec) {
 			Help:    "Request latency",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"method", "path"},
+		[]string{"method", "route", "class"},
+	)
+	inFlightGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "app_http_requests_in_flight",
+			Help: "HTTP requests being served",
+		},
+		[]string{"method", "route"},
+	)
+	sizeHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_http_body_size_bytes",
+			Help:    "Size of HTTP request and response bodies",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+		},
+		[]string{"method", "route", "direction"},
+	)
+	prometheus.MustRegister(requestCounter, latencyHistogram, inFlightGauge, sizeHistogram)
+	return requestCounter, latencyHistogram, inFlightGauge, sizeHistogram
+}
+
+// InitDBStats exports the connection pool statistics of db, such as open,
+// idle and in-use connections and the time spent waiting for one.
+func InitDBStats(db *sql.DB, dbName string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, dbName))
+}
+
+// InitPurge returns the number of items removed from the trash, the number of
+// purge runs by result and the duration of a run.
+func InitPurge() (prometheus.Counter, *prometheus.CounterVec, prometheus.Histogram) {
+	purgedCounter := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_purge_items_total",
+			Help: "Total items permanently removed from the trash",
+		},
+	)
+	runCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_purge_runs_total",
+			Help: "Total purge runs",
+		},
+		[]string{"result"},
+	)
+	latencyHistogram := prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "app_purge_duration_seconds",
+			Help:    "Purge run duration",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+	prometheus.MustRegister(purgedCounter, runCounter, latencyHistogram)
+	return purgedCounter, runCounter, latencyHistogram
+}
+
+// InitExpiry returns the number of expired items removed by the repository.
+func InitExpiry() prometheus.Counter {
+	expiredCounter := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_data_expired_total",
+			Help: "Total expired items removed from the repository",
+		},
+	)
+	prometheus.MustRegister(expiredCounter)
+	return expiredCounter
+}
+
+// InitSnapshot returns the duration of snapshot and restore operations by
+// result and the size of the snapshot files they wrote or read.
+func InitSnapshot() (*prometheus.HistogramVec, *prometheus.HistogramVec) {
+	latencyHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_snapshot_duration_seconds",
+			Help:    "Snapshot operation duration",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"operation", "result"},
+	)
+	sizeHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_snapshot_size_bytes",
+			Help:    "Snapshot file size",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+		},
+		[]string{"operation"},
+	)
+	prometheus.MustRegister(latencyHistogram, sizeHistogram)
+	return latencyHistogram, sizeHistogram
+}
+
+// InitBatch returns the number of operations per batch by mode and result.
+func InitBatch() *prometheus.HistogramVec {
+	sizeHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_batch_size",
+			Help:    "Number of operations per batch",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+		},
+		[]string{"mode", "result"},
+	)
+	prometheus.MustRegister(sizeHistogram)
+	return sizeHistogram
+}
+
+// InitCache returns the cache lookups by result and the evicted items by
+// reason.
+func InitCache() (*prometheus.CounterVec, *prometheus.CounterVec) {
+	requestCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_cache_requests_total",
+			Help: "Total number of cache lookups",
+		},
+		[]string{"result"},
+	)
+	evictionCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_cache_evictions_total",
+			Help: "Total number of items removed from the cache",
+		},
+		[]string{"reason"},
+	)
+	prometheus.MustRegister(requestCounter, evictionCounter)
+	return requestCounter, evictionCounter
+}
+
+// InitRepository returns the duration of repository operations by backend,
+// operation and result and their errors by backend, operation and code.
+func InitRepository() (*prometheus.HistogramVec, *prometheus.CounterVec) {
+	latencyHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_repository_duration_seconds",
+			Help:    "Repository operation duration",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"backend", "operation", "result"},
+	)
+	errorCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_repository_errors_total",
+			Help: "Total number of failed repository operations",
+		},
+		[]string{"backend", "operation", "code"},
+	)
+	prometheus.MustRegister(latencyHistogram, errorCounter)
+	return latencyHistogram, errorCounter
+}
+
+// InitService returns the duration of service operations by operation and
+// result and their errors by operation and code.
+func InitService() (*prometheus.HistogramVec, *prometheus.CounterVec) {
+	latencyHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_service_duration_seconds",
+			Help:    "Service operation duration",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"operation", "result"},
+	)
+	errorCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_service_errors_total",
+			Help: "Total number of failed service operations",
+		},
+		[]string{"operation", "code"},
+	)
+	prometheus.MustRegister(latencyHistogram, errorCounter)
+	return latencyHistogram, errorCounter
+}
+
+// InitTenant returns the requests by tenant. Only existing tenants are
+// counted, so the label is bounded by the limit of tenants.
+func InitTenant() *prometheus.CounterVec {
+	requestCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_tenant_requests_total",
+			Help: "Total HTTP requests by tenant",
+		},
+		[]string{"tenant"},
+	)
+	prometheus.MustRegister(requestCounter)
+	return requestCounter
+}
+
+// InitWatch returns the open watches and those that were dropped for falling
+// behind.
+func InitWatch() (prometheus.Gauge, prometheus.Counter) {
+	subscriberGauge := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "app_watch_subscribers",
+			Help: "Number of open watches of the change feed",
+		},
+	)
+	droppedCounter := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_watch_dropped_total",
+			Help: "Total number of watches dropped for falling behind",
+		},
+	)
+	prometheus.MustRegister(subscriberGauge, droppedCounter)
+	return subscriberGauge, droppedCounter
+}
+
+// InitWebhook returns the duration and number of webhook delivery attempts by
+// result, and the deliveries that were dead-lettered by reason.
+func InitWebhook() (*prometheus.HistogramVec, *prometheus.CounterVec, *prometheus.CounterVec) {
+	latencyHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_webhook_delivery_duration_seconds",
+			Help:    "Webhook delivery attempt duration",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+	attemptCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_webhook_attempts_total",
+			Help: "Total number of webhook delivery attempts",
+		},
+		[]string{"result"},
+	)
+	failureCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_webhook_failures_total",
+			Help: "Total number of dead-lettered webhook deliveries",
+		},
+		[]string{"reason"},
+	)
+	prometheus.MustRegister(latencyHistogram, attemptCounter, failureCounter)
+	return latencyHistogram, attemptCounter, failureCounter
+}
+
+// InitBlob returns the bytes of blobs uploaded ("in") and downloaded ("out").
+func InitBlob() *prometheus.CounterVec {
+	bytesCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_blob_bytes_total",
+			Help: "Total bytes of blobs transferred",
+		},
+		[]string{"direction"},
+	)
+	prometheus.MustRegister(bytesCounter)
+	return bytesCounter
+}
+
+// InitShutdown returns the duration of every step of a shutdown by result.
+func InitShutdown() *prometheus.HistogramVec {
+	shutdownHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_shutdown_step_duration_seconds",
+			Help:    "Duration of a step of the shutdown",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"step", "result"},
+	)
+	prometheus.MustRegister(shutdownHistogram)
+	return shutdownHistogram
+}
+
+// InitHealth returns whether each health check passed the last time it ran,
+// as 1 or 0, and how long that run took.
+func InitHealth() (*prometheus.GaugeVec, *prometheus.GaugeVec) {
+	statusGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "app_health_check_status",
+			Help: "Whether the health check passed the last time it ran",
+		},
+		[]string{"check"},
+	)
+	durationGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "app_health_check_duration_seconds",
+			Help: "Duration of the last run of the health check",
+		},
+		[]string{"check"},
 	)
-	prometheus.MustRegister(requestCounter, latencyHistogram)
-	return requestCounter, latencyHistogram
+	prometheus.MustRegister(statusGauge, durationGauge)
+	return statusGauge, durationGauge
 }
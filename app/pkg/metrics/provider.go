@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	prometheusbridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const (
+	// ExporterPrometheus serves the metrics of the default Prometheus
+	// registry for scraping.
+	ExporterPrometheus = "prometheus"
+	// ExporterOTLP pushes the metrics through the OpenTelemetry SDK.
+	ExporterOTLP = "otlp"
+)
+
+type ProviderOptions struct {
+	// Exporter is ExporterPrometheus or ExporterOTLP.
+	Exporter string
+	// Protocol of the OTLP exporter, "http/protobuf" or "grpc". The endpoint
+	// is configured by OTEL_EXPORTER_OTLP_METRICS_ENDPOINT or
+	// OTEL_EXPORTER_OTLP_ENDPOINT.
+	Protocol string
+	// Interval between two pushes.
+	Interval time.Duration
+	// Temporality is "cumulative" or "delta".
+	Temporality string
+}
+
+// InitProvider sets the global MeterProvider of OpenTelemetry and returns its
+// shutdown, which pushes the metrics one last time.
+//
+// With ExporterOTLP, the Prometheus instruments of this package are read from
+// the default registry by the SDK on every push, together with the
+// instruments of OpenTelemetry libraries such as otelhttp. With
+// ExporterPrometheus the global MeterProvider is left as is and the shutdown
+// does nothing.
+func InitProvider(ctx context.Context, appName string, opts ProviderOptions) (func(context.Context) error, error) {
+	switch opts.Exporter {
+	case ExporterPrometheus:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("unknown metrics exporter %q", opts.Exporter)
+	}
+
+	var temporality sdkmetric.TemporalitySelector
+	switch opts.Temporality {
+	case "cumulative":
+		temporality = sdkmetric.DefaultTemporalitySelector
+	case "delta":
+		temporality = deltaTemporality
+	default:
+		return nil, fmt.Errorf("unknown metrics temporality %q", opts.Temporality)
+	}
+
+	var exporter sdkmetric.Exporter
+	var err error
+	switch opts.Protocol {
+	case "http/protobuf":
+		exporter, err = otlpmetrichttp.New(ctx, otlpmetrichttp.WithInsecure(), otlpmetrichttp.WithTemporalitySelector(temporality))
+	case "grpc":
+		exporter, err = otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithInsecure(), otlpmetricgrpc.WithTemporalitySelector(temporality))
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q", opts.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metrics exporter: %w", err)
+	}
+
+	// Prometheus instruments are cumulative, so the bridge has to compute
+	// the deltas itself.
+	var producer sdkmetric.Producer = prometheusbridge.NewMetricProducer()
+	if opts.Temporality == "delta" {
+		producer = newDeltaProducer(producer)
+	}
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter,
+			sdkmetric.WithInterval(opts.Interval),
+			sdkmetric.WithProducer(producer),
+		)),
+		sdkmetric.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(appName),
+		)),
+	)
+
+	otel.SetMeterProvider(mp)
+	return mp.Shutdown, nil
+}
@@ -0,0 +1,69 @@
+// Package shutdown stops the components of the process in order, so that each
+// step can still rely on the components stopped after it.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Step stops one component. It should return once ctx is done, even if the
+// component did not stop.
+type Step func(ctx context.Context) error
+
+type namedStep struct {
+	name string
+	fn   Step
+}
+
+// Sequence runs the steps added to it in the order they were added.
+type Sequence struct {
+	steps []namedStep
+	// durationHistogram is labeled by step and result, "success" or "error".
+	durationHistogram prometheus.ObserverVec
+}
+
+func NewSequence(durationHistogram prometheus.ObserverVec) *Sequence {
+	return &Sequence{durationHistogram: durationHistogram}
+}
+
+// Add appends the step name to s.
+func (s *Sequence) Add(name string, fn Step) {
+	s.steps = append(s.steps, namedStep{name: name, fn: fn})
+}
+
+// Run runs every step of s, including those after a step that failed, since
+// a component that was not stopped should not keep the others from flushing
+// their state. All steps share the deadline of ctx. It returns the errors of
+// the steps that failed.
+func (s *Sequence) Run(ctx context.Context) error {
+	start := time.Now()
+	slog.InfoContext(ctx, "shutting down", slog.Int("steps", len(s.steps)))
+
+	var errList []error
+	for _, step := range s.steps {
+		stepStart := time.Now()
+		slog.InfoContext(ctx, "shutdown step started", slog.String("step", step.name))
+
+		err := step.fn(ctx)
+		duration := time.Since(stepStart)
+		result := "success"
+		if err != nil {
+			result = "error"
+			errList = append(errList, fmt.Errorf("%s: %w", step.name, err))
+			slog.ErrorContext(ctx, "shutdown step failed", slog.String("step", step.name), slog.Duration("duration", duration), slog.Any("error", err))
+		} else {
+			slog.InfoContext(ctx, "shutdown step finished", slog.String("step", step.name), slog.Duration("duration", duration))
+		}
+		s.durationHistogram.WithLabelValues(step.name, result).Observe(duration.Seconds())
+	}
+
+	err := errors.Join(errList...)
+	slog.InfoContext(ctx, "shutdown finished", slog.Duration("duration", time.Since(start)), slog.Bool("clean", err == nil))
+	return err
+}
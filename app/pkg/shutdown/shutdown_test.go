@@ -0,0 +1,39 @@
+package shutdown_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"simple_lgtm/pkg/shutdown"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequence(t *testing.T) {
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_shutdown_step_duration_seconds"}, []string{"step", "result"})
+	seq := shutdown.NewSequence(histogram)
+
+	var ran []string
+	errClose := errors.New("close failed")
+	seq.Add("http", func(context.Context) error {
+		ran = append(ran, "http")
+		return nil
+	})
+	seq.Add("repository", func(context.Context) error {
+		ran = append(ran, "repository")
+		return errClose
+	})
+	seq.Add("tracer", func(context.Context) error {
+		ran = append(ran, "tracer")
+		return nil
+	})
+
+	err := seq.Run(context.Background())
+	assert.Equal(t, []string{"http", "repository", "tracer"}, ran)
+	assert.ErrorIs(t, err, errClose)
+	assert.ErrorContains(t, err, "repository: close failed")
+	assert.Equal(t, 3, testutil.CollectAndCount(histogram))
+}